@@ -0,0 +1,113 @@
+package plugin
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+
+	gs "github.com/snowflakedb/gosnowflake"
+)
+
+const (
+	authBasic         = "basic"
+	authKeypair       = "keypair"
+	authOAuth         = "oauth"
+	authOAuthPassThru = "oauthPassThru"
+	authPAT           = "pat"
+	authOkta          = "okta"
+)
+
+// validateAuth checks that the datasource has what its authentication type needs, and prepares
+// the private key, the OAuth token source and the Okta URL of the authentication.
+// The error tells which setting is missing or invalid.
+func (dm *datasourceModel) validateAuth() error {
+	if dm.Authtype == "" {
+		dm.Authtype = authBasic
+	}
+
+	if dm.Account == "" {
+		return fmt.Errorf("the account is required")
+	}
+
+	var err error
+
+	switch dm.Authtype {
+	case authBasic:
+		return requireSettings(dm.Authtype, "user", dm.User, "password", dm.Password)
+	case authKeypair:
+		if err := requireSettings(dm.Authtype, "user", dm.User, "private key", dm.PrivateKey); err != nil {
+			return err
+		}
+		dm.privateKey, err = parsePrivateKey(dm.PrivateKey, dm.PrivateKeyPassphrase)
+		if err != nil {
+			return fmt.Errorf("failed to parse the private key: %v", err)
+		}
+	case authOAuth:
+		dm.oauthTokens, err = newOAuthTokenSource(dm.OAuth, dm.OAuthClientSecret, dm.OAuthRefreshToken)
+		if err != nil {
+			return fmt.Errorf("failed to configure the OAuth: %v", err)
+		}
+	case authOAuthPassThru:
+		// Grafana forwards the OAuth identity of the signed-in user only if jsonData.oauthPassThru is set.
+		if !dm.OAuthPassThru {
+			return fmt.Errorf("the authentication type [%s] needs oauthPassThru to be enabled", dm.Authtype)
+		}
+	case authPAT:
+		return requireSettings(dm.Authtype, "user", dm.User, "programmatic access token", dm.ProgrammaticAccessToken)
+	case authOkta:
+		if err := requireSettings(dm.Authtype, "user", dm.User, "password", dm.Password, "Okta URL", dm.OktaURL); err != nil {
+			return err
+		}
+		dm.oktaURL, err = url.Parse(dm.OktaURL)
+		if err != nil || dm.oktaURL.Scheme != "https" || dm.oktaURL.Host == "" {
+			return fmt.Errorf("the Okta URL [%s] must be an https URL, such as https://example.okta.com", dm.OktaURL)
+		}
+	default:
+		return fmt.Errorf("unsupported authentication type: %s", dm.Authtype)
+	}
+
+	return nil
+}
+
+// requireSettings returns an error naming the first empty setting. settings are pairs of a name and a value.
+func requireSettings(authtype string, settings ...string) error {
+	for i := 0; i+1 < len(settings); i += 2 {
+		if settings[i+1] == "" {
+			return fmt.Errorf("the authentication type [%s] needs the %s", authtype, settings[i])
+		}
+	}
+	return nil
+}
+
+// loginError describes the step of the login to Snowflake where err happened. The Okta authentication
+// signs in to Okta before Snowflake, and its errors tell which request to Okta failed.
+func loginError(authtype string, err error) error {
+	step := "Snowflake login"
+	hint := loginHints[authtype]
+
+	var sfErr *gs.SnowflakeError
+	if errors.As(err, &sfErr) {
+		switch sfErr.Number {
+		case gs.ErrCodeIdpConnectionError:
+			step = "Okta URL verification"
+			hint = "the Okta URL must be the one of the identity provider of the Snowflake account"
+		case gs.ErrFailedToGetSSO:
+			step = "Okta sign-in"
+			hint = "Okta rejected the user and the password, or the user needs MFA, which is not supported"
+		case gs.ErrFailedToAuthSAML, gs.ErrCodeSSOURLNotMatch:
+			step = "Okta SAML response"
+			hint = "Okta did not give a SAML response for the Snowflake account"
+		}
+	}
+
+	return fmt.Errorf("the %s of the %s authentication failed, %s: %v", step, authtype, hint, err)
+}
+
+var loginHints = map[string]string{
+	authBasic:         "check the user and the password",
+	authKeypair:       "check that the public key of the private key is set to the user",
+	authOAuth:         "check that the security integration of Snowflake accepts the access tokens of the token URL",
+	authOAuthPassThru: "check that the security integration of Snowflake accepts the access token of the signed-in user",
+	authPAT:           "check that the programmatic access token is of the user, and it has not expired",
+	authOkta:          "check that Snowflake accepts the SAML response of the user",
+}
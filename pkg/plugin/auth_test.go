@@ -0,0 +1,118 @@
+package plugin
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	gs "github.com/snowflakedb/gosnowflake"
+)
+
+func TestBuildDatasourceModelAuth(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	privateKey := encodePEM("RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(rsaKey))
+
+	tests := []struct {
+		jsonData string
+		secrets  map[string]string
+		expected string
+	}{
+		{`{"account": "a", "user": "u"}`, map[string]string{"password": "p"}, ""},
+		{`{"account": "a", "authtype": "basic", "user": "u"}`, nil, "needs the password"},
+		{`{"authtype": "basic", "user": "u"}`, map[string]string{"password": "p"}, "account is required"},
+		{`{"account": "a", "authtype": "keypair", "user": "u"}`, map[string]string{"privatekey": privateKey}, ""},
+		{`{"account": "a", "authtype": "keypair"}`, map[string]string{"privatekey": privateKey}, "needs the user"},
+		{`{"account": "a", "authtype": "keypair", "user": "u"}`, map[string]string{"privatekey": "not a key"}, "failed to parse the private key"},
+		{`{"account": "a", "authtype": "oauth", "oauth": {"tokenUrl": "https://idp.example.com/token", "clientId": "c"}}`, map[string]string{"oauthClientSecret": "s"}, ""},
+		{`{"account": "a", "authtype": "oauth", "oauth": {"clientId": "c"}}`, map[string]string{"oauthClientSecret": "s"}, "token URL"},
+		{`{"account": "a", "authtype": "oauthPassThru", "oauthPassThru": true}`, nil, ""},
+		{`{"account": "a", "authtype": "oauthPassThru"}`, nil, "needs oauthPassThru"},
+		{`{"account": "a", "authtype": "pat", "user": "u"}`, map[string]string{"programmaticAccessToken": "t"}, ""},
+		{`{"account": "a", "authtype": "pat", "user": "u"}`, map[string]string{"password": "p"}, "needs the programmatic access token"},
+		{`{"account": "a", "authtype": "okta", "user": "u", "oktaUrl": "https://example.okta.com"}`, map[string]string{"password": "p"}, ""},
+		{`{"account": "a", "authtype": "okta", "user": "u"}`, map[string]string{"password": "p"}, "needs the Okta URL"},
+		{`{"account": "a", "authtype": "okta", "user": "u", "oktaUrl": "example.okta.com"}`, map[string]string{"password": "p"}, "must be an https URL"},
+		{`{"account": "a", "authtype": "externalbrowser", "user": "u"}`, nil, "unsupported authentication type"},
+	}
+
+	for _, tt := range tests {
+		dm, err := buildDatasourceModel(&backend.DataSourceInstanceSettings{
+			JSONData:                []byte(tt.jsonData),
+			DecryptedSecureJSONData: tt.secrets,
+		})
+
+		if err != nil {
+			t.Errorf("failed to build the datasource model of %s: %v", tt.jsonData, err)
+			continue
+		}
+
+		if tt.expected == "" {
+			if dm.authErr != nil {
+				t.Errorf("expected a valid authentication from %s, but got [%v]", tt.jsonData, dm.authErr)
+			}
+			continue
+		}
+
+		if dm.authErr == nil || !strings.Contains(dm.authErr.Error(), tt.expected) {
+			t.Errorf("expected an error with [%s] from %s, but got [%v]", tt.expected, tt.jsonData, dm.authErr)
+		}
+	}
+}
+
+func TestCheckHealthInvalidAuth(t *testing.T) {
+	instance, err := NewDatasource(context.Background(), backend.DataSourceInstanceSettings{
+		JSONData:                []byte(`{"account": "a", "authtype": "keypair", "user": "u"}`),
+		DecryptedSecureJSONData: map[string]string{"privatekey": "not a key"},
+	})
+	if err != nil {
+		t.Fatalf("expected the datasource to be created with an invalid authentication, but got [%v]", err)
+	}
+	ds := instance.(*Datasource)
+	defer ds.Dispose()
+
+	result, err := ds.CheckHealth(context.Background(), &backend.CheckHealthRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Status != backend.HealthStatusError || !strings.HasPrefix(result.Message, "failed to validate the authentication: failed to parse the private key") {
+		t.Errorf("expected the error of the private key, but got [%s]", result.Message)
+	}
+
+	response, err := ds.QueryData(context.Background(), &backend.QueryDataRequest{
+		Queries: []backend.DataQuery{{RefID: "A", JSON: []byte(`{"queryText": "SELECT 1"}`)}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r := response.Responses["A"]; r.Error == nil || !strings.Contains(r.Error.Error(), "failed to parse the private key") {
+		t.Errorf("expected the query to fail with the error of the private key, but got [%v]", r.Error)
+	}
+}
+
+func TestLoginError(t *testing.T) {
+	tests := []struct {
+		authtype string
+		err      error
+		expected string
+	}{
+		{authBasic, &gs.SnowflakeError{Number: 390100, Message: "Incorrect username or password was specified."}, "the Snowflake login of the basic authentication failed, check the user and the password"},
+		{authOkta, &gs.SnowflakeError{Number: gs.ErrCodeIdpConnectionError}, "the Okta URL verification of the okta authentication failed"},
+		{authOkta, &gs.SnowflakeError{Number: gs.ErrFailedToGetSSO}, "the Okta sign-in of the okta authentication failed"},
+		{authOkta, &gs.SnowflakeError{Number: gs.ErrFailedToAuthSAML}, "the Okta SAML response of the okta authentication failed"},
+		{authPAT, errors.New("390100"), "the Snowflake login of the pat authentication failed, check that the programmatic access token"},
+	}
+
+	for _, tt := range tests {
+		if err := loginError(tt.authtype, tt.err); !strings.HasPrefix(err.Error(), tt.expected) {
+			t.Errorf("expected [%s...], but got [%v]", tt.expected, err)
+		}
+	}
+}
@@ -0,0 +1,604 @@
+package plugin
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	sf "github.com/nexon/sunflake/pkg/snowflake"
+)
+
+const editorModeBuilder = "builder"
+
+// The types below are the models of the builder mode of the query editor, as in src/types.ts.
+
+type snowflakeObjectJson struct {
+	Database string
+	Schema   string
+	Table    string
+}
+
+type tableColumnJson struct {
+	Name string
+	Type string
+}
+
+type selectColumnJson struct {
+	Column  *tableColumnJson
+	Alias   string
+	AggFunc string
+}
+
+type orderByColumnJson struct {
+	Name      string
+	Limit     int
+	SortOrder string
+}
+
+type queryBuilderJson struct {
+	HasFilter      bool
+	HasGroupBy     bool
+	HasOrderBy     bool
+	SelectColumns  []selectColumnJson
+	WhereJsonTree  *filterTree
+	GroupByColumns []string
+	OrderByColumn  *orderByColumnJson
+}
+
+type timeSeriesJson struct {
+	TimeColumn      *tableColumnJson
+	Interval        int
+	TimeUnit        string
+	FillMissing     string
+	LineIdentifiers []string
+	Metrics         []selectColumnJson
+	RowLimit        int
+	FilterJsonTree  *filterTree
+}
+
+// filterTree is a JsonTree of react-awesome-query-builder, which is a group of rules and groups.
+type filterTree struct {
+	Type       string
+	Properties filterProperties
+	Children1  filterChildren
+}
+
+type filterProperties struct {
+	Conjunction string
+	Not         bool
+	Field       string
+	Operator    string
+	Value       []any
+	ValueSrc    []string
+	ValueType   []string
+}
+
+// filterChildren are the children of a group, which are either an array or an object keyed by their ids.
+type filterChildren []filterTree
+
+func (c *filterChildren) UnmarshalJSON(b []byte) error {
+	b = bytes.TrimSpace(b)
+	if len(b) == 0 || b[0] != '{' {
+		return json.Unmarshal(b, (*[]filterTree)(c))
+	}
+
+	// The order of the keys is kept, so that the same tree always generates the same SQL.
+	dec := json.NewDecoder(bytes.NewReader(b))
+	if _, err := dec.Token(); err != nil {
+		return err
+	}
+
+	for dec.More() {
+		if _, err := dec.Token(); err != nil {
+			return err
+		}
+
+		var child filterTree
+		if err := dec.Decode(&child); err != nil {
+			return err
+		}
+		*c = append(*c, child)
+	}
+
+	return nil
+}
+
+var aggregateFuncs = map[string]bool{
+	"AVG":   true,
+	"COUNT": true,
+	"MAX":   true,
+	"MIN":   true,
+	"SUM":   true,
+}
+
+var timeUnits = map[string]bool{
+	"y": true,
+	"M": true,
+	"w": true,
+	"d": true,
+	"h": true,
+	"m": true,
+	"s": true,
+}
+
+// filterOperators are the operators of the filter rules which ruleSQL supports. They are exactly the operators
+// offered by the query builder of the frontend, FILTER_OPERATORS in src/components/grafana/AwesomeQueryBuilder.tsx.
+var filterOperators = []string{
+	"equal", "not_equal", "less", "less_or_equal", "greater", "greater_or_equal",
+	"like", "not_like", "starts_with", "ends_with", "between", "not_between",
+	"is_empty", "is_not_empty", "is_null", "is_not_null",
+	"select_equals", "select_not_equals", "select_any_in", "select_not_any_in", "macros",
+}
+
+var comparisonOperators = map[string]string{
+	"equal":             "=",
+	"not_equal":         "<>",
+	"less":              "<",
+	"less_or_equal":     "<=",
+	"greater":           ">",
+	"greater_or_equal":  ">=",
+	"select_equals":     "=",
+	"select_not_equals": "<>",
+}
+
+const filterMacroTimeFilter = "timeFilter"
+
+// builderSQL generates the SQL of a query made in the builder mode, in the same way as the query editor.
+// The SQL still has macros, such as $__timeGroup, to be evaluated.
+func (qj *queryJson) builderSQL() (string, error) {
+	if qj.DataFormat == "table" {
+		return buildTableSQL(qj.SnowflakeObject, qj.QueryBuilder)
+	}
+
+	return buildTimeSeriesSQL(qj.SnowflakeObject, qj.TimeSeries)
+}
+
+// buildTableSQL generates the SQL of the builder for the table format.
+func buildTableSQL(obj *snowflakeObjectJson, qb *queryBuilderJson) (string, error) {
+	if qb == nil {
+		qb = &queryBuilderJson{}
+	}
+
+	from, err := fromClause(obj)
+	if err != nil {
+		return "", err
+	}
+
+	var cols []string
+	for _, c := range qb.SelectColumns {
+		if c.Column == nil || c.Column.Name == "" {
+			continue
+		}
+
+		col, err := selectExpr(c, "")
+		if err != nil {
+			return "", err
+		}
+		cols = append(cols, col)
+	}
+
+	var sb strings.Builder
+	if len(cols) == 0 {
+		sb.WriteString("SELECT *")
+	} else {
+		sb.WriteString("SELECT " + strings.Join(cols, ", "))
+	}
+	sb.WriteString(from)
+
+	if qb.HasFilter {
+		where, err := qb.WhereJsonTree.sql()
+		if err != nil {
+			return "", fmt.Errorf("failed to build the filter: %v", err)
+		}
+		if where != "" {
+			sb.WriteString(" WHERE " + where)
+		}
+	}
+
+	if qb.HasGroupBy {
+		if groupBy := quoteIdentifiers(qb.GroupByColumns); len(groupBy) > 0 {
+			sb.WriteString(" GROUP BY " + strings.Join(groupBy, ", "))
+		}
+	}
+
+	if qb.HasOrderBy && qb.OrderByColumn != nil {
+		ob := qb.OrderByColumn
+		if ob.Name != "" {
+			order, err := orderByExpr(ob, qb.SelectColumns)
+			if err != nil {
+				return "", err
+			}
+			sb.WriteString(" ORDER BY " + order)
+		}
+
+		if ob.Limit > 0 {
+			sb.WriteString(" LIMIT " + strconv.Itoa(ob.Limit))
+		}
+	}
+
+	return sb.String(), nil
+}
+
+// buildTimeSeriesSQL generates the SQL of the builder for the time series format,
+// which groups the metrics by $__timeGroup and the line identifiers.
+func buildTimeSeriesSQL(obj *snowflakeObjectJson, ts *timeSeriesJson) (string, error) {
+	if ts == nil || ts.TimeColumn == nil || ts.TimeColumn.Name == "" {
+		return "", fmt.Errorf("the time column is not selected")
+	}
+
+	if ts.Interval <= 0 {
+		return "", fmt.Errorf("the interval [%d] must be a positive number", ts.Interval)
+	}
+
+	if !timeUnits[ts.TimeUnit] {
+		return "", fmt.Errorf("unknown time unit [%s]", ts.TimeUnit)
+	}
+
+	from, err := fromClause(obj)
+	if err != nil {
+		return "", err
+	}
+
+	timeCol := sf.QuoteIdentifier(ts.TimeColumn.Name)
+	timeGroup := fmt.Sprintf("$__timeGroup(%s, '%d%s'", timeCol, ts.Interval, ts.TimeUnit)
+	if ts.FillMissing != "" {
+		if _, err := parseFillMissing(ts.FillMissing); err != nil {
+			return "", err
+		}
+		timeGroup += ", " + ts.FillMissing
+	}
+	timeGroup += `) AS "time"`
+
+	lineIds := quoteIdentifiers(ts.LineIdentifiers)
+
+	var metrics []string
+	for _, m := range ts.Metrics {
+		if m.Column == nil || m.Column.Name == "" {
+			continue
+		}
+
+		metric, err := selectExpr(m, "MAX")
+		if err != nil {
+			return "", err
+		}
+		metrics = append(metrics, metric)
+	}
+
+	if len(metrics) == 0 {
+		return "", fmt.Errorf("no metric column is selected")
+	}
+
+	filter, err := ts.FilterJsonTree.sql()
+	if err != nil {
+		return "", fmt.Errorf("failed to build the filter: %v", err)
+	}
+
+	var sb strings.Builder
+	sb.WriteString("SELECT " + strings.Join(append(append([]string{timeGroup}, lineIds...), metrics...), ", "))
+	sb.WriteString(from)
+	sb.WriteString(fmt.Sprintf(" WHERE $__timeFilter(%s)", timeCol))
+	if filter != "" {
+		sb.WriteString(" AND " + filter)
+	}
+	sb.WriteString(" GROUP BY " + strings.Join(append([]string{`"time"`}, lineIds...), ", "))
+	sb.WriteString(` ORDER BY "time"`)
+	if ts.RowLimit > 0 {
+		sb.WriteString(" LIMIT " + strconv.Itoa(ts.RowLimit))
+	}
+
+	return sb.String(), nil
+}
+
+func fromClause(obj *snowflakeObjectJson) (string, error) {
+	if obj == nil || obj.Table == "" {
+		return "", fmt.Errorf("the table is not selected")
+	}
+
+	var names []string
+	for _, name := range []string{obj.Database, obj.Schema, obj.Table} {
+		if name != "" {
+			names = append(names, sf.QuoteIdentifier(name))
+		}
+	}
+
+	return " FROM " + strings.Join(names, "."), nil
+}
+
+// selectExpr returns the column with its aggregate function and alias. defaultFunc is used when
+// no aggregate function is selected.
+func selectExpr(c selectColumnJson, defaultFunc string) (string, error) {
+	expr, err := aggregateExpr(c.Column.Name, c.AggFunc, defaultFunc)
+	if err != nil {
+		return "", err
+	}
+
+	if c.Alias != "" {
+		expr += " AS " + sf.QuoteIdentifier(c.Alias)
+	}
+
+	return expr, nil
+}
+
+func aggregateExpr(column string, aggFunc string, defaultFunc string) (string, error) {
+	if aggFunc == "" {
+		aggFunc = defaultFunc
+	}
+
+	if aggFunc == "" {
+		return sf.QuoteIdentifier(column), nil
+	}
+
+	aggFunc = strings.ToUpper(aggFunc)
+	if !aggregateFuncs[aggFunc] {
+		return "", fmt.Errorf("unknown aggregate function [%s]", aggFunc)
+	}
+
+	return fmt.Sprintf("%s(%s)", aggFunc, sf.QuoteIdentifier(column)), nil
+}
+
+// orderByExpr returns the ORDER BY expression. The query editor names a selected column with an
+// aggregate function as it is written in SQL, such as "AVG(price)".
+func orderByExpr(ob *orderByColumnJson, cols []selectColumnJson) (string, error) {
+	expr := sf.QuoteIdentifier(ob.Name)
+	for _, c := range cols {
+		if c.Column != nil && c.AggFunc != "" && ob.Name == fmt.Sprintf("%s(%s)", c.AggFunc, c.Column.Name) {
+			var err error
+			if expr, err = aggregateExpr(c.Column.Name, c.AggFunc, ""); err != nil {
+				return "", err
+			}
+			break
+		}
+	}
+
+	switch sortOrder := strings.ToUpper(ob.SortOrder); sortOrder {
+	case "":
+		return expr, nil
+	case "ASC", "DESC":
+		return expr + " " + sortOrder, nil
+	default:
+		return "", fmt.Errorf("unknown sort order [%s]", ob.SortOrder)
+	}
+}
+
+func quoteIdentifiers(names []string) []string {
+	var quoted []string
+	for _, name := range names {
+		if name != "" {
+			quoted = append(quoted, sf.QuoteIdentifier(name))
+		}
+	}
+	return quoted
+}
+
+// sql returns the condition of the tree, or "" if it has no complete rule.
+// A rule which is not completed in the query editor is skipped as the query editor does.
+func (t *filterTree) sql() (string, error) {
+	if t == nil {
+		return "", nil
+	}
+
+	switch t.Type {
+	case "group", "":
+		return t.groupSQL()
+	case "rule":
+		return t.ruleSQL()
+	default:
+		return "", fmt.Errorf("unsupported type of the filter [%s]", t.Type)
+	}
+}
+
+func (t *filterTree) groupSQL() (string, error) {
+	var conds []string
+	for i := range t.Children1 {
+		cond, err := t.Children1[i].sql()
+		if err != nil {
+			return "", err
+		}
+		if cond != "" {
+			conds = append(conds, cond)
+		}
+	}
+
+	if len(conds) == 0 {
+		return "", nil
+	}
+
+	conjunction := strings.ToUpper(t.Properties.Conjunction)
+	switch conjunction {
+	case "":
+		conjunction = "AND"
+	case "AND", "OR":
+	default:
+		return "", fmt.Errorf("unknown conjunction [%s]", t.Properties.Conjunction)
+	}
+
+	cond := strings.Join(conds, " "+conjunction+" ")
+	if len(conds) > 1 {
+		cond = "(" + cond + ")"
+	}
+
+	if t.Properties.Not {
+		return "NOT " + cond, nil
+	}
+
+	return cond, nil
+}
+
+func (t *filterTree) ruleSQL() (string, error) {
+	p := t.Properties
+	if p.Field == "" || p.Operator == "" {
+		return "", nil
+	}
+
+	field := sf.QuoteIdentifier(p.Field)
+
+	switch p.Operator {
+	case "is_null":
+		return field + " IS NULL", nil
+	case "is_not_null":
+		return field + " IS NOT NULL", nil
+	case "is_empty":
+		return fmt.Sprintf("(%s IS NULL OR %s = '')", field, field), nil
+	case "is_not_empty":
+		return fmt.Sprintf("(%s IS NOT NULL AND %s <> '')", field, field), nil
+	}
+
+	if len(p.Value) == 0 || p.Value[0] == nil {
+		return "", nil
+	}
+
+	switch p.Operator {
+	case "like", "not_like", "starts_with", "ends_with":
+		s, ok := p.Value[0].(string)
+		if !ok {
+			return "", fmt.Errorf("the value of [%s] must be a string", p.Operator)
+		}
+		return likeSQL(field, p.Operator, s), nil
+	case "between", "not_between":
+		if len(p.Value) < 2 || p.Value[1] == nil {
+			return "", nil
+		}
+		low, err := p.valueSQL(0)
+		if err != nil {
+			return "", err
+		}
+		high, err := p.valueSQL(1)
+		if err != nil {
+			return "", err
+		}
+		op := "BETWEEN"
+		if p.Operator == "not_between" {
+			op = "NOT BETWEEN"
+		}
+		return fmt.Sprintf("%s %s %s AND %s", field, op, low, high), nil
+	case "select_any_in", "select_not_any_in":
+		values, err := p.listSQL()
+		if err != nil {
+			return "", err
+		}
+		op := "IN"
+		if p.Operator == "select_not_any_in" {
+			op = "NOT IN"
+		}
+		return fmt.Sprintf("%s %s (%s)", field, op, strings.Join(values, ", ")), nil
+	case "macros":
+		if p.Value[0] != filterMacroTimeFilter {
+			return "", fmt.Errorf("unknown macro of the filter [%v]", p.Value[0])
+		}
+		return fmt.Sprintf("$__timeFilter(%s)", field), nil
+	}
+
+	op, found := comparisonOperators[p.Operator]
+	if !found {
+		return "", fmt.Errorf("unsupported operator of the filter [%s]", p.Operator)
+	}
+
+	value, err := p.valueSQL(0)
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("%s %s %s", field, op, value), nil
+}
+
+// likeSQL escapes the wildcards in the value, since Snowflake has no default escape character.
+func likeSQL(field string, operator string, value string) string {
+	value = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(value)
+
+	var op, pattern string
+	switch operator {
+	case "like":
+		op, pattern = "LIKE", "%"+value+"%"
+	case "not_like":
+		op, pattern = "NOT LIKE", "%"+value+"%"
+	case "starts_with":
+		op, pattern = "LIKE", value+"%"
+	case "ends_with":
+		op, pattern = "LIKE", "%"+value
+	}
+
+	return fmt.Sprintf(`%s %s %s ESCAPE '\\'`, field, op, sf.QuoteLiteral(pattern))
+}
+
+// listSQL returns the values of IN. A string value is a comma-separated list, which is how
+// a multi-value template variable is given.
+func (p filterProperties) listSQL() ([]string, error) {
+	var items []any
+	switch v := p.Value[0].(type) {
+	case []any:
+		items = v
+	case string:
+		for _, s := range strings.Split(v, ",") {
+			items = append(items, s)
+		}
+	default:
+		items = []any{v}
+	}
+
+	if len(items) == 0 {
+		return nil, fmt.Errorf("the value of [%s] must not be empty", p.Operator)
+	}
+
+	values := make([]string, len(items))
+	for i, item := range items {
+		value, err := literalSQL(item, p.valueType(0))
+		if err != nil {
+			return nil, err
+		}
+		values[i] = value
+	}
+
+	return values, nil
+}
+
+func (p filterProperties) valueSQL(i int) (string, error) {
+	if i < len(p.ValueSrc) && p.ValueSrc[i] == "field" {
+		s, ok := p.Value[i].(string)
+		if !ok || s == "" {
+			return "", fmt.Errorf("the field of the value must be a name, but got [%v]", p.Value[i])
+		}
+		return sf.QuoteIdentifier(s), nil
+	}
+
+	return literalSQL(p.Value[i], p.valueType(i))
+}
+
+func (p filterProperties) valueType(i int) string {
+	if i < len(p.ValueType) {
+		return p.ValueType[i]
+	}
+	return ""
+}
+
+// literalSQL returns the value as a SQL literal. A number or a boolean in a string is checked
+// so that it cannot be anything else.
+func literalSQL(v any, valueType string) (string, error) {
+	switch v := v.(type) {
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	case bool:
+		return strings.ToUpper(strconv.FormatBool(v)), nil
+	case string:
+		switch valueType {
+		case "number":
+			f, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+			if err != nil || math.IsNaN(f) || math.IsInf(f, 0) {
+				return "", fmt.Errorf("the value [%s] is not a number", v)
+			}
+			return strconv.FormatFloat(f, 'f', -1, 64), nil
+		case "boolean":
+			b, err := strconv.ParseBool(v)
+			if err != nil {
+				return "", fmt.Errorf("the value [%s] is not a boolean", v)
+			}
+			return strings.ToUpper(strconv.FormatBool(b)), nil
+		default:
+			return sf.QuoteLiteral(v), nil
+		}
+	default:
+		return "", fmt.Errorf("unsupported value of the filter [%v]", v)
+	}
+}
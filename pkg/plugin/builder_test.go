@@ -0,0 +1,153 @@
+package plugin
+
+import (
+	"encoding/json"
+	"os"
+	"reflect"
+	"regexp"
+	"sort"
+	"testing"
+)
+
+func TestBuilderSQL(t *testing.T) {
+	tests := []struct {
+		json     string
+		expected string
+	}{
+		{`{"dataFormat": "table", "snowflakeObject": {"database": "DB", "schema": "PUBLIC", "table": "ORDERS"}}`,
+			`SELECT * FROM "DB"."PUBLIC"."ORDERS"`},
+		{`{"dataFormat": "table", "snowflakeObject": {"table": "my\"table"},
+			"queryBuilder": {"selectColumns": [{"column": {"name": "REGION"}}, {"column": {"name": "PRICE"}, "aggFunc": "SUM", "alias": "total"}]}}`,
+			`SELECT "REGION", SUM("PRICE") AS "total" FROM "my""table"`},
+		{`{"dataFormat": "table", "snowflakeObject": {"database": "DB", "schema": "PUBLIC", "table": "ORDERS"},
+			"queryBuilder": {"hasGroupBy": true, "hasOrderBy": true,
+				"selectColumns": [{"column": {"name": "REGION"}}, {"column": {"name": "PRICE"}, "aggFunc": "AVG"}],
+				"groupByColumns": ["REGION"], "orderByColumn": {"name": "AVG(PRICE)", "sortOrder": "DESC", "limit": 10}}}`,
+			`SELECT "REGION", AVG("PRICE") FROM "DB"."PUBLIC"."ORDERS" GROUP BY "REGION" ORDER BY AVG("PRICE") DESC LIMIT 10`},
+		{`{"dataFormat": "table", "snowflakeObject": {"table": "ORDERS"},
+			"queryBuilder": {"hasFilter": true, "whereJsonTree": {"type": "group", "properties": {"conjunction": "OR"}, "children1": [
+				{"type": "rule", "properties": {"field": "REGION", "operator": "equal", "value": ["it's"], "valueType": ["text"]}},
+				{"type": "rule", "properties": {"field": "PRICE", "operator": "between", "value": [1, "2.5"], "valueType": ["number", "number"]}},
+				{"type": "rule", "properties": {"field": "NAME", "operator": "like", "value": ["50%_off"], "valueType": ["text"]}},
+				{"type": "rule", "properties": {"field": "CODE", "operator": "select_any_in", "value": ["a,b"], "valueType": ["text"]}},
+				{"type": "rule", "properties": {"field": "NOTE", "operator": "is_empty", "value": []}},
+				{"type": "rule", "properties": {"field": "PRICE", "operator": "less"}}
+			]}}}`,
+			`SELECT * FROM "ORDERS" WHERE ("REGION" = 'it''s' OR "PRICE" BETWEEN 1 AND 2.5 OR "NAME" LIKE '%50\\%\\_off%' ESCAPE '\\' OR "CODE" IN ('a', 'b') OR ("NOTE" IS NULL OR "NOTE" = ''))`},
+		{`{"dataFormat": "table", "snowflakeObject": {"table": "ORDERS"},
+			"queryBuilder": {"hasFilter": true, "whereJsonTree": {"type": "group", "children1": {
+				"b": {"type": "rule", "properties": {"field": "CODE", "operator": "select_not_any_in", "value": [["x", "y"]], "valueType": ["multiselect"]}},
+				"a": {"type": "group", "properties": {"conjunction": "AND", "not": true}, "children1": {
+					"c": {"type": "rule", "properties": {"field": "A", "operator": "greater", "value": ["B"], "valueSrc": ["field"]}},
+					"d": {"type": "rule", "properties": {"field": "C", "operator": "equal", "value": [true], "valueType": ["boolean"]}}
+				}}
+			}}}}`,
+			`SELECT * FROM "ORDERS" WHERE ("CODE" NOT IN ('x', 'y') AND NOT ("A" > "B" AND "C" = TRUE))`},
+		// The frontend replaces the multi-value variable $region in [["$region", "apac"]] with each of its values.
+		{`{"dataFormat": "table", "snowflakeObject": {"table": "ORDERS"},
+			"queryBuilder": {"hasFilter": true, "whereJsonTree": {"type": "group", "children1": [
+				{"type": "rule", "properties": {"field": "REGION", "operator": "select_any_in", "value": [["us", "eu", "apac"]], "valueType": ["multiselect"]}}
+			]}}}`,
+			`SELECT * FROM "ORDERS" WHERE "REGION" IN ('us', 'eu', 'apac')`},
+		{`{"snowflakeObject": {"database": "DB", "schema": "PUBLIC", "table": "ORDERS"},
+			"timeSeries": {"timeColumn": {"name": "CREATED_AT"}, "interval": 5, "timeUnit": "m", "fillMissing": "previous",
+				"lineIdentifiers": ["REGION"], "metrics": [{"column": {"name": "PRICE"}, "aggFunc": "AVG", "alias": "price"}, {"column": {"name": "ID"}}],
+				"rowLimit": 1000}}`,
+			`SELECT $__timeGroup("CREATED_AT", '5m', previous) AS "time", "REGION", AVG("PRICE") AS "price", MAX("ID") FROM "DB"."PUBLIC"."ORDERS"` +
+				` WHERE $__timeFilter("CREATED_AT") GROUP BY "time", "REGION" ORDER BY "time" LIMIT 1000`},
+		{`{"dataFormat": "timeseries", "snowflakeObject": {"table": "ORDERS"},
+			"timeSeries": {"timeColumn": {"name": "TS"}, "interval": 1, "timeUnit": "d",
+				"metrics": [{"column": {"name": "PRICE"}, "aggFunc": "SUM"}],
+				"filterJsonTree": {"type": "group", "children1": [
+					{"type": "rule", "properties": {"field": "UPDATED_AT", "operator": "macros", "value": ["timeFilter"], "valueType": ["datetime"]}},
+					{"type": "rule", "properties": {"field": "NAME", "operator": "starts_with", "value": ["a"], "valueType": ["text"]}}
+				]}}}`,
+			`SELECT $__timeGroup("TS", '1d') AS "time", SUM("PRICE") FROM "ORDERS"` +
+				` WHERE $__timeFilter("TS") AND ($__timeFilter("UPDATED_AT") AND "NAME" LIKE 'a%' ESCAPE '\\') GROUP BY "time" ORDER BY "time"`},
+	}
+
+	for _, tt := range tests {
+		var qj queryJson
+		if err := json.Unmarshal([]byte(tt.json), &qj); err != nil {
+			t.Fatalf("failed to unmarshal [%s]: %v", tt.json, err)
+		}
+
+		actual, err := qj.builderSQL()
+		if err != nil {
+			t.Errorf("failed to build [%s]: %v", tt.json, err)
+			continue
+		}
+
+		if actual != tt.expected {
+			t.Errorf("expected [%s], but got [%s]", tt.expected, actual)
+		}
+	}
+}
+
+func TestBuilderSQLError(t *testing.T) {
+	tests := []string{
+		`{"dataFormat": "table"}`,
+		`{"dataFormat": "table", "snowflakeObject": {"table": "T"}, "queryBuilder": {"selectColumns": [{"column": {"name": "A"}, "aggFunc": "SUM(1)); DROP TABLE T; --"}]}}`,
+		`{"dataFormat": "table", "snowflakeObject": {"table": "T"}, "queryBuilder": {"hasOrderBy": true, "orderByColumn": {"name": "A", "sortOrder": "DESC; DROP TABLE T"}}}`,
+		`{"dataFormat": "table", "snowflakeObject": {"table": "T"}, "queryBuilder": {"hasFilter": true, "whereJsonTree": {"type": "group", "children1": [
+			{"type": "rule", "properties": {"field": "A", "operator": "equal", "value": ["1 OR 1=1"], "valueType": ["number"]}}]}}}`,
+		`{"dataFormat": "table", "snowflakeObject": {"table": "T"}, "queryBuilder": {"hasFilter": true, "whereJsonTree": {"type": "group", "children1": [
+			{"type": "rule", "properties": {"field": "A", "operator": "macros", "value": ["1=1"]}}]}}}`,
+		`{"dataFormat": "table", "snowflakeObject": {"table": "T"}, "queryBuilder": {"hasFilter": true, "whereJsonTree": {"type": "group", "children1": [
+			{"type": "rule", "properties": {"field": "A", "operator": "proximity", "value": ["a"]}}]}}}`,
+		`{"snowflakeObject": {"table": "T"}, "timeSeries": {"interval": 1, "timeUnit": "m", "metrics": [{"column": {"name": "A"}}]}}`,
+		`{"snowflakeObject": {"table": "T"}, "timeSeries": {"timeColumn": {"name": "TS"}, "interval": 1, "timeUnit": "m"}}`,
+		`{"snowflakeObject": {"table": "T"}, "timeSeries": {"timeColumn": {"name": "TS"}, "interval": 1, "timeUnit": "1m'", "metrics": [{"column": {"name": "A"}}]}}`,
+		`{"snowflakeObject": {"table": "T"}, "timeSeries": {"timeColumn": {"name": "TS"}, "interval": 1, "timeUnit": "m", "fillMissing": "0) --", "metrics": [{"column": {"name": "A"}}]}}`,
+	}
+
+	for _, raw := range tests {
+		var qj queryJson
+		if err := json.Unmarshal([]byte(raw), &qj); err != nil {
+			t.Fatalf("failed to unmarshal [%s]: %v", raw, err)
+		}
+
+		if actual, err := qj.builderSQL(); err == nil {
+			t.Errorf("expected an error from [%s], but got [%s]", raw, actual)
+		}
+	}
+}
+
+// TestFilterOperators checks that the backend supports exactly the operators which the query builder of the frontend offers.
+func TestFilterOperators(t *testing.T) {
+	src, err := os.ReadFile("../../src/components/grafana/AwesomeQueryBuilder.tsx")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	list := regexp.MustCompile(`(?s)FILTER_OPERATORS: string\[\] = \[(.*?)\]`).FindSubmatch(src)
+	if list == nil {
+		t.Fatal("FILTER_OPERATORS is not found in the frontend")
+	}
+	var frontend []string
+	for _, m := range regexp.MustCompile(`'(\w+)'`).FindAllSubmatch(list[1], -1) {
+		frontend = append(frontend, string(m[1]))
+	}
+
+	backend := append([]string{}, filterOperators...)
+	sort.Strings(frontend)
+	sort.Strings(backend)
+	if !reflect.DeepEqual(frontend, backend) {
+		t.Errorf("expected the operators of the frontend %v to be those of the backend %v", frontend, backend)
+	}
+
+	for _, op := range filterOperators {
+		value := []any{"a"}
+		switch op {
+		case "between", "not_between":
+			value = []any{1.0, 2.0}
+		case "macros":
+			value = []any{filterMacroTimeFilter}
+		}
+
+		tree := filterTree{Type: "rule", Properties: filterProperties{Field: "A", Operator: op, Value: value}}
+		if sql, err := tree.ruleSQL(); err != nil || sql == "" {
+			t.Errorf("expected the SQL of [%s], but got [%s], [%v]", op, sql, err)
+		}
+	}
+}
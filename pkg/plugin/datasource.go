@@ -5,6 +5,7 @@ import (
 	"database/sql"
 	"fmt"
 	"sync"
+	"time"
 
 	"github.com/go-stack/stack"
 	"github.com/grafana/grafana-plugin-sdk-go/backend"
@@ -24,6 +25,7 @@ import (
 var (
 	_ backend.QueryDataHandler      = (*Datasource)(nil)
 	_ backend.CheckHealthHandler    = (*Datasource)(nil)
+	_ backend.CallResourceHandler   = (*Datasource)(nil)
 	_ instancemgmt.InstanceDisposer = (*Datasource)(nil)
 )
 
@@ -44,10 +46,21 @@ func NewDatasource(_ context.Context, settings backend.DataSourceInstanceSetting
 	return ds, nil
 }
 
+// frameMetaCustom is the plugin specific metadata of a frame.
+type frameMetaCustom struct {
+	// Cache is whether the result was served from the result cache: "hit", "miss" or "shared".
+	Cache string `json:"cache,omitempty"`
+}
+
 // Datasource is an example datasource which can respond to data queries, reports
 // its health and has streaming skills.
 type Datasource struct {
-	db *sql.DB
+	db              *sql.DB
+	userPools       *userPools
+	dm              *datasourceModel
+	resourceHandler backend.CallResourceHandler
+	metaCache       *metadataCache
+	resultCache     *resultCache
 }
 
 // Dispose here tells plugin SDK that plugin wants to clean up resources when a new instance
@@ -56,7 +69,12 @@ type Datasource struct {
 func (d *Datasource) Dispose() {
 	// Clean up datasource instance resources.
 	log.InfoM("Dispose")
-	d.db.Close()
+	d.metaCache.clear()
+	d.resultCache.clear()
+	d.userPools.close()
+	if d.db != nil {
+		d.db.Close()
+	}
 }
 
 // QueryData handles multiple queries and returns multiple responses.
@@ -67,49 +85,77 @@ func (d *Datasource) QueryData(ctx context.Context, req *backend.QueryDataReques
 	// create response struct
 	response := backend.NewQueryDataResponse()
 
+	conn, err := d.connection(req.GetHTTPHeader(backend.OAuthIdentityTokenHeaderName))
+	if err != nil {
+		log.ErrorM("failed to get the connection:", err)
+		for _, query := range req.Queries {
+			response.Responses[query.RefID] = backend.ErrDataResponse(errorStatus(err), er.GetMessage(err))
+		}
+		return response, nil
+	}
+
 	numQueries := len(req.Queries)
+	// The channel is large enough for every query, so that no query blocks
+	// after QueryData stops waiting.
 	responseChan := make(chan backend.DataResponse, numQueries)
 	var wg sync.WaitGroup
 
 	// loop over queries and execute them individually.
 	for _, query := range req.Queries {
 		wg.Add(1)
-		go d.concurrentQuery(ctx, query, responseChan, &wg)
+		go d.concurrentQuery(ctx, query, conn, responseChan, &wg)
 	}
 
-	// wait for results
-	wg.Wait()
-	close(responseChan)
+	// The queries may outlive QueryData when the request is cancelled, so the last of them releases the connection.
+	go func() {
+		wg.Wait()
+		close(responseChan)
+		conn.release()
+	}()
+
+	// wait for results, or until the request is cancelled.
+	for waiting := true; waiting; {
+		select {
+		case result, ok := <-responseChan:
+			if !ok {
+				waiting = false
+				break
+			}
 
-	for result := range responseChan {
-		if len(result.Frames) > 0 {
-			refID := result.Frames[0].RefID
+			if len(result.Frames) > 0 {
+				refID := result.Frames[0].RefID
 
-			// save the response in a hashmap
-			// based on with RefID as identifier
-			response.Responses[refID] = result
-		} else {
-			log.ErrorM("Frames not found in the result")
+				// save the response in a hashmap
+				// based on with RefID as identifier
+				response.Responses[refID] = result
+			} else {
+				log.ErrorM("Frames not found in the result")
+			}
+		case <-ctx.Done():
+			log.ErrorM("context is canceled:", ctx.Err())
+			waiting = false
+		}
+	}
+
+	for _, query := range req.Queries {
+		if _, found := response.Responses[query.RefID]; !found {
+			err := er.NewError(er.ErrQueryCancelled, ctx.Err())
+			response.Responses[query.RefID] = backend.ErrDataResponse(backend.StatusTimeout, er.GetMessage(err))
 		}
 	}
 
 	return response, nil
 }
 
-func (d *Datasource) concurrentQuery(ctx context.Context, query backend.DataQuery, responseChan chan<- backend.DataResponse, wg *sync.WaitGroup) {
+func (d *Datasource) concurrentQuery(ctx context.Context, query backend.DataQuery, conn connection, responseChan chan<- backend.DataResponse, wg *sync.WaitGroup) {
 	defer wg.Done()
 
-	// select {
-	// case <-ctx.Done():
-	// 	log.ErrorM("context is canceled")
-	// case responseChan <- d.query(ctx, query):
-	// }
-	responseChan <- d.query(ctx, query)
+	responseChan <- d.query(ctx, query, conn)
 }
 
-func (d *Datasource) query(ctx context.Context, query backend.DataQuery) (response backend.DataResponse) {
+func (d *Datasource) query(ctx context.Context, query backend.DataQuery, conn connection) (response backend.DataResponse) {
 	var qm *queryModel
-	var frame *data.Frame
+	var frames []*data.Frame
 	var err error
 
 	log.InfoM("Query:", query)
@@ -139,56 +185,106 @@ func (d *Datasource) query(ctx context.Context, query backend.DataQuery) (respon
 			response = backend.ErrDataResponse(backend.StatusBadRequest, fmt.Sprintf("panic: %s", p))
 		}
 
-		if frame == nil {
+		if len(frames) == 0 {
 			fields := make([]*data.Field, 0)
-			frame = data.NewFrame("response", fields...)
+			frames = append(frames, data.NewFrame("response", fields...))
 		}
 
-		frame.RefID = query.RefID
-		frame.Meta = &data.FrameMeta{
-			Type:                data.FrameTypeTimeSeriesWide,
-			ExecutedQueryString: executedQuery,
+		for i, frame := range frames {
+			frame.RefID = query.RefID
+			frame.Meta = &data.FrameMeta{
+				Type:                data.FrameTypeTimeSeriesWide,
+				ExecutedQueryString: executedQuery,
+			}
+			if qm != nil {
+				// The notices are of the whole query, so they are shown once.
+				if i == 0 {
+					frame.Meta.Notices = qm.notices
+				}
+				if qm.cacheStatus != "" {
+					frame.Meta.Custom = frameMetaCustom{Cache: qm.cacheStatus}
+				}
+			}
 		}
 		// add the frames to the response.
-		response.Frames = append(response.Frames, frame)
+		response.Frames = append(response.Frames, frames...)
 	}()
 
-	qm, err = buildQueryModel(&query)
+	qm, err = buildQueryModel(&query, d.dm)
 	if err != nil {
 		log.ErrorM("failed to query:", err)
 		response = backend.ErrDataResponse(backend.StatusBadRequest, fmt.Sprintf("json unmarshal: %v", err.Error()))
 		return
 	}
 
-	table, err := qm.execute(ctx, d.db)
-	if err != nil {
-		log.ErrorM("failed to execute the query:", err)
-		response = backend.ErrDataResponse(backend.StatusBadRequest, fmt.Sprintf("query execution: %v", err.Error()))
+	qm.user = conn.user
+
+	if err = qm.readOnly.check(qm.sql, qm.multiStatement != ""); err != nil {
+		log.ErrorM("failed to query:", err)
+		response = backend.ErrDataResponse(errorStatus(err), er.GetDetailedMessageF(err, "read-only: %v", err.Error()))
 		return
 	}
 
-	frame, err = qm.convertToFrame(table)
+	exec := func(ctx context.Context, timeout time.Duration) ([]*table, error) {
+		return qm.execute(ctx, conn.db, timeout)
+	}
+	tables, cacheStatus, err := d.resultCache.execute(ctx, qm.cacheKey(), qm.timeout, exec)
+	qm.cacheStatus = cacheStatus
 	if err != nil {
-		log.ErrorM("failed to convert table to frame:", err)
-		response = backend.ErrDataResponse(backend.StatusBadRequest, er.GetMessageF(err, "query execution: %v", err.Error()))
+		log.ErrorM("failed to execute the query:", err)
+		response = backend.ErrDataResponse(errorStatus(err), er.GetDetailedMessageF(err, "query execution: %v", err.Error()))
 		return
 	}
 
+	for _, table := range tables {
+		frame, err := qm.convertToFrame(table)
+		if frame != nil {
+			frames = append(frames, frame)
+		}
+		if err != nil {
+			log.ErrorM("failed to convert table to frame:", err)
+			response = backend.ErrDataResponse(backend.StatusBadRequest, er.GetMessageF(err, "query execution: %v", err.Error()))
+			return
+		}
+	}
+
 	return response
 }
 
+// errorStatus returns the status of the response for err.
+func errorStatus(err error) backend.Status {
+	switch er.GetCode(err) {
+	case er.ErrQueryCancelled, er.ErrQueryTimeout:
+		return backend.StatusTimeout
+	case er.ErrTooManyRows:
+		return backend.StatusValidationFailed
+	case er.ErrNotReadOnly:
+		return backend.StatusForbidden
+	case er.ErrNoUserToken:
+		return backend.StatusUnauthorized
+	default:
+		return backend.StatusBadRequest
+	}
+}
+
+// CallResource handles the metadata requests from the query editor, such as listing
+// databases, schemas, tables and columns. See newResourceHandler for the routes.
+func (d *Datasource) CallResource(ctx context.Context, req *backend.CallResourceRequest, sender backend.CallResourceResponseSender) error {
+	return d.resourceHandler.CallResource(ctx, req, sender)
+}
+
 // CheckHealth handles health checks sent from Grafana to the plugin.
 // The main use case for these health checks is the test button on the
 // datasource configuration page which allows users to verify that
 // a datasource is working as expected.
-func (d *Datasource) CheckHealth(_ context.Context, _ *backend.CheckHealthRequest) (*backend.CheckHealthResult, error) {
+func (d *Datasource) CheckHealth(ctx context.Context, req *backend.CheckHealthRequest) (*backend.CheckHealthResult, error) {
 	var status = backend.HealthStatusOk
 	var message = "Data source is working"
 
-	err := sf.Select1(d.db)
+	err := d.checkConnection(ctx, req.GetHTTPHeader(backend.OAuthIdentityTokenHeaderName))
 	if err != nil {
 		status = backend.HealthStatusError
-		message = fmt.Sprintf("failed to test the connection: %v", err)
+		message = err.Error()
 	}
 
 	return &backend.CheckHealthResult{
@@ -196,3 +292,55 @@ func (d *Datasource) CheckHealth(_ context.Context, _ *backend.CheckHealthReques
 		Message: message,
 	}, nil
 }
+
+// checkConnection tests the authentication step by step: the validation of its settings, getting the access
+// token of the OAuth, the login to Snowflake, and a query after the login. The error tells which step failed.
+// The settings are validated when the datasource is created, and their error is reported here first.
+func (d *Datasource) checkConnection(ctx context.Context, authorization string) error {
+	authtype := ""
+	if d.dm != nil {
+		authtype = d.dm.Authtype
+		if d.dm.authErr != nil {
+			return d.dm.authErr
+		}
+	}
+
+	conn, err := d.connection(authorization)
+	if err != nil {
+		return fmt.Errorf("failed to get the OAuth identity of the signed-in user: %s", er.GetMessage(err))
+	}
+	defer conn.release()
+
+	if d.dm != nil && d.dm.oauthTokens != nil {
+		if _, err := d.dm.oauthTokens.token(ctx); err != nil {
+			return fmt.Errorf("failed to get an access token from the token URL of the OAuth: %v", err)
+		}
+	}
+
+	// A new datasource has no connection yet, so that the login is tested with the current settings.
+	c, err := conn.db.Conn(ctx)
+	if err != nil {
+		return loginError(authtype, err)
+	}
+	c.Close()
+
+	err = sf.Select1(conn.db)
+	if err != nil {
+		return fmt.Errorf("logged in to Snowflake, but failed to run a query, check the role and the warehouse: %v", err)
+	}
+
+	return nil
+}
+
+// connection returns the DB of a request with the Authorization header. It is the pool of the signed-in
+// user if the datasource forwards the OAuth identity, or the shared DB otherwise. It returns the error of
+// the authentication if its settings are invalid.
+func (d *Datasource) connection(authorization string) (connection, error) {
+	if d.dm != nil && d.dm.authErr != nil {
+		return connection{}, d.dm.authErr
+	}
+	if d.userPools == nil {
+		return connection{db: d.db}, nil
+	}
+	return d.userPools.get(authorization)
+}
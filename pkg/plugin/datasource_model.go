@@ -2,10 +2,12 @@ package plugin
 
 import (
 	"crypto/rsa"
-	"crypto/x509"
+	"database/sql"
 	"encoding/json"
-	"encoding/pem"
 	"fmt"
+	"net/url"
+	"strconv"
+	"time"
 
 	"github.com/grafana/grafana-plugin-sdk-go/backend"
 	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
@@ -14,27 +16,86 @@ import (
 	gs "github.com/snowflakedb/gosnowflake"
 )
 
+// defaultMaxRows is the row limit of a datasource which does not set MaxRows.
+const defaultMaxRows = 1000000
+
 type datasourceModel struct {
-	Account         string
-	User            string
-	Role            string
-	Database        string
-	Schema          string
-	Warehouse       string
-	Authtype        string
-	Password        string
-	PrivateKey      string
-	ConnPoolOptions *sf.ConnectionPoolConfig
+	Account                 string
+	User                    string
+	Role                    string
+	Database                string
+	Schema                  string
+	Warehouse               string
+	Authtype                string
+	Password                string
+	PrivateKey              string
+	PrivateKeyPassphrase    string
+	OAuthClientSecret       string
+	OAuthRefreshToken       string
+	ProgrammaticAccessToken string
+	OktaURL                 string
+	QueryTimeout            int
+	MaxRows                 int
+	FailOnMaxRows           bool
+	ArrowFetch              bool
+	Timezone                string
+	WeekStart               string
+	Macros                  []customMacro
+	ConnPoolOptions         *sf.ConnectionPoolConfig
+	MetadataCache           *metadataCacheConfig
+	ResultCache             *resultCacheConfig
+	ReadOnly                *readOnlyConfig
+	OAuth                   *oauthConfig
+	OAuthPassThru           bool
+	location                *time.Location
+	weekStart               time.Weekday
+	macros                  macroRegistry
+	readOnly                *readOnlyGuard
+	privateKey              *rsa.PrivateKey
+	oauthTokens             *oauthTokenSource
+	oktaURL                 *url.URL
+	// authErr is why the authentication is invalid. The datasource is created anyway,
+	// so that CheckHealth can report it.
+	authErr error
 }
 
 func buildDatasourceModel(settings *backend.DataSourceInstanceSettings) (*datasourceModel, error) {
-	var dm datasourceModel
+	dm := datasourceModel{
+		MaxRows:    defaultMaxRows,
+		ArrowFetch: true,
+	}
 
 	err := json.Unmarshal(settings.JSONData, &dm)
 	if err != nil {
 		return nil, fmt.Errorf("failed to unmarshal the backend.settings to DatasourceModel: [%s]", string(settings.JSONData))
 	}
 
+	// Without the Timezone, the times are read and written in UTC as they are, see toTimestampTZ.
+	if dm.Timezone != "" {
+		dm.location, err = time.LoadLocation(dm.Timezone)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load the timezone [%s]: [%v]", dm.Timezone, err)
+		}
+	}
+
+	dm.weekStart = time.Monday
+	if dm.WeekStart != "" {
+		dm.weekStart, err = parseWeekday(dm.WeekStart)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse the weekStart: [%v]", err)
+		}
+	}
+
+	dm.macros, err = newMacroRegistry(dm.Macros)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load the macros: [%v]", err)
+	}
+
+	dm.readOnly, err = newReadOnlyGuard(dm.ReadOnly)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load the readOnly: [%v]", err)
+	}
+
 	log.DefaultLogger.Info("------------------------------------------------------------")
 	log.DefaultLogger.Info(fmt.Sprintf("jsonData: [%s]", string(settings.JSONData)))
 	log.DefaultLogger.Info("------------------------------------------------------------")
@@ -44,6 +105,14 @@ func buildDatasourceModel(settings *backend.DataSourceInstanceSettings) (*dataso
 
 	dm.Password = settings.DecryptedSecureJSONData["password"]
 	dm.PrivateKey = settings.DecryptedSecureJSONData["privatekey"]
+	dm.PrivateKeyPassphrase = settings.DecryptedSecureJSONData["privatekeyPassphrase"]
+	dm.OAuthClientSecret = settings.DecryptedSecureJSONData["oauthClientSecret"]
+	dm.OAuthRefreshToken = settings.DecryptedSecureJSONData["oauthRefreshToken"]
+	dm.ProgrammaticAccessToken = settings.DecryptedSecureJSONData["programmaticAccessToken"]
+
+	if err := dm.validateAuth(); err != nil {
+		dm.authErr = fmt.Errorf("failed to validate the authentication: %v", err)
+	}
 
 	return &dm, nil
 }
@@ -57,6 +126,21 @@ func createDatasource(dm *datasourceModel) (*Datasource, error) {
 		Database:  dm.Database,
 		Schema:    dm.Schema,
 		Warehouse: dm.Warehouse,
+		Params:    make(map[string]*string),
+	}
+
+	if dm.QueryTimeout > 0 {
+		// The context deadline of a query aborts it as well, but the statement timeout also
+		// stops a statement whose client went away.
+		timeout := strconv.Itoa(dm.QueryTimeout)
+		cfg.Params["STATEMENT_TIMEOUT_IN_SECONDS"] = &timeout
+	}
+
+	if dm.Timezone != "" {
+		// The macros read TIMESTAMP_NTZ and DATE columns in the session time zone,
+		// so it must be the same time zone as the one the plugin reads them in.
+		timezone := dm.Timezone
+		cfg.Params["TIMEZONE"] = &timezone
 	}
 
 	if dm.ConnPoolOptions == nil {
@@ -66,47 +150,75 @@ func createDatasource(dm *datasourceModel) (*Datasource, error) {
 	log.DefaultLogger.Info(fmt.Sprintf("ConnectionPoolConfig: [%#+v]", dm.ConnPoolOptions))
 	log.DefaultLogger.Info("------------------------------------------------------------")
 
-	switch dm.Authtype {
-	case "basic":
-		cfg.Password = dm.Password
-	case "keypair":
-		pk, err := parsePrivateKey(dm.PrivateKey)
+	var db *sql.DB
+	var pools *userPools
+	if dm.authErr == nil {
+		var err error
+		db, pools, err = openDB(dm, &cfg)
 		if err != nil {
-			return nil, fmt.Errorf("failed to parse a private key: %v", err)
+			return nil, err
 		}
-		cfg.PrivateKey = pk
-		cfg.Authenticator = gs.AuthTypeJwt
-	default:
-		return nil, fmt.Errorf("unsupported authentication type: %s", dm.Authtype)
 	}
 
-	db, err := sf.Open(&cfg, dm.ConnPoolOptions)
-	if err != nil {
-		return nil, fmt.Errorf("failed to open the Snowflake: [%v]", err)
+	if dm.MetadataCache == nil {
+		dm.MetadataCache = &defaultMetadataCacheConfig
 	}
 
-	return &Datasource{
-		db,
-	}, nil
+	if dm.ResultCache == nil {
+		dm.ResultCache = &defaultResultCacheConfig
+	}
+
+	ds := &Datasource{
+		db:          db,
+		userPools:   pools,
+		dm:          dm,
+		metaCache:   newMetadataCache(dm.MetadataCache),
+		resultCache: newResultCache(dm.ResultCache),
+	}
+	ds.resourceHandler = newResourceHandler(ds)
+
+	return ds, nil
 }
 
-func parsePrivateKey(key string) (*rsa.PrivateKey, error) {
-	bytes := []byte(key)
+// openDB opens the DB of the authentication of the datasource, or the pools of the signed-in users
+// if it forwards the OAuth identity.
+func openDB(dm *datasourceModel, cfg *gs.Config) (*sql.DB, *userPools, error) {
+	var tokenSource *oauthTokenSource
+	var pools *userPools
 
-	block, _ := pem.Decode(bytes)
-	if block == nil {
-		return nil, fmt.Errorf("failed to parse PEM block containing the private key")
+	switch dm.Authtype {
+	case authBasic:
+		cfg.Password = dm.Password
+	case authKeypair:
+		cfg.PrivateKey = dm.privateKey
+		cfg.Authenticator = gs.AuthTypeJwt
+	case authOAuth:
+		// The DSN has a fixed token, so the DB gets the token of each connection from the source instead.
+		tokenSource = dm.oauthTokens
+	case authOAuthPassThru:
+		pools = newUserPools(cfg, dm.ConnPoolOptions)
+	case authPAT:
+		// Snowflake accepts a programmatic access token in place of the password of its user.
+		cfg.Password = dm.ProgrammaticAccessToken
+	case authOkta:
+		cfg.Password = dm.Password
+		cfg.OktaURL = dm.oktaURL
+		cfg.Authenticator = gs.AuthTypeOkta
+	default:
+		return nil, nil, fmt.Errorf("unsupported authentication type: %s", dm.Authtype)
 	}
 
-	privateKey, err := x509.ParsePKCS8PrivateKey(block.Bytes)
-	if err != nil {
-		return nil, fmt.Errorf("failed to parse an unencrypted private key in PKCS: %v", err)
+	// A datasource which forwards the OAuth identity has no shared DB, so that no query runs as another user.
+	switch {
+	case pools != nil:
+		return nil, pools, nil
+	case tokenSource != nil:
+		return sf.OpenWithToken(cfg, dm.ConnPoolOptions, tokenSource.connectionToken), nil, nil
 	}
 
-	pk, ok := privateKey.(*rsa.PrivateKey)
-	if !ok {
-		return nil, fmt.Errorf("failed to convert to *rsa.PrivateKey")
+	db, err := sf.Open(cfg, dm.ConnPoolOptions)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open the Snowflake: [%v]", err)
 	}
-
-	return pk, nil
+	return db, nil, nil
 }
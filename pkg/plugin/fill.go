@@ -0,0 +1,364 @@
+package plugin
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+
+	"github.com/nexon/sunflake/pkg/util/log"
+)
+
+type fillMode int
+
+const (
+	fillModeNull fillMode = iota
+	fillModeValue
+	fillModePrevious
+	fillModeNext
+	fillModeLinear
+)
+
+// fillMissing is how to fill a missing value. It has more modes than data.FillMissing.
+type fillMissing struct {
+	mode  fillMode
+	value float64
+	// ttl is how many missing values in a row a previous value fills. If ttl is 0, it fills all of them.
+	ttl int
+}
+
+// dataFillMissing returns the data.FillMissing for data.LongToWide, which fills with null
+// instead of the modes it does not support.
+func (f *fillMissing) dataFillMissing() *data.FillMissing {
+	switch f.mode {
+	case fillModeValue:
+		return &data.FillMissing{Mode: data.FillModeValue, Value: f.value}
+	case fillModePrevious:
+		if f.ttl <= 0 {
+			return &data.FillMissing{Mode: data.FillModePrevious}
+		}
+	}
+
+	return &data.FillMissing{Mode: data.FillModeNull}
+}
+
+var matchPreviousTTL = regexp.MustCompile(`(?i)^previous\((\d+)\)$`)
+
+// parseFillMissing parses how to fill a missing value, which is null, previous, previous(N) to fill
+// at most N missing values in a row, next, linear or a number.
+func parseFillMissing(fill string) (*fillMissing, error) {
+	switch strings.ToLower(fill) {
+	case "null":
+		return &fillMissing{mode: fillModeNull}, nil
+	case "previous":
+		return &fillMissing{mode: fillModePrevious}, nil
+	case "next":
+		return &fillMissing{mode: fillModeNext}, nil
+	case "linear":
+		return &fillMissing{mode: fillModeLinear}, nil
+	}
+
+	if m := matchPreviousTTL.FindStringSubmatch(fill); m != nil {
+		ttl, err := strconv.Atoi(m[1])
+		if err != nil || ttl <= 0 {
+			return nil, fmt.Errorf("the number of previous[%s] must be positive", fill)
+		}
+		return &fillMissing{mode: fillModePrevious, ttl: ttl}, nil
+	}
+
+	v, err := strconv.ParseFloat(fill, 64)
+	if err != nil {
+		return nil, fmt.Errorf("an error from parseFloat[%s]", fill)
+	}
+	return &fillMissing{mode: fillModeValue, value: v}, nil
+}
+
+// fieldFillMissing returns how to fill the missing values of each field. The fill of a field
+// is looked up by its name, ignoring case, or is the fill of $__timeGroup.
+func (qm *queryModel) fieldFillMissing(frame *data.Frame) []*fillMissing {
+	fills := make([]*fillMissing, len(frame.Fields))
+
+	for i, field := range frame.Fields {
+		fills[i] = qm.fillMissingOption
+		if fill, found := qm.fieldFills[strings.ToLower(field.Name)]; found {
+			fills[i] = fill
+		}
+	}
+
+	return fills
+}
+
+// fillStats counts the rows which are not placed as they are while filling missing points.
+type fillStats struct {
+	// snapped is the number of rows which are not at the start of a bucket, and are moved to it.
+	snapped int
+	// duplicated is the number of rows dropped because another row of the series is in the same bucket.
+	duplicated int
+	// outOfRange is the number of rows dropped because they are out of the time range or have no time.
+	outOfRange int
+}
+
+func (s *fillStats) notices() []data.Notice {
+	var notices []data.Notice
+
+	if s.snapped > 0 {
+		notices = append(notices, data.Notice{
+			Severity: data.NoticeSeverityWarning,
+			Text:     fmt.Sprintf("%d rows were not at the start of an interval of $__timeGroup, and were moved to the start of their interval.", s.snapped),
+		})
+	}
+	if s.duplicated > 0 {
+		notices = append(notices, data.Notice{
+			Severity: data.NoticeSeverityWarning,
+			Text:     fmt.Sprintf("%d rows were dropped, because another row of the same series is in the same interval.", s.duplicated),
+		})
+	}
+	if s.outOfRange > 0 {
+		notices = append(notices, data.Notice{
+			Severity: data.NoticeSeverityWarning,
+			Text:     fmt.Sprintf("%d rows were dropped, because their time is out of the time range.", s.outOfRange),
+		})
+	}
+
+	return notices
+}
+
+// fillMissingPoints returns a frame with a row of every series at the start of every bucket in the time range.
+// A wide frame is a single series, and a long frame has a series for each set of the values of its factor fields.
+// The rows are sorted by time, and then by the order in which the series first appear.
+// A row which is not at the start of a bucket is moved to it, and the rows which cannot be placed are dropped.
+// Both are reported as notices.
+func (qm *queryModel) fillMissingPoints(schema *data.TimeSeriesSchema, frame *data.Frame) (*data.Frame, error) {
+	timeIdx := schema.TimeIndex
+	timeField := frame.Fields[timeIdx]
+	if timeField.Type() != data.FieldTypeTime && timeField.Type() != data.FieldTypeNullableTime {
+		return nil, fmt.Errorf("failed to fill missing points, cause by not supported type[%s]", timeField.Type())
+	}
+
+	buckets := qm.buckets()
+	fills := qm.fieldFillMissing(frame)
+	missingVals := buildMissingValues(frame, timeIdx, fills)
+
+	var stats fillStats
+	series := splitSeries(frame, schema.FactorIndices)
+	filled := make([][][]interface{}, len(series))
+	for i, rows := range series {
+		filled[i] = qm.fillSeries(frame, schema, rows, buckets, fills, missingVals, &stats)
+	}
+
+	newFrame := frame.EmptyCopy()
+	for b := range buckets {
+		for i := range series {
+			newFrame.AppendRow(filled[i][b]...)
+		}
+	}
+
+	qm.notices = append(qm.notices, stats.notices()...)
+	return newFrame, nil
+}
+
+// buckets returns the start of every bucket in the time range.
+func (qm *queryModel) buckets() []time.Time {
+	buckets := make([]time.Time, 0)
+
+	for curr := qm.step.truncate(qm.from); !curr.After(qm.to); curr = qm.step.next(curr) {
+		buckets = append(buckets, curr)
+	}
+
+	return buckets
+}
+
+// splitSeries returns the indices of the rows of each series, in the order in which the series first appear.
+func splitSeries(frame *data.Frame, factorIndices []int) [][]int {
+	rowLen, _ := frame.RowLen()
+	if len(factorIndices) == 0 {
+		rows := make([]int, rowLen)
+		for i := range rows {
+			rows[i] = i
+		}
+		return [][]int{rows}
+	}
+
+	series := make([][]int, 0)
+	seriesIdx := make(map[string]int)
+
+	for i := 0; i < rowLen; i++ {
+		var sb strings.Builder
+		for _, f := range factorIndices {
+			v, ok := frame.Fields[f].ConcreteAt(i)
+			// Null and a value are different label sets, even if the value is printed the same.
+			fmt.Fprintf(&sb, "%t:%v\x00", ok, v)
+		}
+
+		key := sb.String()
+		idx, found := seriesIdx[key]
+		if !found {
+			idx = len(series)
+			seriesIdx[key] = idx
+			series = append(series, nil)
+		}
+		series[idx] = append(series[idx], i)
+	}
+
+	return series
+}
+
+// fillSeries returns the row of the series at each bucket, which is the row in the bucket or a filled row.
+func (qm *queryModel) fillSeries(frame *data.Frame, schema *data.TimeSeriesSchema, rows []int, buckets []time.Time,
+	fills []*fillMissing, missingVals []interface{}, stats *fillStats) [][]interface{} {
+	timeIdx := schema.TimeIndex
+	timeField := frame.Fields[timeIdx]
+
+	bucketRows := make([]int, len(buckets))
+	for b := range bucketRows {
+		bucketRows[b] = -1
+	}
+
+	for _, r := range rows {
+		v, ok := timeField.ConcreteAt(r)
+		if !ok {
+			stats.outOfRange++
+			continue
+		}
+		t := v.(time.Time)
+
+		b := sort.Search(len(buckets), func(i int) bool { return buckets[i].After(t) }) - 1
+		if b < 0 || (b == len(buckets)-1 && !t.Before(qm.step.next(buckets[b]))) {
+			stats.outOfRange++
+			continue
+		}
+
+		if bucketRows[b] >= 0 {
+			stats.duplicated++
+			continue
+		}
+		if !t.Equal(buckets[b]) {
+			stats.snapped++
+		}
+		bucketRows[b] = r
+	}
+
+	// nextRows[b] is the row in the first bucket after b which has a row.
+	nextRows := make([]int, len(buckets))
+	next := -1
+	for b := len(buckets) - 1; b >= 0; b-- {
+		nextRows[b] = next
+		if bucketRows[b] >= 0 {
+			next = bucketRows[b]
+		}
+	}
+
+	filled := make([][]interface{}, len(buckets))
+	prevRowIdx := -1
+	missingCount := 0
+
+	for b, r := range bucketRows {
+		var vals []interface{}
+
+		if r >= 0 {
+			vals = frame.RowCopy(r)
+			prevRowIdx = r
+			missingCount = 0
+		} else {
+			missingCount++
+			vals = newRow(fills, frame, timeIdx, buckets[b], prevRowIdx, nextRows[b], missingCount, missingVals)
+
+			// A filled row of a long frame has the labels of its series.
+			for _, f := range schema.FactorIndices {
+				vals[f] = frame.CopyAt(f, rows[0])
+			}
+		}
+
+		if timeField.Nullable() {
+			t := buckets[b]
+			vals[timeIdx] = &t
+		} else {
+			vals[timeIdx] = buckets[b]
+		}
+		filled[b] = vals
+	}
+
+	return filled
+}
+
+// buildMissingValues returns the values which fill a missing row regardless of the other rows.
+// A field which cannot hold the number to fill with is filled with null.
+func buildMissingValues(frame *data.Frame, timeIdx int, fills []*fillMissing) []interface{} {
+	vals := make([]interface{}, len(frame.Fields))
+
+	for i, field := range frame.Fields {
+		if i == timeIdx || fills[i].mode != fillModeValue {
+			continue
+		}
+
+		v, err := data.GetMissing(&data.FillMissing{Mode: data.FillModeValue, Value: fills[i].value}, field, 0)
+		if err != nil {
+			log.Info("failed to fill the field", field.Name, "with the value:", err)
+			continue
+		}
+		vals[i] = v
+	}
+
+	return vals
+}
+
+// newRow returns the values of a missing row at curr, between the rows at prevRowIdx and nextRowIdx
+// of the frame, which are -1 if there is no such row. missingCount is the number of the missing rows
+// since the previous row, including this one.
+func newRow(fills []*fillMissing, frame *data.Frame, timeIdx int, curr time.Time, prevRowIdx int, nextRowIdx int, missingCount int, missingVals []interface{}) []interface{} {
+	vals := make([]interface{}, len(missingVals))
+	copy(vals, missingVals)
+
+	for i, fill := range fills {
+		if i == timeIdx {
+			continue
+		}
+
+		switch fill.mode {
+		case fillModePrevious:
+			if prevRowIdx >= 0 && (fill.ttl <= 0 || missingCount <= fill.ttl) {
+				vals[i] = frame.CopyAt(i, prevRowIdx)
+			}
+		case fillModeNext:
+			if nextRowIdx >= 0 {
+				vals[i] = frame.CopyAt(i, nextRowIdx)
+			}
+		case fillModeLinear:
+			if prevRowIdx >= 0 && nextRowIdx >= 0 {
+				vals[i] = interpolate(frame.Fields[i], frame.Fields[timeIdx], curr, prevRowIdx, nextRowIdx)
+			}
+		}
+	}
+
+	return vals
+}
+
+// interpolate returns the value of the field at curr on the line between the rows at prevRowIdx and nextRowIdx.
+// It returns nil if the field is not a number, or either value is null.
+func interpolate(field *data.Field, timeField *data.Field, curr time.Time, prevRowIdx int, nextRowIdx int) interface{} {
+	prev, err := field.FloatAt(prevRowIdx)
+	if err != nil || math.IsNaN(prev) {
+		return nil
+	}
+
+	next, err := field.FloatAt(nextRowIdx)
+	if err != nil || math.IsNaN(next) {
+		return nil
+	}
+
+	prevTime, _ := timeField.ConcreteAt(prevRowIdx)
+	nextTime, _ := timeField.ConcreteAt(nextRowIdx)
+	span := nextTime.(time.Time).Sub(prevTime.(time.Time))
+	ratio := float64(curr.Sub(prevTime.(time.Time))) / float64(span)
+
+	v, err := data.GetMissing(&data.FillMissing{Mode: data.FillModeValue, Value: prev + (next-prev)*ratio}, field, 0)
+	if err != nil {
+		return nil
+	}
+	return v
+}
@@ -0,0 +1,254 @@
+package plugin
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+func TestFillMissingPerField(t *testing.T) {
+	start := time.Date(2024, 3, 19, 13, 0, 0, 0, time.UTC)
+	step, _ := parseTimeStep("1m", time.UTC, time.Monday)
+
+	frame := data.NewFrame("response",
+		data.NewField("time", nil, []time.Time{start, start.Add(2 * time.Minute)}),
+		data.NewField("COUNT", nil, []*int64{int64p(3), int64p(5)}),
+		data.NewField("gauge", nil, []*float64{float64p(0.5), float64p(0.7)}),
+		data.NewField("other", nil, []*float64{float64p(1), float64p(2)}),
+	)
+
+	qm := queryModel{
+		from:              start,
+		to:                start.Add(2 * time.Minute),
+		step:              step,
+		shouldFillMissing: true,
+		fillMissingOption: &fillMissing{mode: fillModeNull},
+		fieldFills: map[string]*fillMissing{
+			"count": {mode: fillModeValue, value: 0},
+			"gauge": {mode: fillModePrevious},
+		},
+	}
+
+	schema := frame.TimeSeriesSchema()
+	filled, err := qm.fillMissingPoints(&schema, frame)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if l, _ := filled.RowLen(); l != 3 {
+		t.Fatalf("expected 3 rows, but got %d", l)
+	}
+	if v := filled.Fields[1].At(1).(*int64); v == nil || *v != 0 {
+		t.Errorf("expected 0 for the missing count, but got %v", v)
+	}
+	if v := filled.Fields[2].At(1).(*float64); v == nil || *v != 0.5 {
+		t.Errorf("expected the previous value for the missing gauge, but got %v", v)
+	}
+	if v := filled.Fields[3].At(1).(*float64); v != nil {
+		t.Errorf("expected null for the missing other, but got %v", *v)
+	}
+}
+
+func TestFillModes(t *testing.T) {
+	start := time.Date(2024, 3, 19, 13, 0, 0, 0, time.UTC)
+	step, _ := parseTimeStep("1m", time.UTC, time.Monday)
+
+	frame := data.NewFrame("response",
+		data.NewField("time", nil, []*time.Time{&start, timep(start.Add(4 * time.Minute))}),
+		data.NewField("value", nil, []*float64{float64p(1), float64p(5)}),
+	)
+
+	tests := []struct {
+		fill     string
+		expected []*float64
+	}{
+		{"null", []*float64{float64p(1), nil, nil, nil, float64p(5), nil}},
+		{"0", []*float64{float64p(1), float64p(0), float64p(0), float64p(0), float64p(5), float64p(0)}},
+		{"previous", []*float64{float64p(1), float64p(1), float64p(1), float64p(1), float64p(5), float64p(5)}},
+		{"previous(2)", []*float64{float64p(1), float64p(1), float64p(1), nil, float64p(5), float64p(5)}},
+		{"next", []*float64{float64p(1), float64p(5), float64p(5), float64p(5), float64p(5), nil}},
+		{"linear", []*float64{float64p(1), float64p(2), float64p(3), float64p(4), float64p(5), nil}},
+	}
+
+	for _, tt := range tests {
+		fill, err := parseFillMissing(tt.fill)
+		if err != nil {
+			t.Fatalf("parseFillMissing(%s): %v", tt.fill, err)
+		}
+
+		qm := queryModel{
+			from:              start,
+			to:                start.Add(5 * time.Minute),
+			step:              step,
+			shouldFillMissing: true,
+			fillMissingOption: fill,
+		}
+
+		schema := frame.TimeSeriesSchema()
+		filled, err := qm.fillMissingPoints(&schema, frame)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.fill, err)
+		}
+
+		if l, _ := filled.RowLen(); l != len(tt.expected) {
+			t.Fatalf("%s: expected %d rows, but got %d", tt.fill, len(tt.expected), l)
+		}
+		for i, expected := range tt.expected {
+			if at := filled.Fields[0].At(i).(*time.Time); !at.Equal(start.Add(time.Duration(i) * time.Minute)) {
+				t.Errorf("%s: unexpected time [%v] at %d", tt.fill, *at, i)
+			}
+
+			actual := filled.Fields[1].At(i).(*float64)
+			if (actual == nil) != (expected == nil) || (actual != nil && *actual != *expected) {
+				t.Errorf("%s: expected [%v] at %d, but got [%v]", tt.fill, fmtFloat64p(expected), i, fmtFloat64p(actual))
+			}
+		}
+	}
+}
+
+func fmtFloat64p(v *float64) string {
+	if v == nil {
+		return "null"
+	}
+	return strconv.FormatFloat(*v, 'f', -1, 64)
+}
+
+func timep(v time.Time) *time.Time {
+	return &v
+}
+
+func int64p(v int64) *int64 {
+	return &v
+}
+
+func float64p(v float64) *float64 {
+	return &v
+}
+
+func TestFillMissingPoints(t *testing.T) {
+	start := time.Date(2024, 3, 19, 13, 0, 0, 0, time.UTC)
+	step, _ := parseTimeStep("1m", time.UTC, time.Monday)
+	at := func(d time.Duration) time.Time { return start.Add(d) }
+
+	tests := []struct {
+		name     string
+		fill     string
+		times    []time.Time
+		values   []*float64
+		expected []*float64
+		notices  int
+	}{
+		{"a leading empty bucket has no previous value", "previous",
+			[]time.Time{at(2 * time.Minute), at(3 * time.Minute)},
+			[]*float64{float64p(2), float64p(3)},
+			[]*float64{nil, nil, float64p(2), float64p(3), float64p(3)}, 0},
+		{"rows off the grid are moved to the start of their bucket", "null",
+			[]time.Time{at(30 * time.Second), at(2*time.Minute + 10*time.Second)},
+			[]*float64{float64p(1), float64p(2)},
+			[]*float64{float64p(1), nil, float64p(2), nil, nil}, 1},
+		{"the first row in a bucket is kept", "null",
+			[]time.Time{at(time.Minute), at(time.Minute + 30*time.Second)},
+			[]*float64{float64p(1), float64p(9)},
+			[]*float64{nil, float64p(1), nil, nil, nil}, 1},
+		{"rows out of the time range are dropped", "0",
+			[]time.Time{at(-time.Minute), at(time.Minute), at(5 * time.Minute)},
+			[]*float64{float64p(7), float64p(1), float64p(8)},
+			[]*float64{float64p(0), float64p(1), float64p(0), float64p(0), float64p(0)}, 1},
+		{"linear interpolates between the rows around a gap", "linear",
+			[]time.Time{at(0), at(3 * time.Minute)},
+			[]*float64{float64p(0), float64p(3)},
+			[]*float64{float64p(0), float64p(1), float64p(2), float64p(3), nil}, 0},
+	}
+
+	for _, tt := range tests {
+		fill, err := parseFillMissing(tt.fill)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+
+		qm := queryModel{
+			from:              start,
+			to:                at(4 * time.Minute),
+			step:              step,
+			shouldFillMissing: true,
+			fillMissingOption: fill,
+		}
+
+		frame := data.NewFrame("response",
+			data.NewField("time", nil, tt.times),
+			data.NewField("value", nil, tt.values),
+		)
+		schema := frame.TimeSeriesSchema()
+
+		filled, err := qm.fillMissingPoints(&schema, frame)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+
+		if l, _ := filled.RowLen(); l != len(tt.expected) {
+			t.Fatalf("%s: expected %d rows, but got %d", tt.name, len(tt.expected), l)
+		}
+		for i, expected := range tt.expected {
+			if actual := filled.Fields[0].At(i).(time.Time); !actual.Equal(at(time.Duration(i) * time.Minute)) {
+				t.Errorf("%s: unexpected time [%v] at %d", tt.name, actual, i)
+			}
+
+			actual := filled.Fields[1].At(i).(*float64)
+			if (actual == nil) != (expected == nil) || (actual != nil && *actual != *expected) {
+				t.Errorf("%s: expected [%v] at %d, but got [%v]", tt.name, fmtFloat64p(expected), i, fmtFloat64p(actual))
+			}
+		}
+
+		if len(qm.notices) != tt.notices {
+			t.Errorf("%s: expected %d notices, but got %v", tt.name, tt.notices, qm.notices)
+		}
+	}
+}
+
+func TestFillLongFrame(t *testing.T) {
+	start := time.Date(2024, 3, 19, 13, 0, 0, 0, time.UTC)
+	step, _ := parseTimeStep("1m", time.UTC, time.Monday)
+	a, b := "a", "b"
+
+	tbl := &table{cols: []column{
+		{name: "time", values: []time.Time{start, start, start.Add(2 * time.Minute)}},
+		{name: "name", values: []*string{&a, &b, &a}},
+		{name: "value", values: []*float64{float64p(1), float64p(10), float64p(3)}},
+	}}
+
+	qm := queryModel{
+		from:              start,
+		to:                start.Add(2 * time.Minute),
+		step:              step,
+		isTimeseries:      true,
+		shouldFillMissing: true,
+		fillMissingOption: &fillMissing{mode: fillModePrevious},
+	}
+
+	frame, err := qm.convertToFrame(tbl)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string][]float64{
+		"a": {1, 1, 3},
+		"b": {10, 10, 10},
+	}
+
+	if len(frame.Fields) != 3 {
+		t.Fatalf("expected a time field and a field for each name, but got %d fields", len(frame.Fields))
+	}
+	for _, field := range frame.Fields[1:] {
+		values := expected[field.Labels["name"]]
+		if field.Len() != len(values) {
+			t.Fatalf("expected %d values of [%s], but got %d", len(values), field.Labels["name"], field.Len())
+		}
+		for i, v := range values {
+			if actual := field.At(i).(*float64); actual == nil || *actual != v {
+				t.Errorf("expected [%v] of [%s] at %d, but got [%v]", v, field.Labels["name"], i, fmtFloat64p(actual))
+			}
+		}
+	}
+}
@@ -0,0 +1,162 @@
+package plugin
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+)
+
+// flattenJSON returns a table whose semi-structured columns holding objects are replaced by a column
+// for each top-level key, named "column.key". The table itself is not changed, because it may be cached.
+//
+// A key column holds numbers, strings or booleans if all of its values are of that type,
+// and JSON otherwise. A column holding anything other than objects is kept as it is.
+func (t *table) flattenJSON() (*table, error) {
+	cols := make([]column, 0, len(t.cols))
+
+	for _, c := range t.cols {
+		values, ok := c.values.([]*json.RawMessage)
+		if !ok {
+			cols = append(cols, c)
+			continue
+		}
+
+		objects, keys, ok := parseObjects(values)
+		if !ok {
+			cols = append(cols, c)
+			continue
+		}
+
+		for _, key := range keys {
+			keyValues, err := newKeyValues(objects, key)
+			if err != nil {
+				return nil, fmt.Errorf("failed to flatten the key [%s] of the column [%s]: %v", key, c.name, err)
+			}
+
+			cols = append(cols, column{
+				name:         c.name + "." + key,
+				databaseType: c.databaseType,
+				values:       keyValues,
+			})
+		}
+	}
+
+	return &table{cols, t.truncated, t.rowCount}, nil
+}
+
+// parseObjects parses the values as JSON objects, and returns the top-level keys in the order they appear.
+// It returns false if any value is not an object.
+func parseObjects(values []*json.RawMessage) ([]map[string]json.RawMessage, []string, bool) {
+	objects := make([]map[string]json.RawMessage, len(values))
+	keys := make([]string, 0)
+	seen := make(map[string]bool)
+
+	for i, v := range values {
+		if v == nil || isJSONNull(*v) {
+			continue
+		}
+
+		trimmed := bytes.TrimSpace(*v)
+		if len(trimmed) == 0 || trimmed[0] != '{' {
+			return nil, nil, false
+		}
+
+		dec := json.NewDecoder(bytes.NewReader(trimmed))
+		if _, err := dec.Token(); err != nil {
+			return nil, nil, false
+		}
+
+		object := make(map[string]json.RawMessage)
+		for dec.More() {
+			token, err := dec.Token()
+			if err != nil {
+				return nil, nil, false
+			}
+			key := token.(string)
+
+			var value json.RawMessage
+			if err := dec.Decode(&value); err != nil {
+				return nil, nil, false
+			}
+
+			object[key] = value
+			if !seen[key] {
+				seen[key] = true
+				keys = append(keys, key)
+			}
+		}
+		objects[i] = object
+	}
+
+	return objects, keys, true
+}
+
+// newKeyValues collects the values of the key from every object into a slice of the narrowest type.
+func newKeyValues(objects []map[string]json.RawMessage, key string) (interface{}, error) {
+	var floats []*float64
+	var strs []*string
+	var bools []*bool
+	raws := make([]*json.RawMessage, len(objects))
+	isFloat, isString, isBool := true, true, true
+
+	for i, object := range objects {
+		v, found := object[key]
+		if !found || isJSONNull(v) {
+			continue
+		}
+		raws[i] = &v
+
+		switch bytes.TrimSpace(v)[0] {
+		case '"':
+			isFloat, isBool = false, false
+		case 't', 'f':
+			isFloat, isString = false, false
+		case '{', '[':
+			isFloat, isString, isBool = false, false, false
+		default:
+			isString, isBool = false, false
+		}
+	}
+
+	switch {
+	case isFloat:
+		floats = make([]*float64, len(raws))
+		for i, v := range raws {
+			if v != nil {
+				floats[i] = new(float64)
+				if err := json.Unmarshal(*v, floats[i]); err != nil {
+					return nil, err
+				}
+			}
+		}
+		return floats, nil
+	case isString:
+		strs = make([]*string, len(raws))
+		for i, v := range raws {
+			if v != nil {
+				strs[i] = new(string)
+				if err := json.Unmarshal(*v, strs[i]); err != nil {
+					return nil, err
+				}
+			}
+		}
+		return strs, nil
+	case isBool:
+		bools = make([]*bool, len(raws))
+		for i, v := range raws {
+			if v != nil {
+				bools[i] = new(bool)
+				if err := json.Unmarshal(*v, bools[i]); err != nil {
+					return nil, err
+				}
+			}
+		}
+		return bools, nil
+	}
+
+	return raws, nil
+}
+
+func isJSONNull(v json.RawMessage) bool {
+	return bytes.Equal(bytes.TrimSpace(v), []byte("null"))
+}
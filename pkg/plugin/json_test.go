@@ -0,0 +1,66 @@
+package plugin
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestFlattenJSON(t *testing.T) {
+	raw := func(s string) *json.RawMessage {
+		m := json.RawMessage(s)
+		return &m
+	}
+
+	tbl := &table{cols: []column{
+		{name: "payload", databaseType: "VARIANT", values: []*json.RawMessage{
+			raw(`{"cpu": 0.5, "host": "a", "tags": ["x"]}`),
+			nil,
+			raw(`{"cpu": 1, "up": true}`),
+		}},
+		{name: "items", databaseType: "ARRAY", values: []*json.RawMessage{raw(`[1]`), nil, raw(`[2]`)}},
+	}}
+
+	flattened, err := tbl.flattenJSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]string{
+		"payload.cpu":  "[]*float64",
+		"payload.host": "[]*string",
+		"payload.tags": "[]*json.RawMessage",
+		"payload.up":   "[]*bool",
+		"items":        "[]*json.RawMessage",
+	}
+	if len(flattened.cols) != len(expected) {
+		t.Fatalf("expected %d columns, but got %d", len(expected), len(flattened.cols))
+	}
+	for _, c := range flattened.cols {
+		if typ := typeName(c.values); typ != expected[c.name] {
+			t.Errorf("column [%s]: expected [%s], but got [%s]", c.name, expected[c.name], typ)
+		}
+	}
+
+	cpu := flattened.cols[0].values.([]*float64)
+	if *cpu[0] != 0.5 || cpu[1] != nil || *cpu[2] != 1 {
+		t.Errorf("unexpected values of payload.cpu: %v", cpu)
+	}
+
+	if len(tbl.cols) != 2 {
+		t.Error("the original table must not be changed")
+	}
+}
+
+func typeName(values any) string {
+	switch values.(type) {
+	case []*float64:
+		return "[]*float64"
+	case []*string:
+		return "[]*string"
+	case []*bool:
+		return "[]*bool"
+	case []*json.RawMessage:
+		return "[]*json.RawMessage"
+	}
+	return "unknown"
+}
@@ -5,35 +5,48 @@ import (
 	"time"
 )
 
-// Interpolate provides global macros/substitutions for all sql datasources.
-// var Interpolate = func(query backend.DataQuery, timeRange backend.TimeRange, timeInterval string, sql string) string {
-// 	interval := query.Interval
+// toTimestampTZ converts the column to TIMESTAMP_TZ in the time zone, keeping the instant of
+// TIMESTAMP_TZ and TIMESTAMP_LTZ values. TIMESTAMP_NTZ and DATE values are read in the session
+// time zone, which the datasource sets to the same time zone.
+// If loc is nil, the datasource does not set a time zone, and the column is converted to TIMESTAMP_NTZ,
+// whose wall clock is read as UTC.
+func toTimestampTZ(col string, loc *time.Location) string {
+	if loc == nil {
+		return fmt.Sprintf("TO_TIMESTAMP_NTZ(%s)", col)
+	}
+	return fmt.Sprintf("CONVERT_TIMEZONE('%s', TO_TIMESTAMP_TZ(%s))", loc, col)
+}
 
-// 	sql = strings.ReplaceAll(sql, "$__interval_ms", strconv.FormatInt(interval.Milliseconds(), 10))
-// 	sql = strings.ReplaceAll(sql, "$__interval", gtime.FormatInterval(interval))
-// 	sql = strings.ReplaceAll(sql, "$__unixEpochFrom()", fmt.Sprintf("%d", timeRange.From.UTC().Unix()))
-// 	sql = strings.ReplaceAll(sql, "$__unixEpochTo()", fmt.Sprintf("%d", timeRange.To.UTC().Unix()))
+// orUTC returns the time zone, or UTC if loc is nil.
+func orUTC(loc *time.Location) *time.Location {
+	if loc == nil {
+		return time.UTC
+	}
+	return loc
+}
 
-//		return sql
-//	}
+func toTimestamp(col string, loc *time.Location) string {
+	return fmt.Sprintf("%s AS time", toTimestampTZ(col, loc))
+}
 
-func toTimestamp(col string) string {
-	return fmt.Sprintf("TO_TIMESTAMP_NTZ(%s) AS time", col)
+// toRFC3339 formats the time with the offset of the time zone, or in UTC if loc is nil, so that
+// the literal means the same wall clock when it is compared with a TIMESTAMP_NTZ or DATE column.
+func toRFC3339(t time.Time, loc *time.Location) string {
+	return fmt.Sprintf("'%s'", t.In(orUTC(loc)).Format(time.RFC3339Nano))
 }
 
-func toRFC3339(t time.Time) string {
-	return fmt.Sprintf("'%s'", t.UTC().Format(time.RFC3339Nano))
+func timeFilter(col string, from time.Time, to time.Time, loc *time.Location) string {
+	return fmt.Sprintf("%s BETWEEN %s AND %s", col, toRFC3339(from, loc), toRFC3339(to, loc))
 }
 
-func timeFilter(col string, from time.Time, to time.Time) string {
-	return fmt.Sprintf("%s BETWEEN %s AND %s", col, toRFC3339(from), toRFC3339(to))
+func timeEpoch(col string, loc *time.Location) string {
+	return fmt.Sprintf("DATE_PART(EPOCH_SECOND, %s) AS time", toTimestampTZ(col, loc))
 }
 
-func timeGroup(col string, it time.Duration) string {
-	return fmt.Sprintf("TIME_SLICE(TO_TIMESTAMP_NTZ(%s), %v, 'SECOND', 'START')", col, it.Seconds())
+func unixEpochFilter(col string, from int64, to int64) string {
+	return fmt.Sprintf("%s >= %d AND %s <= %d", col, from, col, to)
 }
 
-// func toTimeEpoch(col string) string {
-// 	// return fmt.Sprintf("DATE_PART(EPOCH_SECOND, %s) AS time_sec", arg)
-// 	return fmt.Sprintf("extract(epoch from %s) AS time", col)
-// }
+func unixEpochGroup(col string, seconds int64) string {
+	return fmt.Sprintf("FLOOR(%s / %d) * %d", col, seconds, seconds)
+}
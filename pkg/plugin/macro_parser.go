@@ -0,0 +1,202 @@
+package plugin
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// macro is a call of a macro in a query, such as $__timeFilter(col) or $__interval.
+type macro struct {
+	name string
+	// args is nil when the macro is written without parentheses.
+	args []string
+	// text is the call as it is written in the query.
+	text   string
+	offset int
+}
+
+// macroError is an error at a position of a query. Lines and columns start from 1.
+type macroError struct {
+	line   int
+	column int
+	err    error
+}
+
+func (e *macroError) Error() string {
+	return fmt.Sprintf("line %d, column %d: %v", e.line, e.column, e.err)
+}
+
+func (e *macroError) Unwrap() error {
+	return e.err
+}
+
+func newMacroError(src string, offset int, format string, a ...any) *macroError {
+	line, column := position(src, offset)
+	return &macroError{line, column, fmt.Errorf(format, a...)}
+}
+
+// position returns the line and the column of the byte offset in the source.
+func position(src string, offset int) (int, int) {
+	before := src[:offset]
+	line := strings.Count(before, "\n") + 1
+	column := utf8.RuneCountInString(before[strings.LastIndexByte(before, '\n')+1:]) + 1
+
+	return line, column
+}
+
+// parseMacros finds the macros in the query. A macro is a name starting with $__, optionally followed
+// by arguments in parentheses. Macros in string literals, quoted identifiers and comments are ignored.
+// Arguments are split on the commas outside of nested parentheses, literals and quoted identifiers.
+func parseMacros(src string) ([]macro, error) {
+	var macros []macro
+
+	for i := 0; i < len(src); {
+		end, err := skipLiteral(src, i)
+		if err != nil {
+			return nil, err
+		}
+		if end > i {
+			i = end
+			continue
+		}
+
+		if !strings.HasPrefix(src[i:], "$__") || (i > 0 && isIdentifierByte(src[i-1])) {
+			i++
+			continue
+		}
+
+		m, err := parseMacro(src, i)
+		if err != nil {
+			return nil, err
+		}
+
+		macros = append(macros, m)
+		i = m.offset + len(m.text)
+	}
+
+	return macros, nil
+}
+
+func parseMacro(src string, start int) (macro, error) {
+	i := start + 1
+	for i < len(src) && isIdentifierByte(src[i]) && src[i] != '$' {
+		i++
+	}
+
+	m := macro{name: src[start+1 : i], offset: start}
+	if i >= len(src) || src[i] != '(' {
+		m.text = src[start:i]
+		return m, nil
+	}
+
+	args, end, err := parseArgs(src, i)
+	if err != nil {
+		if _, ok := err.(*macroError); ok {
+			return m, err
+		}
+		return m, newMacroError(src, start, "failed to parse the macro [%s]: %v", m.name, err)
+	}
+
+	m.args = args
+	m.text = src[start:end]
+	return m, nil
+}
+
+// parseArgs splits the arguments in the parentheses starting at open,
+// and returns the offset after the closing parenthesis.
+func parseArgs(src string, open int) ([]string, int, error) {
+	args := make([]string, 0)
+	depth := 0
+	argStart := open + 1
+
+	for i := open + 1; i < len(src); {
+		end, err := skipLiteral(src, i)
+		if err != nil {
+			return nil, 0, err
+		}
+		if end > i {
+			i = end
+			continue
+		}
+
+		switch src[i] {
+		case '(':
+			depth++
+		case ')':
+			if depth == 0 {
+				if arg := strings.TrimSpace(src[argStart:i]); arg != "" || len(args) > 0 {
+					args = append(args, arg)
+				}
+				return args, i + 1, nil
+			}
+			depth--
+		case ',':
+			if depth == 0 {
+				args = append(args, strings.TrimSpace(src[argStart:i]))
+				argStart = i + 1
+			}
+		}
+		i++
+	}
+
+	return nil, 0, fmt.Errorf("the parentheses are not closed")
+}
+
+// skipLiteral returns the offset after the string literal, quoted identifier or comment starting at i,
+// or i if there is none.
+func skipLiteral(src string, i int) (int, error) {
+	rest := src[i:]
+
+	switch {
+	case rest[0] == '\'':
+		return skipQuoted(src, i, '\'', "string literal")
+	case rest[0] == '"':
+		return skipQuoted(src, i, '"', "quoted identifier")
+	case strings.HasPrefix(rest, "$$"):
+		end := strings.Index(rest[2:], "$$")
+		if end < 0 {
+			return 0, newMacroError(src, i, "the dollar-quoted string literal is not closed")
+		}
+		return i + 2 + end + 2, nil
+	case strings.HasPrefix(rest, "--"), strings.HasPrefix(rest, "//"):
+		end := strings.IndexByte(rest, '\n')
+		if end < 0 {
+			return len(src), nil
+		}
+		return i + end + 1, nil
+	case strings.HasPrefix(rest, "/*"):
+		end := strings.Index(rest[2:], "*/")
+		if end < 0 {
+			return 0, newMacroError(src, i, "the comment is not closed")
+		}
+		return i + 2 + end + 2, nil
+	}
+
+	return i, nil
+}
+
+// skipQuoted skips the text quoted with the quote, where a doubled quote is an escaped quote.
+// A backslash also escapes the next character in a string literal.
+func skipQuoted(src string, start int, quote byte, kind string) (int, error) {
+	for i := start + 1; i < len(src); i++ {
+		switch src[i] {
+		case '\\':
+			if quote == '\'' {
+				i++
+			}
+		case quote:
+			if i+1 < len(src) && src[i+1] == quote {
+				i++
+				continue
+			}
+			return i + 1, nil
+		}
+	}
+
+	return 0, newMacroError(src, start, "the %s is not closed", kind)
+}
+
+func isIdentifierByte(b byte) bool {
+	return b == '_' || b == '$' || ('a' <= b && b <= 'z') || ('A' <= b && b <= 'Z') || ('0' <= b && b <= '9')
+}
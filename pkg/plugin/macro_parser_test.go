@@ -0,0 +1,71 @@
+package plugin
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestParseMacros(t *testing.T) {
+	tests := []struct {
+		src      string
+		expected []macro
+	}{
+		{"WHERE $__timeFilter(COALESCE(a, b))",
+			[]macro{{"__timeFilter", []string{"COALESCE(a, b)"}, "$__timeFilter(COALESCE(a, b))", 6}}},
+		{`SELECT $__timeGroup("my,col", '1m', 0)`,
+			[]macro{{"__timeGroup", []string{`"my,col"`, "'1m'", "0"}, `$__timeGroup("my,col", '1m', 0)`, 7}}},
+		{"SELECT $__timeFilter(a, ')'), $__interval",
+			[]macro{
+				{"__timeFilter", []string{"a", "')'"}, "$__timeFilter(a, ')')", 7},
+				{"__interval", nil, "$__interval", 30},
+			}},
+		{"SELECT $__timeFrom()",
+			[]macro{{"__timeFrom", []string{}, "$__timeFrom()", 7}}},
+		{"SELECT '$__timeGroup(col, ''1m'', 0)', \"$__time(a)\", $$ $__time(b) $$",
+			nil},
+		{"SELECT a -- $__time(a\n, b // $__time(b\n/* $__time(c) */ FROM t",
+			nil},
+		{"SELECT a$__b, $1 FROM t",
+			nil},
+	}
+
+	for _, tt := range tests {
+		actual, err := parseMacros(tt.src)
+		if err != nil {
+			t.Errorf("failed to parse [%s]: %v", tt.src, err)
+			continue
+		}
+
+		if !reflect.DeepEqual(actual, tt.expected) {
+			t.Errorf("parse [%s]: expected %#v, but got %#v", tt.src, tt.expected, actual)
+		}
+	}
+}
+
+func TestParseMacrosError(t *testing.T) {
+	tests := []struct {
+		src    string
+		line   int
+		column int
+	}{
+		{"SELECT $__timeFilter(COALESCE(a, b)", 1, 8},
+		{"SELECT a,\n  $__time(b, 'c)", 2, 14},
+		{"SELECT a\nFROM \"t", 2, 6},
+		{"SELECT a /* b", 1, 10},
+	}
+
+	for _, tt := range tests {
+		_, err := parseMacros(tt.src)
+
+		var macroErr *macroError
+		if !errors.As(err, &macroErr) {
+			t.Errorf("expected a macroError from [%s], but got [%v]", tt.src, err)
+			continue
+		}
+
+		if macroErr.line != tt.line || macroErr.column != tt.column {
+			t.Errorf("expected the error of [%s] at %d:%d, but got [%v]", tt.src, tt.line, tt.column, err)
+		}
+	}
+}
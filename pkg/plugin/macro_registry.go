@@ -0,0 +1,262 @@
+package plugin
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/gtime"
+)
+
+// macroFunc evaluates a macro with its arguments into SQL.
+type macroFunc func(qm *queryModel, args []string) (string, error)
+
+// macroRegistry looks up the macros by their names, such as "__timeFilter".
+type macroRegistry interface {
+	lookup(name string) (macroFunc, bool)
+}
+
+// macroMap is a macroRegistry holding the macros registered by their names.
+type macroMap map[string]macroFunc
+
+func (m macroMap) register(name string, f macroFunc) error {
+	if _, found := m[name]; found {
+		return fmt.Errorf("the macro [$%s] is already registered", name)
+	}
+
+	m[name] = f
+	return nil
+}
+
+func (m macroMap) lookup(name string) (macroFunc, bool) {
+	f, found := m[name]
+	return f, found
+}
+
+// builtinMacros is the registry of the macros provided by the plugin.
+var builtinMacros = macroMap{}
+
+func init() {
+	macros := map[string]macroFunc{
+		"__time":                withColumn(macroTime),
+		"__timeEpoch":           withColumn(macroTimeEpoch),
+		"__timeFrom":            macroTimeFrom,
+		"__timeTo":              macroTimeTo,
+		"__timeFilter":          withColumn(macroTimeFilter),
+		"__timeGroup":           withColumn(macroTimeGroup),
+		"__timeGroupAlias":      withColumn(withAlias(macroTimeGroup)),
+		"__unixEpochFilter":     withColumn(macroUnixEpochFilter),
+		"__unixEpochNanoFilter": withColumn(macroUnixEpochNanoFilter),
+		"__unixEpochFrom":       macroUnixEpochFrom,
+		"__unixEpochTo":         macroUnixEpochTo,
+		"__unixEpochNanoFrom":   macroUnixEpochNanoFrom,
+		"__unixEpochNanoTo":     macroUnixEpochNanoTo,
+		"__unixEpochGroup":      withColumn(macroUnixEpochGroup),
+		"__unixEpochGroupAlias": withColumn(withAlias(macroUnixEpochGroup)),
+		"__interval":            macroInterval,
+		"__interval_ms":         macroIntervalMs,
+	}
+
+	for name, f := range macros {
+		if err := builtinMacros.register(name, f); err != nil {
+			panic(err)
+		}
+	}
+}
+
+// grafanaVariables are the global variables of Grafana which start with "$__" as the macros do. Grafana
+// replaces them before it sends the query, so one left in the query is passed through as it is written,
+// instead of being reported as an unknown macro.
+var grafanaVariables = map[string]bool{
+	"__from":             true,
+	"__to":               true,
+	"__range":            true,
+	"__range_s":          true,
+	"__range_ms":         true,
+	"__rate_interval":    true,
+	"__rate_interval_ms": true,
+	"__dashboard":        true,
+	"__org":              true,
+	"__user":             true,
+	"__name":             true,
+	"__timezone":         true,
+	"__all_variables":    true,
+	"__url_time_range":   true,
+	"__searchFilter":     true,
+	"__auto":             true,
+}
+
+// withColumn checks that the first argument of the macro, which is the time column, is given.
+func withColumn(f macroFunc) macroFunc {
+	return func(qm *queryModel, args []string) (string, error) {
+		if len(args) == 0 || args[0] == "" {
+			return "", fmt.Errorf("missing time column argument")
+		}
+		return f(qm, args)
+	}
+}
+
+// withAlias names the result of the macro "time".
+func withAlias(f macroFunc) macroFunc {
+	return func(qm *queryModel, args []string) (string, error) {
+		sql, err := f(qm, args)
+		if err != nil {
+			return "", err
+		}
+		return sql + " AS time", nil
+	}
+}
+
+func macroTime(qm *queryModel, args []string) (string, error) {
+	return toTimestamp(args[0], qm.location), nil
+}
+
+func macroTimeEpoch(qm *queryModel, args []string) (string, error) {
+	return timeEpoch(args[0], qm.location), nil
+}
+
+func macroTimeFrom(qm *queryModel, args []string) (string, error) {
+	return toRFC3339(qm.from, qm.location), nil
+}
+
+func macroTimeTo(qm *queryModel, args []string) (string, error) {
+	return toRFC3339(qm.to, qm.location), nil
+}
+
+func macroTimeFilter(qm *queryModel, args []string) (string, error) {
+	loc := qm.location
+	if len(args) >= 2 {
+		var err error
+		loc, err = time.LoadLocation(strings.Trim(args[1], `'"`))
+		if err != nil {
+			return "", fmt.Errorf("an error from loadLocation(%s): %v", args[1], err)
+		}
+	}
+
+	return timeFilter(args[0], qm.from, qm.to, loc), nil
+}
+
+func macroTimeGroup(qm *queryModel, args []string) (string, error) {
+	if err := qm.setTimeGroup(args); err != nil {
+		return "", err
+	}
+
+	return qm.step.sql(args[0]), nil
+}
+
+func macroUnixEpochFilter(qm *queryModel, args []string) (string, error) {
+	return unixEpochFilter(args[0], qm.from.Unix(), qm.to.Unix()), nil
+}
+
+func macroUnixEpochNanoFilter(qm *queryModel, args []string) (string, error) {
+	return unixEpochFilter(args[0], qm.from.UnixNano(), qm.to.UnixNano()), nil
+}
+
+func macroUnixEpochFrom(qm *queryModel, args []string) (string, error) {
+	return strconv.FormatInt(qm.from.Unix(), 10), nil
+}
+
+func macroUnixEpochTo(qm *queryModel, args []string) (string, error) {
+	return strconv.FormatInt(qm.to.Unix(), 10), nil
+}
+
+func macroUnixEpochNanoFrom(qm *queryModel, args []string) (string, error) {
+	return strconv.FormatInt(qm.from.UnixNano(), 10), nil
+}
+
+func macroUnixEpochNanoTo(qm *queryModel, args []string) (string, error) {
+	return strconv.FormatInt(qm.to.UnixNano(), 10), nil
+}
+
+func macroUnixEpochGroup(qm *queryModel, args []string) (string, error) {
+	if err := qm.setTimeGroup(args); err != nil {
+		return "", err
+	}
+
+	if qm.step.unit != stepSecond {
+		return "", fmt.Errorf("interval[%s] must be in seconds, minutes or hours", args[1])
+	}
+
+	return unixEpochGroup(args[0], qm.step.count), nil
+}
+
+func macroInterval(qm *queryModel, args []string) (string, error) {
+	return gtime.FormatInterval(qm.interval), nil
+}
+
+func macroIntervalMs(qm *queryModel, args []string) (string, error) {
+	return strconv.FormatInt(qm.interval.Milliseconds(), 10), nil
+}
+
+// customMacro is a macro defined in the datasource settings, such as
+//
+//	{"name": "tenantFilter", "args": ["column"], "sql": "{{.column}} = 'acme'"}
+//
+// SQL is a text/template which is given the arguments by their names.
+// The built-in macros in the evaluated SQL are evaluated as well.
+type customMacro struct {
+	Name string
+	Args []string
+	SQL  string
+}
+
+var matchMacroName = regexp.MustCompile(`^__[_a-zA-Z0-9]+$`)
+
+// newMacroRegistry returns the registry of the built-in macros and the custom macros.
+// A custom macro cannot replace a built-in macro.
+func newMacroRegistry(custom []customMacro) (macroRegistry, error) {
+	if len(custom) == 0 {
+		return builtinMacros, nil
+	}
+
+	macros := make(macroMap, len(builtinMacros)+len(custom))
+	for name, f := range builtinMacros {
+		macros[name] = f
+	}
+
+	for _, c := range custom {
+		name := "__" + strings.TrimPrefix(c.Name, "__")
+		if !matchMacroName.MatchString(name) {
+			return nil, fmt.Errorf("the name of the macro [%s] must consist of letters, digits and underscores", c.Name)
+		}
+
+		f, err := newCustomMacro(name, c)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := macros.register(name, f); err != nil {
+			return nil, err
+		}
+	}
+
+	return macros, nil
+}
+
+func newCustomMacro(name string, c customMacro) (macroFunc, error) {
+	tmpl, err := template.New(name).Option("missingkey=error").Parse(c.SQL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse the sql of the macro [$%s]: %v", name, err)
+	}
+
+	return func(qm *queryModel, args []string) (string, error) {
+		if len(args) != len(c.Args) {
+			return "", fmt.Errorf("the macro needs %d arguments %v, but got %d", len(c.Args), c.Args, len(args))
+		}
+
+		values := make(map[string]string, len(args))
+		for i, arg := range c.Args {
+			values[arg] = args[i]
+		}
+
+		var sb strings.Builder
+		if err := tmpl.Execute(&sb, values); err != nil {
+			return "", fmt.Errorf("failed to execute the sql of the macro: %v", err)
+		}
+
+		return qm.expandMacros(sb.String(), builtinMacros)
+	}, nil
+}
@@ -0,0 +1,119 @@
+package plugin
+
+import (
+	"testing"
+	"time"
+)
+
+func TestEvalAllMacros(t *testing.T) {
+	from := time.Date(2024, 3, 19, 13, 0, 0, 0, time.UTC)
+	to := time.Date(2024, 3, 19, 14, 0, 0, 0, time.UTC)
+	ts := "CONVERT_TIMEZONE('UTC', TO_TIMESTAMP_TZ(ts))"
+
+	tests := []struct {
+		raw      string
+		expected string
+	}{
+		{"SELECT $__time(ts)", "SELECT " + ts + " AS time"},
+		{"SELECT $__timeEpoch(ts)", "SELECT DATE_PART(EPOCH_SECOND, " + ts + ") AS time"},
+		{"WHERE ts > $__timeFrom() AND ts < $__timeTo()", "WHERE ts > '2024-03-19T13:00:00Z' AND ts < '2024-03-19T14:00:00Z'"},
+		{"WHERE $__timeFilter(ts)", "WHERE ts BETWEEN '2024-03-19T13:00:00Z' AND '2024-03-19T14:00:00Z'"},
+		{"WHERE $__timeFilter(ts, 'Asia/Seoul')", "WHERE ts BETWEEN '2024-03-19T22:00:00+09:00' AND '2024-03-19T23:00:00+09:00'"},
+		{"SELECT $__timeGroup(ts, '5m')", "SELECT TIME_SLICE(" + ts + ", 300, 'SECOND', 'START')"},
+		{"SELECT $__timeGroupAlias(ts, '1h')", "SELECT TIME_SLICE(" + ts + ", 3600, 'SECOND', 'START') AS time"},
+		{"SELECT $__timeGroup(ts, $__interval)", "SELECT TIME_SLICE(" + ts + ", 30, 'SECOND', 'START')"},
+		{"WHERE $__unixEpochFilter(epoch)", "WHERE epoch >= 1710853200 AND epoch <= 1710856800"},
+		{"WHERE $__unixEpochNanoFilter(epoch)", "WHERE epoch >= 1710853200000000000 AND epoch <= 1710856800000000000"},
+		{"WHERE epoch BETWEEN $__unixEpochFrom() AND $__unixEpochTo()", "WHERE epoch BETWEEN 1710853200 AND 1710856800"},
+		{"WHERE epoch BETWEEN $__unixEpochNanoFrom() AND $__unixEpochNanoTo()", "WHERE epoch BETWEEN 1710853200000000000 AND 1710856800000000000"},
+		{"SELECT $__unixEpochGroup(epoch, '5m')", "SELECT FLOOR(epoch / 300) * 300"},
+		{"SELECT $__unixEpochGroupAlias(epoch, '5m')", "SELECT FLOOR(epoch / 300) * 300 AS time"},
+		{"SELECT $__interval, $__interval_ms", "SELECT 30s, 30000"},
+		{"SELECT $1, $unknown(a) FROM t", "SELECT $1, $unknown(a) FROM t"},
+		// The global variables of Grafana are passed through.
+		{"SELECT $__rate_interval, $__dashboard FROM t WHERE ms BETWEEN $__from AND $__to", "SELECT $__rate_interval, $__dashboard FROM t WHERE ms BETWEEN $__from AND $__to"},
+	}
+
+	for _, tt := range tests {
+		qm := queryModel{
+			raw:       tt.raw,
+			from:      from,
+			to:        to,
+			interval:  30 * time.Second,
+			location:  time.UTC,
+			weekStart: time.Monday,
+		}
+
+		if err := qm.evalAllMacros(); err != nil {
+			t.Errorf("failed to evaluate [%s]: %v", tt.raw, err)
+			continue
+		}
+
+		if qm.sql != tt.expected {
+			t.Errorf("expected [%s], but got [%s]", tt.expected, qm.sql)
+		}
+	}
+}
+
+func TestEvalAllMacrosError(t *testing.T) {
+	tests := []string{
+		"SELECT $__time()",
+		"WHERE $__timeFilter(ts, 'Nowhere/Unknown')",
+		"SELECT $__timeGroup(ts)",
+		"SELECT $__unixEpochGroup(epoch, '1d')",
+		"SELECT $__unknown(ts)",
+	}
+
+	for _, raw := range tests {
+		qm := queryModel{raw: raw, location: time.UTC}
+		if err := qm.evalAllMacros(); err == nil {
+			t.Errorf("expected an error from [%s], but got [%s]", raw, qm.sql)
+		}
+	}
+}
+
+func TestCustomMacros(t *testing.T) {
+	macros, err := newMacroRegistry([]customMacro{
+		{"tenantFilter", []string{"column"}, "{{.column}} = 'acme' AND $__timeFilter(ts)"},
+		{"__tenant", nil, "'acme'"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	qm := queryModel{
+		raw:      "WHERE $__tenantFilter(tenant) OR name = $__tenant",
+		from:     time.Date(2024, 3, 19, 13, 0, 0, 0, time.UTC),
+		to:       time.Date(2024, 3, 19, 14, 0, 0, 0, time.UTC),
+		location: time.UTC,
+		macros:   macros,
+	}
+	if err := qm.evalAllMacros(); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "WHERE tenant = 'acme' AND ts BETWEEN '2024-03-19T13:00:00Z' AND '2024-03-19T14:00:00Z' OR name = 'acme'"
+	if qm.sql != expected {
+		t.Errorf("expected [%s], but got [%s]", expected, qm.sql)
+	}
+
+	qm.raw = "WHERE $__tenantFilter(a, b)"
+	if err := qm.evalAllMacros(); err == nil {
+		t.Errorf("expected an error from the wrong number of arguments, but got [%s]", qm.sql)
+	}
+}
+
+func TestCustomMacrosError(t *testing.T) {
+	tests := [][]customMacro{
+		{{"timeFilter", []string{"column"}, "{{.column}}"}},
+		{{"tenant", nil, "'a'"}, {"__tenant", nil, "'b'"}},
+		{{"tenant-filter", nil, "'a'"}},
+		{{"tenant", nil, "{{.column"}},
+	}
+
+	for _, custom := range tests {
+		if _, err := newMacroRegistry(custom); err == nil {
+			t.Errorf("expected an error from %v", custom)
+		}
+	}
+}
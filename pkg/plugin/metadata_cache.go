@@ -0,0 +1,167 @@
+package plugin
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+type metadataCacheConfig struct {
+	// TTL is how long a listing is served from the cache, in seconds. If TTL <= 0, the cache is disabled.
+	TTL int
+	// MaxEntries bounds the number of cached listings. The least recently used listing is evicted first.
+	MaxEntries int
+}
+
+var defaultMetadataCacheConfig = metadataCacheConfig{
+	TTL:        300,
+	MaxEntries: 1000,
+}
+
+// metadataKey identifies a listing of Snowflake objects. Role and the user of a forwarded OAuth
+// identity are parts of the key, because what a listing contains depends on the grants of the role
+// and the user that ran it.
+type metadataKey struct {
+	user     string
+	role     string
+	kind     string
+	database string
+	schema   string
+	table    string
+	search   string
+}
+
+type metadataEntry struct {
+	key       metadataKey
+	value     any
+	expiresAt time.Time
+}
+
+// metadataCache is a LRU cache with TTL for the metadata resources.
+// A nil *metadataCache is valid and caches nothing.
+type metadataCache struct {
+	mu         sync.Mutex
+	ttl        time.Duration
+	maxEntries int
+	entries    map[metadataKey]*list.Element
+	lru        *list.List
+	now        func() time.Time
+}
+
+func newMetadataCache(cfg *metadataCacheConfig) *metadataCache {
+	if cfg == nil || cfg.TTL <= 0 {
+		return nil
+	}
+
+	maxEntries := cfg.MaxEntries
+	if maxEntries <= 0 {
+		maxEntries = defaultMetadataCacheConfig.MaxEntries
+	}
+
+	return &metadataCache{
+		ttl:        time.Duration(cfg.TTL) * time.Second,
+		maxEntries: maxEntries,
+		entries:    make(map[metadataKey]*list.Element),
+		lru:        list.New(),
+		now:        time.Now,
+	}
+}
+
+func (c *metadataCache) get(key metadataKey) (any, bool) {
+	if c == nil {
+		return nil, false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, found := c.entries[key]
+	if !found {
+		return nil, false
+	}
+
+	entry := elem.Value.(*metadataEntry)
+	if c.now().After(entry.expiresAt) {
+		c.remove(elem)
+		return nil, false
+	}
+
+	c.lru.MoveToFront(elem)
+	return entry.value, true
+}
+
+func (c *metadataCache) set(key metadataKey, value any) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, found := c.entries[key]; found {
+		c.remove(elem)
+	}
+
+	c.entries[key] = c.lru.PushFront(&metadataEntry{key, value, c.now().Add(c.ttl)})
+
+	for c.lru.Len() > c.maxEntries {
+		c.remove(c.lru.Back())
+	}
+}
+
+// invalidate removes the listings of the database and of the objects in it.
+// If database is empty, the listings of databases are removed as well as everything else.
+func (c *metadataCache) invalidate(database string) {
+	if c == nil {
+		return
+	}
+
+	if database == "" {
+		c.clear()
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for key, elem := range c.entries {
+		if key.database == database {
+			c.remove(elem)
+		}
+	}
+}
+
+func (c *metadataCache) clear() {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.entries = make(map[metadataKey]*list.Element)
+	c.lru.Init()
+}
+
+func (c *metadataCache) remove(elem *list.Element) {
+	entry := c.lru.Remove(elem).(*metadataEntry)
+	delete(c.entries, entry.key)
+}
+
+// cachedMetadata returns the cached listing for the key, or loads and caches it.
+// If refresh is true, the cached listing is ignored and replaced.
+func cachedMetadata[T any](c *metadataCache, key metadataKey, refresh bool, load func() (T, error)) (T, error) {
+	if !refresh {
+		if v, found := c.get(key); found {
+			return v.(T), nil
+		}
+	}
+
+	v, err := load()
+	if err != nil {
+		return v, err
+	}
+
+	c.set(key, v)
+	return v, nil
+}
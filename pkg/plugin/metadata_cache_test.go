@@ -0,0 +1,65 @@
+package plugin
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMetadataCache(t *testing.T) {
+	c := newMetadataCache(&metadataCacheConfig{TTL: 60, MaxEntries: 2})
+	now := time.Date(2024, 3, 19, 13, 0, 0, 0, time.UTC)
+	c.now = func() time.Time { return now }
+
+	sales := metadataKey{role: "ANALYST", kind: "schemas", database: "SALES"}
+	hr := metadataKey{role: "ANALYST", kind: "schemas", database: "HR"}
+	salesAsAdmin := metadataKey{role: "ADMIN", kind: "schemas", database: "SALES"}
+
+	c.set(sales, []string{"PUBLIC"})
+	if _, found := c.get(salesAsAdmin); found {
+		t.Error("a listing must not be shared between roles")
+	}
+
+	c.set(hr, []string{"PUBLIC"})
+	c.get(sales)
+	c.set(salesAsAdmin, []string{"PUBLIC", "PRIVATE"})
+	if _, found := c.get(hr); found {
+		t.Error("the least recently used listing must be evicted")
+	}
+
+	c.invalidate("SALES")
+	if _, found := c.get(sales); found {
+		t.Error("the listing must be invalidated")
+	}
+
+	c.set(hr, []string{"PUBLIC"})
+	now = now.Add(61 * time.Second)
+	if _, found := c.get(hr); found {
+		t.Error("the listing must be expired")
+	}
+}
+
+func TestCachedMetadata(t *testing.T) {
+	c := newMetadataCache(&defaultMetadataCacheConfig)
+	key := metadataKey{kind: "databases"}
+	loads := 0
+	load := func() ([]string, error) {
+		loads++
+		return []string{"SALES"}, nil
+	}
+
+	cachedMetadata(c, key, false, load)
+	cachedMetadata(c, key, false, load)
+	if loads != 1 {
+		t.Errorf("must load once, but loaded %d times", loads)
+	}
+
+	cachedMetadata(c, key, true, load)
+	if loads != 2 {
+		t.Errorf("must reload when refresh is true, but loaded %d times", loads)
+	}
+
+	cachedMetadata(nil, key, false, load)
+	if loads != 3 {
+		t.Errorf("a nil cache must always load, but loaded %d times", loads)
+	}
+}
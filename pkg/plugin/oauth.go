@@ -0,0 +1,213 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	oauthClientCredentials = "client_credentials"
+	oauthRefreshToken      = "refresh_token"
+)
+
+type oauthConfig struct {
+	// TokenURL is the token endpoint of the OAuth authorization server.
+	TokenURL string
+	// GrantType is how an access token is got: "client_credentials" (default) or "refresh_token".
+	GrantType string
+	ClientID  string
+	// Scope is the space separated scopes of the access token, such as "session:role:ANALYST".
+	Scope string
+	// RefreshBefore is how long before its expiry an access token is refreshed, in seconds.
+	RefreshBefore int
+}
+
+var defaultOAuthConfig = oauthConfig{
+	GrantType:     oauthClientCredentials,
+	RefreshBefore: 60,
+}
+
+// oauthTokenTimeout bounds a request to the token endpoint.
+const oauthTokenTimeout = 30 * time.Second
+
+// oauthTokenSource gets the access tokens of the OAuth authentication from the token endpoint,
+// and caches one until it is about to expire. A token without an expiry is not cached.
+type oauthTokenSource struct {
+	mu            sync.Mutex
+	cfg           oauthConfig
+	clientSecret  string
+	refreshToken  string
+	refreshBefore time.Duration
+	client        *http.Client
+	accessToken   string
+	expiresAt     time.Time
+	now           func() time.Time
+}
+
+func newOAuthTokenSource(cfg *oauthConfig, clientSecret string, refreshToken string) (*oauthTokenSource, error) {
+	c := defaultOAuthConfig
+	if cfg != nil {
+		c = *cfg
+	}
+	if c.GrantType == "" {
+		c.GrantType = defaultOAuthConfig.GrantType
+	}
+	if c.RefreshBefore < 0 {
+		c.RefreshBefore = 0
+	}
+
+	u, err := url.Parse(c.TokenURL)
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		return nil, fmt.Errorf("the token URL [%s] must be an absolute http(s) URL", c.TokenURL)
+	}
+
+	switch c.GrantType {
+	case oauthClientCredentials:
+		if c.ClientID == "" || clientSecret == "" {
+			return nil, fmt.Errorf("the grant type [%s] needs a client ID and a client secret", c.GrantType)
+		}
+	case oauthRefreshToken:
+		if refreshToken == "" {
+			return nil, fmt.Errorf("the grant type [%s] needs a refresh token", c.GrantType)
+		}
+	default:
+		return nil, fmt.Errorf("unknown grant type [%s], it must be %s or %s", c.GrantType, oauthClientCredentials, oauthRefreshToken)
+	}
+
+	return &oauthTokenSource{
+		cfg:           c,
+		clientSecret:  clientSecret,
+		refreshToken:  refreshToken,
+		refreshBefore: time.Duration(c.RefreshBefore) * time.Second,
+		client:        &http.Client{Timeout: oauthTokenTimeout},
+		now:           time.Now,
+	}, nil
+}
+
+// token returns the cached access token, or gets a new one if the cached one expires within
+// RefreshBefore. Only one request to the token endpoint is made at a time.
+func (s *oauthTokenSource) token(ctx context.Context) (string, error) {
+	token, _, err := s.connectionToken(ctx)
+	return token, err
+}
+
+// connectionToken returns the access token as token does, and the time it is refreshed, RefreshBefore
+// its expiry, after which a connection logged in with it is not reused. The time is zero for a token
+// without an expiry.
+func (s *oauthTokenSource) connectionToken(ctx context.Context) (string, time.Time, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.accessToken != "" && s.now().Before(s.refreshAt()) {
+		return s.accessToken, s.refreshAt(), nil
+	}
+
+	s.accessToken = ""
+
+	resp, err := s.requestToken(ctx)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	// An authorization server may rotate the refresh token, and the old one is no longer valid.
+	if resp.RefreshToken != "" {
+		s.refreshToken = resp.RefreshToken
+	}
+
+	if resp.ExpiresIn <= 0 {
+		return resp.AccessToken, time.Time{}, nil
+	}
+
+	s.accessToken = resp.AccessToken
+	s.expiresAt = s.now().Add(time.Duration(resp.ExpiresIn) * time.Second)
+	return s.accessToken, s.refreshAt(), nil
+}
+
+func (s *oauthTokenSource) refreshAt() time.Time {
+	return s.expiresAt.Add(-s.refreshBefore)
+}
+
+type oauthTokenResponse struct {
+	AccessToken      string         `json:"access_token"`
+	ExpiresIn        oauthExpiresIn `json:"expires_in"`
+	RefreshToken     string         `json:"refresh_token"`
+	Error            string         `json:"error"`
+	ErrorDescription string         `json:"error_description"`
+}
+
+// oauthExpiresIn is the lifetime of an access token in seconds. Some authorization servers
+// send it as a string.
+type oauthExpiresIn int64
+
+func (e *oauthExpiresIn) UnmarshalJSON(b []byte) error {
+	if string(b) == "null" {
+		return nil
+	}
+	n, err := strconv.ParseInt(strings.Trim(string(b), `"`), 10, 64)
+	if err != nil {
+		return fmt.Errorf("expires_in [%s] is not a number", string(b))
+	}
+	*e = oauthExpiresIn(n)
+	return nil
+}
+
+func (s *oauthTokenSource) requestToken(ctx context.Context) (*oauthTokenResponse, error) {
+	form := url.Values{"grant_type": {s.cfg.GrantType}}
+	if s.cfg.Scope != "" {
+		form.Set("scope", s.cfg.Scope)
+	}
+	if s.cfg.GrantType == oauthRefreshToken {
+		form.Set("refresh_token", s.refreshToken)
+	}
+	if s.clientSecret == "" && s.cfg.ClientID != "" {
+		// A public client identifies itself without a secret.
+		form.Set("client_id", s.cfg.ClientID)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.cfg.TokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create the token request: [%v]", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if s.clientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(s.cfg.ClientID), url.QueryEscape(s.clientSecret))
+	}
+
+	res, err := s.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to request a token from [%s]: [%v]", s.cfg.TokenURL, err)
+	}
+	defer res.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(res.Body, 1<<20))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the token response: [%v]", err)
+	}
+
+	var resp oauthTokenResponse
+	if err := json.Unmarshal(body, &resp); err != nil && res.StatusCode == http.StatusOK {
+		return nil, fmt.Errorf("failed to unmarshal the token response: [%v]", err)
+	}
+
+	if res.StatusCode != http.StatusOK || resp.Error != "" {
+		if resp.Error != "" {
+			return nil, fmt.Errorf("the token endpoint responded [%s]: %s %s", res.Status, resp.Error, resp.ErrorDescription)
+		}
+		return nil, fmt.Errorf("the token endpoint responded [%s]", res.Status)
+	}
+
+	if resp.AccessToken == "" {
+		return nil, fmt.Errorf("the token response has no access_token")
+	}
+
+	return &resp, nil
+}
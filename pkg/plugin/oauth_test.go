@@ -0,0 +1,198 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeTokenServer is a token endpoint which issues "token-1", "token-2", ... and rotates
+// the refresh token on every refresh.
+type fakeTokenServer struct {
+	mu           sync.Mutex
+	requests     int
+	expiresIn    any
+	refreshToken string
+	forms        []map[string]string
+}
+
+func (f *fakeTokenServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	user, pass, _ := r.BasicAuth()
+	f.forms = append(f.forms, map[string]string{
+		"grant_type":    r.PostForm.Get("grant_type"),
+		"refresh_token": r.PostForm.Get("refresh_token"),
+		"scope":         r.PostForm.Get("scope"),
+		"client":        user + ":" + pass,
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.PostForm.Get("grant_type") == oauthRefreshToken && r.PostForm.Get("refresh_token") != f.refreshToken {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant", "error_description": "the refresh token is revoked"})
+		return
+	}
+
+	f.requests++
+	resp := map[string]any{
+		"access_token": fmt.Sprintf("token-%d", f.requests),
+		"token_type":   "Bearer",
+	}
+	if f.expiresIn != nil {
+		resp["expires_in"] = f.expiresIn
+	}
+	if f.refreshToken != "" {
+		f.refreshToken = fmt.Sprintf("refresh-%d", f.requests)
+		resp["refresh_token"] = f.refreshToken
+	}
+	json.NewEncoder(w).Encode(resp)
+}
+
+func TestOAuthTokenSourceClientCredentials(t *testing.T) {
+	fake := &fakeTokenServer{expiresIn: 600}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	ts, err := newOAuthTokenSource(&oauthConfig{TokenURL: server.URL, ClientID: "sunflake", Scope: "session:role:ANALYST", RefreshBefore: 60}, "secret", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Date(2024, 3, 19, 13, 0, 0, 0, time.UTC)
+	now := start
+	ts.now = func() time.Time { return now }
+
+	tests := []struct {
+		after     time.Duration
+		expected  string
+		refreshAt time.Duration
+	}{
+		{0, "token-1", 9 * time.Minute},
+		{5 * time.Minute, "token-1", 9 * time.Minute},
+		// The token expires in 10 minutes, and it is refreshed 60 seconds before.
+		{4*time.Minute + 30*time.Second, "token-2", 18*time.Minute + 30*time.Second},
+		{8 * time.Minute, "token-2", 18*time.Minute + 30*time.Second},
+		{time.Hour, "token-3", 86*time.Minute + 30*time.Second},
+	}
+
+	for _, tt := range tests {
+		now = now.Add(tt.after)
+
+		token, refreshAt, err := ts.connectionToken(context.Background())
+		if err != nil {
+			t.Fatalf("failed to get a token: %v", err)
+		}
+		if token != tt.expected {
+			t.Errorf("expected [%s] after %v, but got [%s]", tt.expected, tt.after, token)
+		}
+		// A connection logged in with the token is not reused after the token is refreshed.
+		if expected := start.Add(tt.refreshAt); !refreshAt.Equal(expected) {
+			t.Errorf("expected [%s] to be refreshed at [%v], but got [%v]", token, expected, refreshAt)
+		}
+	}
+
+	if fake.requests != 3 {
+		t.Errorf("expected 3 token requests, but got %d", fake.requests)
+	}
+
+	form := fake.forms[0]
+	if form["grant_type"] != oauthClientCredentials || form["scope"] != "session:role:ANALYST" || form["client"] != "sunflake:secret" {
+		t.Errorf("unexpected token request: %v", form)
+	}
+}
+
+func TestOAuthTokenSourceRefreshToken(t *testing.T) {
+	fake := &fakeTokenServer{expiresIn: "3600", refreshToken: "refresh-0"}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	ts, err := newOAuthTokenSource(&oauthConfig{TokenURL: server.URL, GrantType: oauthRefreshToken, ClientID: "sunflake"}, "", "refresh-0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Date(2024, 3, 19, 13, 0, 0, 0, time.UTC)
+	ts.now = func() time.Time { return now }
+
+	for i := 1; i <= 3; i++ {
+		token, err := ts.token(context.Background())
+		if err != nil {
+			t.Fatalf("failed to refresh the token %d: %v", i, err)
+		}
+		if expected := fmt.Sprintf("token-%d", i); token != expected {
+			t.Errorf("expected [%s], but got [%s]", expected, token)
+		}
+		now = now.Add(time.Hour)
+	}
+
+	// Every refresh sends the refresh token rotated by the previous one.
+	for i, form := range fake.forms {
+		if expected := fmt.Sprintf("refresh-%d", i); form["refresh_token"] != expected {
+			t.Errorf("expected the refresh token [%s], but got [%s]", expected, form["refresh_token"])
+		}
+	}
+
+	fake.refreshToken = "revoked"
+	now = now.Add(time.Hour)
+	if _, err := ts.token(context.Background()); err == nil {
+		t.Error("expected an error from a revoked refresh token")
+	}
+}
+
+func TestOAuthTokenSourceNoExpiry(t *testing.T) {
+	fake := &fakeTokenServer{}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	ts, err := newOAuthTokenSource(&oauthConfig{TokenURL: server.URL, ClientID: "sunflake"}, "secret", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		_, refreshAt, err := ts.connectionToken(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !refreshAt.IsZero() {
+			t.Errorf("expected a connection with a token without an expiry to be reused, but got [%v]", refreshAt)
+		}
+	}
+
+	if fake.requests != 2 {
+		t.Errorf("expected a token without an expiry not to be cached, but got %d requests", fake.requests)
+	}
+}
+
+func TestNewOAuthTokenSourceError(t *testing.T) {
+	tests := []struct {
+		cfg          *oauthConfig
+		clientSecret string
+		refreshToken string
+	}{
+		{nil, "secret", ""},
+		{&oauthConfig{TokenURL: "/oauth/token", ClientID: "sunflake"}, "secret", ""},
+		{&oauthConfig{TokenURL: "https://idp.example.com/token", ClientID: "sunflake"}, "", ""},
+		{&oauthConfig{TokenURL: "https://idp.example.com/token", GrantType: oauthRefreshToken}, "secret", ""},
+		{&oauthConfig{TokenURL: "https://idp.example.com/token", GrantType: "password"}, "secret", "refresh"},
+	}
+
+	for _, tt := range tests {
+		if _, err := newOAuthTokenSource(tt.cfg, tt.clientSecret, tt.refreshToken); err == nil {
+			t.Errorf("expected an error from %#v", tt.cfg)
+		}
+	}
+}
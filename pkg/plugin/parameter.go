@@ -0,0 +1,195 @@
+package plugin
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	gs "github.com/snowflakedb/gosnowflake"
+)
+
+const (
+	parameterText      = "text"
+	parameterNumber    = "number"
+	parameterBoolean   = "boolean"
+	parameterDate      = "date"
+	parameterTimestamp = "timestamp"
+)
+
+// queryParameter is a named parameter of a query, such as :region, which is sent to Snowflake as
+// a bind variable. Value is a JSON value of the type, or an array of them, which is bound as a list
+// of bind variables, such as in "region IN (:region)".
+type queryParameter struct {
+	Name  string
+	Type  string
+	Value any
+}
+
+// bindParameters replaces the named parameters in the SQL with bind variables, and returns
+// the values to bind in their order. A name is a parameter only if it is given in params, and not
+// written right after a name, a colon or a closing bracket, so that a cast such as a::DATE, and
+// a path of a semi-structured value, such as v:region, are left as they are.
+func bindParameters(src string, params []queryParameter) (string, []any, error) {
+	if len(params) == 0 {
+		return src, nil, nil
+	}
+
+	bound := make(map[string]boundParameter, len(params))
+	for _, p := range params {
+		if p.Name == "" {
+			return "", nil, fmt.Errorf("a parameter has no name")
+		}
+
+		b, err := p.bind()
+		if err != nil {
+			return "", nil, fmt.Errorf("failed to bind the parameter [:%s]: %v", p.Name, err)
+		}
+		bound[p.Name] = b
+	}
+
+	var sb strings.Builder
+	var args []any
+	currIndex := 0
+
+	for i := 0; i < len(src); {
+		end, err := skipLiteral(src, i)
+		if err != nil {
+			return "", nil, err
+		}
+		if end > i {
+			i = end
+			continue
+		}
+
+		if src[i] != ':' || (i > 0 && isParameterPrefix(src[i-1])) {
+			i++
+			continue
+		}
+
+		end = i + 1
+		for end < len(src) && isIdentifierByte(src[end]) && src[end] != '$' {
+			end++
+		}
+
+		b, found := bound[src[i+1:end]]
+		if !found {
+			i = end
+			continue
+		}
+
+		sb.WriteString(src[currIndex:i])
+		sb.WriteString(strings.TrimSuffix(strings.Repeat("?, ", b.count), ", "))
+		args = append(args, b.args...)
+		currIndex = end
+		i = end
+	}
+
+	sb.WriteString(src[currIndex:])
+	return sb.String(), args, nil
+}
+
+func isParameterPrefix(b byte) bool {
+	return isIdentifierByte(b) || b == ':' || b == ']' || b == ')' || b == '"'
+}
+
+// boundParameter is a parameter written as count bind variables, and args are their values.
+// A date or a timestamp is preceded by the type of the bind variable, which gosnowflake takes
+// for the following time.Time.
+type boundParameter struct {
+	args  []any
+	count int
+}
+
+func (p queryParameter) bind() (boundParameter, error) {
+	items, ok := p.Value.([]any)
+	if !ok {
+		items = []any{p.Value}
+	}
+
+	if len(items) == 0 {
+		return boundParameter{}, fmt.Errorf("the list has no value")
+	}
+
+	b := boundParameter{count: len(items)}
+	for _, item := range items {
+		if item == nil {
+			return boundParameter{}, fmt.Errorf("the value is null")
+		}
+
+		v, err := parameterValue(p.Type, item)
+		if err != nil {
+			return boundParameter{}, err
+		}
+
+		switch p.Type {
+		case parameterDate:
+			b.args = append(b.args, gs.DataTypeDate)
+		case parameterTimestamp:
+			b.args = append(b.args, gs.DataTypeTimestampTz)
+		}
+		b.args = append(b.args, v)
+	}
+
+	return b, nil
+}
+
+// parameterValue converts the JSON value to the Go value of the type. A date or a timestamp is
+// a string, such as "2024-03-19" or "2024-03-19T13:00:00Z", or milliseconds since the epoch.
+func parameterValue(typ string, v any) (any, error) {
+	switch typ {
+	case parameterText, "":
+		if s, ok := v.(string); ok {
+			return s, nil
+		}
+		return fmt.Sprint(v), nil
+	case parameterNumber:
+		switch n := v.(type) {
+		case float64:
+			if n == math.Trunc(n) && math.Abs(n) < 1<<53 {
+				return int64(n), nil
+			}
+			return n, nil
+		case string:
+			if i, err := strconv.ParseInt(strings.TrimSpace(n), 10, 64); err == nil {
+				return i, nil
+			}
+			f, err := strconv.ParseFloat(strings.TrimSpace(n), 64)
+			if err != nil || math.IsNaN(f) || math.IsInf(f, 0) {
+				return nil, fmt.Errorf("the value [%s] is not a number", n)
+			}
+			return f, nil
+		}
+	case parameterBoolean:
+		switch b := v.(type) {
+		case bool:
+			return b, nil
+		case string:
+			parsed, err := strconv.ParseBool(strings.TrimSpace(b))
+			if err != nil {
+				return nil, fmt.Errorf("the value [%s] is not a boolean", b)
+			}
+			return parsed, nil
+		}
+	case parameterDate, parameterTimestamp:
+		switch t := v.(type) {
+		case float64:
+			return time.UnixMilli(int64(t)).UTC(), nil
+		case string:
+			for _, layout := range []string{time.RFC3339Nano, time.DateOnly} {
+				if parsed, err := time.Parse(layout, strings.TrimSpace(t)); err == nil {
+					return parsed, nil
+				}
+			}
+			if ms, err := strconv.ParseInt(strings.TrimSpace(t), 10, 64); err == nil {
+				return time.UnixMilli(ms).UTC(), nil
+			}
+			return nil, fmt.Errorf("the value [%s] is not a date or a time", t)
+		}
+	default:
+		return nil, fmt.Errorf("unknown type [%s]", typ)
+	}
+
+	return nil, fmt.Errorf("the value [%v] is not a %s", v, typ)
+}
@@ -0,0 +1,90 @@
+package plugin
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+
+	gs "github.com/snowflakedb/gosnowflake"
+)
+
+func TestBindParameters(t *testing.T) {
+	from := time.Date(2024, 3, 19, 13, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		sql          string
+		params       string
+		expectedSQL  string
+		expectedArgs []any
+	}{
+		{"SELECT * FROM t WHERE region = :region",
+			`[{"name": "region", "value": "it's"}]`,
+			"SELECT * FROM t WHERE region = ?",
+			[]any{"it's"}},
+		{"SELECT * FROM t WHERE region IN (:region) AND size > :size AND active = :active",
+			`[{"name": "size", "type": "number", "value": "10"}, {"name": "region", "type": "text", "value": ["kr", "jp"]}, {"name": "active", "type": "boolean", "value": true}]`,
+			"SELECT * FROM t WHERE region IN (?, ?) AND size > ? AND active = ?",
+			[]any{"kr", "jp", int64(10), true}},
+		{"SELECT * FROM t WHERE ts >= :from AND ts < :from",
+			`[{"name": "from", "type": "timestamp", "value": "2024-03-19T13:00:00Z"}]`,
+			"SELECT * FROM t WHERE ts >= ? AND ts < ?",
+			[]any{gs.DataTypeTimestampTz, from, gs.DataTypeTimestampTz, from}},
+		{"SELECT * FROM t WHERE day = :day AND ratio < :ratio",
+			`[{"name": "day", "type": "date", "value": 1710853200000}, {"name": "ratio", "type": "number", "value": 0.5}]`,
+			"SELECT * FROM t WHERE day = ? AND ratio < ?",
+			[]any{gs.DataTypeDate, from, 0.5}},
+		{"SELECT v:region, a::DATE, ':region', \":region\", :other -- :region\nFROM t WHERE r = :region",
+			`[{"name": "region", "value": "kr"}]`,
+			"SELECT v:region, a::DATE, ':region', \":region\", :other -- :region\nFROM t WHERE r = ?",
+			[]any{"kr"}},
+		{"SELECT :region",
+			`[]`,
+			"SELECT :region",
+			nil},
+	}
+
+	for _, tt := range tests {
+		var params []queryParameter
+		if err := json.Unmarshal([]byte(tt.params), &params); err != nil {
+			t.Fatal(err)
+		}
+
+		sql, args, err := bindParameters(tt.sql, params)
+		if err != nil {
+			t.Errorf("failed to bind [%s]: %v", tt.sql, err)
+			continue
+		}
+
+		if sql != tt.expectedSQL {
+			t.Errorf("expected [%s], but got [%s]", tt.expectedSQL, sql)
+		}
+
+		if !reflect.DeepEqual(args, tt.expectedArgs) {
+			t.Errorf("expected the args %#v, but got %#v", tt.expectedArgs, args)
+		}
+	}
+}
+
+func TestBindParametersError(t *testing.T) {
+	tests := []string{
+		`[{"name": "size", "type": "number", "value": "10 OR 1=1"}]`,
+		`[{"name": "active", "type": "boolean", "value": "yes"}]`,
+		`[{"name": "from", "type": "timestamp", "value": "yesterday"}]`,
+		`[{"name": "region", "type": "text", "value": []}]`,
+		`[{"name": "region", "type": "text"}]`,
+		`[{"name": "region", "type": "array", "value": "kr"}]`,
+		`[{"type": "text", "value": "kr"}]`,
+	}
+
+	for _, raw := range tests {
+		var params []queryParameter
+		if err := json.Unmarshal([]byte(raw), &params); err != nil {
+			t.Fatal(err)
+		}
+
+		if sql, _, err := bindParameters("SELECT :size, :active, :from, :region", params); err == nil {
+			t.Errorf("expected an error from %s, but got [%s]", raw, sql)
+		}
+	}
+}
@@ -0,0 +1,280 @@
+package plugin
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/des"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"hash"
+	"strings"
+
+	"golang.org/x/crypto/pbkdf2"
+	"golang.org/x/crypto/scrypt"
+)
+
+var (
+	errPrivateKeyNotPEM = errors.New(
+		"the private key is not a PEM block, it must be pasted with its -----BEGIN and -----END lines")
+	errPrivateKeyMalformed = errors.New(
+		"the PEM block of the private key is malformed, the key may be truncated or altered")
+	errPrivateKeyNotRSA = errors.New(
+		"the private key is not an RSA private key, Snowflake key pair authentication needs an RSA key")
+	errPrivateKeyPassphraseRequired = errors.New(
+		"the private key is encrypted, set its passphrase in the Private Key Passphrase")
+	errPrivateKeyWrongPassphrase = errors.New(
+		"failed to decrypt the private key, the Private Key Passphrase may be wrong")
+	errPrivateKeyUnsupported = errors.New(
+		"the encryption of the private key is not supported, convert it with 'openssl pkcs8 -topk8 -v2 aes256'")
+)
+
+// parsePrivateKey parses the RSA private key of the key pair authentication. The key is a PEM block
+// of an unencrypted PKCS#8 ("PRIVATE KEY"), a PKCS#1 ("RSA PRIVATE KEY"), or a PKCS#8 encrypted
+// with PBES2 ("ENCRYPTED PRIVATE KEY"), which is decrypted with the passphrase.
+// Every error wraps one of the errPrivateKey errors, which tells what to fix.
+func parsePrivateKey(key string, passphrase string) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode([]byte(strings.TrimSpace(key)))
+	if block == nil {
+		return nil, errPrivateKeyNotPEM
+	}
+
+	var privateKey any
+	var err error
+
+	switch block.Type {
+	case "PRIVATE KEY":
+		privateKey, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", errPrivateKeyMalformed, err)
+		}
+	case "RSA PRIVATE KEY":
+		if _, found := block.Headers["DEK-Info"]; found {
+			return nil, fmt.Errorf("%w: the PKCS#1 key is encrypted with the legacy PEM encryption", errPrivateKeyUnsupported)
+		}
+		privateKey, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", errPrivateKeyMalformed, err)
+		}
+	case "ENCRYPTED PRIVATE KEY":
+		if passphrase == "" {
+			return nil, errPrivateKeyPassphraseRequired
+		}
+		privateKey, err = decryptPKCS8PrivateKey(block.Bytes, []byte(passphrase))
+		if err != nil {
+			return nil, err
+		}
+	case "EC PRIVATE KEY", "OPENSSH PRIVATE KEY", "DSA PRIVATE KEY":
+		return nil, fmt.Errorf("%w: the PEM block is [%s]", errPrivateKeyNotRSA, block.Type)
+	default:
+		return nil, fmt.Errorf("%w: the PEM block is [%s], but it must be a private key", errPrivateKeyNotPEM, block.Type)
+	}
+
+	pk, ok := privateKey.(*rsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("%w: the key is %T", errPrivateKeyNotRSA, privateKey)
+	}
+
+	return pk, nil
+}
+
+var (
+	oidPBES2      = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 13}
+	oidPBKDF2     = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 12}
+	oidScrypt     = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 11591, 4, 11}
+	oidHMACSHA1   = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 7}
+	oidHMACSHA224 = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 8}
+	oidHMACSHA256 = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 9}
+	oidHMACSHA384 = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 10}
+	oidHMACSHA512 = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 11}
+	oidAES128CBC  = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 2}
+	oidAES192CBC  = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 22}
+	oidAES256CBC  = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 42}
+	oidDESEDE3CBC = asn1.ObjectIdentifier{1, 2, 840, 113549, 3, 7}
+)
+
+// encryptedPrivateKeyInfo and the following structures are the ASN.1 of RFC 5958 and RFC 8018.
+type encryptedPrivateKeyInfo struct {
+	Algorithm     algorithmIdentifier
+	EncryptedData []byte
+}
+
+type algorithmIdentifier struct {
+	Algorithm  asn1.ObjectIdentifier
+	Parameters asn1.RawValue `asn1:"optional"`
+}
+
+type pbes2Params struct {
+	KeyDerivationFunc algorithmIdentifier
+	EncryptionScheme  algorithmIdentifier
+}
+
+type pbkdf2Params struct {
+	Salt           []byte
+	IterationCount int
+	KeyLength      int                 `asn1:"optional"`
+	PRF            algorithmIdentifier `asn1:"optional"`
+}
+
+type scryptParams struct {
+	Salt                     []byte
+	CostParameter            int
+	BlockSize                int
+	ParallelizationParameter int
+	KeyLength                int `asn1:"optional"`
+}
+
+// The limits of the key derivation, whose parameters are read from the key. They are far above what
+// openssl uses, but keep a crafted key from spending minutes of CPU or gigabytes of memory.
+const (
+	maxPBKDF2Iterations = 10_000_000
+	// maxScryptMemory bounds 128*N*r bytes, which scrypt allocates.
+	maxScryptMemory = 256 << 20
+	maxScryptP      = 16
+)
+
+// decryptPKCS8PrivateKey decrypts the PKCS#8 encrypted with PBES2, which is the encryption of
+// "openssl pkcs8 -topk8 -v2", and parses the decrypted PKCS#8.
+func decryptPKCS8PrivateKey(der []byte, passphrase []byte) (any, error) {
+	var info encryptedPrivateKeyInfo
+	if rest, err := asn1.Unmarshal(der, &info); err != nil || len(rest) > 0 {
+		return nil, fmt.Errorf("%w: failed to parse the encrypted private key", errPrivateKeyMalformed)
+	}
+
+	if !info.Algorithm.Algorithm.Equal(oidPBES2) {
+		return nil, fmt.Errorf("%w: the algorithm [%s] is not PBES2", errPrivateKeyUnsupported, info.Algorithm.Algorithm)
+	}
+
+	var params pbes2Params
+	if _, err := asn1.Unmarshal(info.Algorithm.Parameters.FullBytes, &params); err != nil {
+		return nil, fmt.Errorf("%w: failed to parse the PBES2 parameters", errPrivateKeyMalformed)
+	}
+
+	newCipher, keyLen, err := pbes2Cipher(params.EncryptionScheme.Algorithm)
+	if err != nil {
+		return nil, err
+	}
+
+	var iv []byte
+	if _, err := asn1.Unmarshal(params.EncryptionScheme.Parameters.FullBytes, &iv); err != nil {
+		return nil, fmt.Errorf("%w: failed to parse the IV of the cipher", errPrivateKeyMalformed)
+	}
+
+	key, err := pbes2Key(params.KeyDerivationFunc, passphrase, keyLen)
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := newCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errPrivateKeyMalformed, err)
+	}
+
+	data := info.EncryptedData
+	if len(iv) != block.BlockSize() || len(data) == 0 || len(data)%block.BlockSize() != 0 {
+		return nil, fmt.Errorf("%w: the encrypted data does not fit the cipher", errPrivateKeyMalformed)
+	}
+
+	decrypted := make([]byte, len(data))
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(decrypted, data)
+
+	// A wrong passphrase gives random bytes, whose padding is almost always invalid.
+	padding := int(decrypted[len(decrypted)-1])
+	if padding == 0 || padding > block.BlockSize() {
+		return nil, errPrivateKeyWrongPassphrase
+	}
+	for _, b := range decrypted[len(decrypted)-padding:] {
+		if int(b) != padding {
+			return nil, errPrivateKeyWrongPassphrase
+		}
+	}
+
+	privateKey, err := x509.ParsePKCS8PrivateKey(decrypted[:len(decrypted)-padding])
+	if err != nil {
+		return nil, errPrivateKeyWrongPassphrase
+	}
+
+	return privateKey, nil
+}
+
+func pbes2Cipher(oid asn1.ObjectIdentifier) (func([]byte) (cipher.Block, error), int, error) {
+	switch {
+	case oid.Equal(oidAES128CBC):
+		return aes.NewCipher, 16, nil
+	case oid.Equal(oidAES192CBC):
+		return aes.NewCipher, 24, nil
+	case oid.Equal(oidAES256CBC):
+		return aes.NewCipher, 32, nil
+	case oid.Equal(oidDESEDE3CBC):
+		return des.NewTripleDESCipher, 24, nil
+	}
+
+	return nil, 0, fmt.Errorf("%w: the cipher [%s]", errPrivateKeyUnsupported, oid)
+}
+
+func pbes2Key(kdf algorithmIdentifier, passphrase []byte, keyLen int) ([]byte, error) {
+	switch {
+	case kdf.Algorithm.Equal(oidPBKDF2):
+		var params pbkdf2Params
+		if _, err := asn1.Unmarshal(kdf.Parameters.FullBytes, &params); err != nil {
+			return nil, fmt.Errorf("%w: failed to parse the PBKDF2 parameters", errPrivateKeyMalformed)
+		}
+
+		prf, err := pbkdf2Hash(params.PRF.Algorithm)
+		if err != nil {
+			return nil, err
+		}
+
+		if params.IterationCount <= 0 || (params.KeyLength != 0 && params.KeyLength != keyLen) {
+			return nil, fmt.Errorf("%w: the PBKDF2 parameters do not fit the cipher", errPrivateKeyMalformed)
+		}
+		if params.IterationCount > maxPBKDF2Iterations {
+			return nil, fmt.Errorf("%w: the PBKDF2 iteration count [%d] exceeds %d", errPrivateKeyUnsupported, params.IterationCount, maxPBKDF2Iterations)
+		}
+
+		return pbkdf2.Key(passphrase, params.Salt, params.IterationCount, keyLen, prf), nil
+	case kdf.Algorithm.Equal(oidScrypt):
+		var params scryptParams
+		if _, err := asn1.Unmarshal(kdf.Parameters.FullBytes, &params); err != nil {
+			return nil, fmt.Errorf("%w: failed to parse the scrypt parameters", errPrivateKeyMalformed)
+		}
+
+		n, r, p := params.CostParameter, params.BlockSize, params.ParallelizationParameter
+		if n <= 0 || r <= 0 || p <= 0 || n > maxScryptMemory/128/r || p > maxScryptP {
+			return nil, fmt.Errorf("%w: the scrypt parameters N=%d, r=%d, p=%d exceed the limits", errPrivateKeyUnsupported, n, r, p)
+		}
+
+		key, err := scrypt.Key(passphrase, params.Salt, n, r, p, keyLen)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", errPrivateKeyMalformed, err)
+		}
+
+		return key, nil
+	}
+
+	return nil, fmt.Errorf("%w: the key derivation function [%s]", errPrivateKeyUnsupported, kdf.Algorithm)
+}
+
+// pbkdf2Hash returns the hash of the pseudorandom function, which is HMAC-SHA1 if it is not given.
+func pbkdf2Hash(oid asn1.ObjectIdentifier) (func() hash.Hash, error) {
+	switch {
+	case len(oid) == 0, oid.Equal(oidHMACSHA1):
+		return sha1.New, nil
+	case oid.Equal(oidHMACSHA224):
+		return sha256.New224, nil
+	case oid.Equal(oidHMACSHA256):
+		return sha256.New, nil
+	case oid.Equal(oidHMACSHA384):
+		return sha512.New384, nil
+	case oid.Equal(oidHMACSHA512):
+		return sha512.New, nil
+	}
+
+	return nil, fmt.Errorf("%w: the pseudorandom function [%s]", errPrivateKeyUnsupported, oid)
+}
@@ -3,41 +3,95 @@ package plugin
 import (
 	"context"
 	"database/sql"
+	"database/sql/driver"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"regexp"
-	"strconv"
 	"strings"
 	"time"
 
 	"github.com/grafana/grafana-plugin-sdk-go/backend"
 	"github.com/grafana/grafana-plugin-sdk-go/backend/gtime"
 	"github.com/grafana/grafana-plugin-sdk-go/data"
+
+	sf "github.com/nexon/sunflake/pkg/snowflake"
 	"github.com/nexon/sunflake/pkg/util/er"
+	"github.com/nexon/sunflake/pkg/util/log"
+	gs "github.com/snowflakedb/gosnowflake"
 )
 
 type queryJson struct {
 	QueryText  string
 	DataFormat string
+	// EditorMode is "builder" when the query is made in the builder mode. The SQL is generated
+	// from QueryBuilder or TimeSeries then, and QueryText is ignored.
+	EditorMode      string
+	QueryBuilder    *queryBuilderJson
+	TimeSeries      *timeSeriesJson
+	SnowflakeObject *snowflakeObjectJson
+	// QueryTimeout is the timeout of the query in seconds. It can only be shorter than the timeout of the datasource.
+	QueryTimeout int
+	// MaxRows is the maximum number of rows to read. It can only be lower than the limit of the datasource.
+	MaxRows       int
+	FailOnMaxRows bool
+	// FlattenJSON replaces a VARIANT or OBJECT column with a column for each of its top-level keys.
+	FlattenJSON bool
+	// Fill is how to fill the missing values of the columns by their names, such as {"count": "0"}.
+	// It overrides the fill of $__timeGroup, and needs $__timeGroup for the interval.
+	Fill map[string]string
+	// MultiStatement runs the query as statements separated by semicolons, such as SET statements
+	// followed by a SELECT. It is "all" to return a frame for each statement, or "last" to return
+	// only the frame of the last statement.
+	MultiStatement string
+	// Parameters are the named parameters in QueryText, such as :region. Their values are sent
+	// to Snowflake as bind variables instead of being written into the SQL.
+	Parameters []queryParameter
 }
 
+const (
+	multiStatementAll  = "all"
+	multiStatementLast = "last"
+)
+
+// resetTimeout bounds setting the statement timeout of a session back after a query.
+const resetTimeout = 10 * time.Second
+
 type queryModel struct {
-	raw               string
-	from              time.Time
-	to                time.Time
-	interval          time.Duration
+	raw      string
+	from     time.Time
+	to       time.Time
+	interval time.Duration
+	// location is the Timezone of the datasource, or nil if it does not set one.
+	location          *time.Location
+	weekStart         time.Weekday
+	macros            macroRegistry
+	readOnly          *readOnlyGuard
+	step              timeStep
+	timeout           time.Duration
+	sessionTimeout    time.Duration
+	maxRows           int
+	failOnMaxRows     bool
+	multiStatement    string
+	arrowFetch        bool
+	flattenJSON       bool
 	sql               string
+	args              []any
 	isTimeseries      bool
 	shouldFillMissing bool
-	fillMissingOption *data.FillMissing
+	fillMissingOption *fillMissing
+	fieldFills        map[string]*fillMissing
+	cacheStatus       string
+	// trimToRange is true when the macros were evaluated in the range aligned for the result cache,
+	// and the rows outside from and to are trimmed from the result.
+	trimToRange bool
+	user        string
+	notices     []data.Notice
 }
 
 type any = interface{}
 type anyp = *any
 
-var matchMacro, _ = regexp.Compile(`\$([_a-zA-Z0-9]+)\(([^\)]*)\)`)
-
-func buildQueryModel(query *backend.DataQuery) (*queryModel, error) {
+func buildQueryModel(query *backend.DataQuery, dm *datasourceModel) (*queryModel, error) {
 	var qj queryJson
 
 	// Unmarshal the JSON into our queryJson.
@@ -47,79 +101,159 @@ func buildQueryModel(query *backend.DataQuery) (*queryModel, error) {
 
 	var isTimeseries bool = qj.DataFormat == "timeseries" || qj.DataFormat == ""
 
+	raw := qj.QueryText
+	if qj.EditorMode == editorModeBuilder {
+		var err error
+		if raw, err = qj.builderSQL(); err != nil {
+			return nil, fmt.Errorf("failed to build the query: [%v]", err)
+		}
+	}
+
 	qm := queryModel{
-		raw:               qj.QueryText,
+		raw:               raw,
 		from:              query.TimeRange.From,
 		to:                query.TimeRange.To,
 		interval:          query.Interval,
+		weekStart:         time.Monday,
 		isTimeseries:      isTimeseries,
+		flattenJSON:       qj.FlattenJSON,
+		multiStatement:    qj.MultiStatement,
 		shouldFillMissing: false,
-		fillMissingOption: &data.FillMissing{
-			Mode: data.FillModeNull,
+		fillMissingOption: &fillMissing{
+			mode: fillModeNull,
 		},
 	}
 
-	if err := qm.evalAllMacros(); err != nil {
+	if dm != nil {
+		qm.location = dm.location
+		qm.timeout = queryTimeout(dm.QueryTimeout, qj.QueryTimeout)
+		qm.sessionTimeout = time.Duration(dm.QueryTimeout) * time.Second
+		qm.maxRows = maxRows(dm.MaxRows, qj.MaxRows)
+		qm.failOnMaxRows = dm.FailOnMaxRows || qj.FailOnMaxRows
+		qm.arrowFetch = dm.ArrowFetch
+		qm.weekStart = dm.weekStart
+		qm.macros = dm.macros
+		qm.readOnly = dm.readOnly
+	}
+
+	switch qm.multiStatement {
+	case "", multiStatementAll, multiStatementLast:
+	default:
+		return nil, fmt.Errorf("failed to set the multiStatement: it must be %q or %q, but got [%s]", multiStatementAll, multiStatementLast, qm.multiStatement)
+	}
+
+	if dm != nil && dm.ResultCache.enabled() {
+		from, to := qm.from, qm.to
+		qm.from, qm.to = alignTimeRange(from, to, qm.interval)
+		if err := qm.evalAllMacros(); err != nil {
+			return nil, fmt.Errorf("failed to evaluate the macro: [%v]", err)
+		}
+		qm.from, qm.to = from, to
+		qm.trimToRange = true
+	} else if err := qm.evalAllMacros(); err != nil {
 		return nil, fmt.Errorf("failed to evaluate the macro: [%v]", err)
 	}
 
+	var err error
+	qm.sql, qm.args, err = bindParameters(qm.sql, qj.Parameters)
+	if err != nil {
+		return nil, fmt.Errorf("failed to bind the parameters: [%v]", err)
+	}
+
+	if len(qj.Fill) > 0 {
+		if qm.step.unit == "" {
+			return nil, fmt.Errorf("failed to set the fill: the query needs $__timeGroup to fill missing values")
+		}
+
+		qm.fieldFills = make(map[string]*fillMissing, len(qj.Fill))
+		for name, fill := range qj.Fill {
+			fm, err := parseFillMissing(fill)
+			if err != nil {
+				return nil, fmt.Errorf("failed to set the fill of [%s]: %v", name, err)
+			}
+			qm.fieldFills[strings.ToLower(name)] = fm
+		}
+		qm.shouldFillMissing = true
+	}
+
 	return &qm, nil
 }
 
+// queryTimeout returns the timeout of a query. The timeout of the datasource is the upper bound,
+// and a query can only shorten it.
+func queryTimeout(datasourceTimeout int, timeout int) time.Duration {
+	if timeout <= 0 || (datasourceTimeout > 0 && timeout > datasourceTimeout) {
+		timeout = datasourceTimeout
+	}
+
+	return time.Duration(timeout) * time.Second
+}
+
+// maxRows returns the row limit of a query. The limit of the datasource is the upper bound,
+// and a query can only lower it.
+func maxRows(datasourceMaxRows int, maxRows int) int {
+	if maxRows <= 0 || (datasourceMaxRows > 0 && maxRows > datasourceMaxRows) {
+		return datasourceMaxRows
+	}
+
+	return maxRows
+}
+
 func (qm *queryModel) evalAllMacros() error {
-	matches := matchMacro.FindAllStringSubmatchIndex(qm.raw, -1)
+	macros := qm.macros
+	if macros == nil {
+		macros = builtinMacros
+	}
+
+	sql, err := qm.expandMacros(qm.raw, macros)
+	if err != nil {
+		return err
+	}
+
+	qm.sql = sql
+	return nil
+}
+
+// expandMacros replaces the macros in the source with the SQL they are evaluated to.
+func (qm *queryModel) expandMacros(src string, macros macroRegistry) (string, error) {
+	parsed, err := parseMacros(src)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse macros: [%v]", err)
+	}
+
 	var sb strings.Builder
 	var currIndex = 0
 
-	for _, match := range matches {
-		sb.WriteString(qm.raw[currIndex:match[0]])
+	for _, m := range parsed {
+		sb.WriteString(src[currIndex:m.offset])
 
-		var subMatches [3]string
-		for j := 0; j < 3; j++ {
-			subMatch := qm.raw[match[j*2]:match[j*2+1]]
-			subMatches[j] = subMatch
-		}
-
-		sql, err := qm.evalMacro(subMatches)
+		sql, err := qm.evalMacro(m, macros)
 		if err != nil {
-			return fmt.Errorf("failed to evaluate macros: [%v]", err)
+			line, column := position(src, m.offset)
+			return "", fmt.Errorf("failed to evaluate macros: [%v]", &macroError{line, column, err})
 		}
 
 		sb.WriteString(sql)
 
-		currIndex = match[1]
+		currIndex = m.offset + len(m.text)
 	}
 
-	sb.WriteString(qm.raw[currIndex:])
-	qm.sql = sb.String()
-
-	return nil
+	sb.WriteString(src[currIndex:])
+	return sb.String(), nil
 }
 
-func (qm *queryModel) evalMacro(matches [3]string) (sql string, err error) {
-	name := matches[1]
-	args := splitArgs(matches[2])
-
-	switch name {
-	case "__time":
-		if len(args) == 0 {
-			return "", fmt.Errorf("failed to evaluate the macro [%s], cause by missing time column argument", name)
-		}
-		sql = toTimestamp(args[0])
-	case "__timeFrom":
-		sql = toRFC3339(qm.from)
-	case "__timeTo":
-		sql = toRFC3339(qm.to)
-	case "__timeFilter":
-		sql = timeFilter(args[0], qm.from, qm.to)
-	case "__timeGroup":
-		if err := qm.setTimeGroup(args); err != nil {
-			return "", fmt.Errorf("failed to evaluate the macro [%s]: [%v]", name, err)
+func (qm *queryModel) evalMacro(m macro, macros macroRegistry) (string, error) {
+	f, found := macros.lookup(m.name)
+	if !found {
+		if grafanaVariables[m.name] {
+			return m.text, nil
 		}
-		sql = timeGroup(args[0], qm.interval)
-	default:
-		return matches[0], nil
-		// return "", fmt.Errorf("failed to generate a SQL: unsupported macro [%s]", name)
+		return "", fmt.Errorf("unknown macro [$%s]", m.name)
+	}
+
+	sql, err := f(qm, m.args)
+	if err != nil {
+		return "", fmt.Errorf("failed to evaluate the macro [%s]: [%v]", m.name, err)
 	}
 
 	return sql, nil
@@ -132,220 +266,309 @@ func (qm *queryModel) setTimeGroup(args []string) error {
 		return fmt.Errorf("failed to set the timeGroup: macro __timeGroup needs time column")
 	}
 
-	it, err := gtime.ParseInterval(strings.Trim(args[1], `'"`))
-	if err != nil {
-		return fmt.Errorf("failed to set the timeGroup, cause by an error from parseInterval(%s)", args[1])
+	loc := qm.location
+	if argsCount >= 4 {
+		var err error
+		loc, err = time.LoadLocation(strings.Trim(args[3], `'"`))
+		if err != nil {
+			return fmt.Errorf("failed to set the timeGroup, cause by an error from loadLocation(%s): %v", args[3], err)
+		}
+	}
+
+	interval := strings.Trim(args[1], `'"`)
+	if interval == "$__interval" {
+		interval = gtime.FormatInterval(max(qm.interval, time.Second))
 	}
 
-	if it.Seconds() <= 0 {
-		return fmt.Errorf("failed to set the timeGroup: interval[%s] must be at least in seconds", args[1])
+	step, err := parseTimeStep(interval, loc, qm.weekStart)
+	if err != nil {
+		return fmt.Errorf("failed to set the timeGroup, cause by %v", err)
 	}
 
-	qm.interval = it
+	// A query may group by the same time in several expressions, such as in SELECT and GROUP BY.
+	if qm.step.unit != "" && !qm.step.equal(step) {
+		return fmt.Errorf("failed to set the timeGroup: every timeGroup must have the same interval and timezone, but got [%s]", args[1])
+	}
+	qm.step = step
 
 	if argsCount >= 3 {
-		// TODO: duplicated timeGroup
-		if qm.shouldFillMissing {
-			return fmt.Errorf("failed to set the timeGroup, cause by duplicated")
+		fill, err := parseFillMissing(args[2])
+		if err != nil {
+			return fmt.Errorf("failed to set the timeGroup, cause by %v", err)
 		}
-		qm.shouldFillMissing = true
 
-		switch strings.ToLower(args[2]) {
-		case "null":
-			qm.fillMissingOption.Mode = data.FillModeNull
-		case "previous":
-			qm.fillMissingOption.Mode = data.FillModePrevious
-		default:
-			v, err := strconv.ParseFloat(args[2], 64)
-			if err != nil {
-				return fmt.Errorf("failed to set the timeGroup, cause by an error from parseFloat[%s]", args[2])
-			}
-			qm.fillMissingOption.Mode = data.FillModeValue
-			qm.fillMissingOption.Value = v
+		if qm.shouldFillMissing && *fill != *qm.fillMissingOption {
+			return fmt.Errorf("failed to set the timeGroup: every timeGroup must have the same fill, but got [%s]", args[2])
 		}
+		qm.shouldFillMissing = true
+		qm.fillMissingOption = fill
 	}
 
 	return nil
 }
 
-func splitArgs(args string) []string {
-	as := strings.Split(args, ",")
+// execute executes the query within the timeout and returns the tables of its result. There is a table
+// for each statement in the multi-statement mode, and one table otherwise.
+func (qm *queryModel) execute(ctx context.Context, db *sql.DB, timeout time.Duration) ([]*table, error) {
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
 
-	for i, a := range as {
-		as[i] = strings.TrimSpace(a)
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return nil, queryError(ctx, err, fmt.Errorf("failed to get a connection: [%v]", err))
 	}
 
-	return as
-}
+	// The statements of a multi-statement query may change the session, so its connection is discarded.
+	keep := qm.multiStatement == ""
+	defer func() {
+		if keep {
+			conn.Close()
+		} else {
+			discardConn(conn)
+		}
+	}()
+
+	// The session has the statement timeout of the datasource, so a query with a shorter timeout sets its own,
+	// and sets the one of the datasource back before the connection returns to the pool.
+	if seconds := timeoutSeconds(timeout); seconds != timeoutSeconds(qm.sessionTimeout) {
+		if err := sf.SetStatementTimeout(ctx, conn, seconds); err != nil {
+			keep = false
+			return nil, queryError(ctx, err, fmt.Errorf("failed to set the statement timeout: %v", err))
+		}
+		defer func() {
+			if keep && !qm.resetStatementTimeout(conn) {
+				keep = false
+			}
+		}()
+	}
 
-func (qm *queryModel) execute(ctx context.Context, db *sql.DB) (*table, error) {
-	rows, err := db.QueryContext(ctx, qm.sql)
-	if err != nil {
-		return nil, fmt.Errorf("failed to query [%s]: [%v]", qm.sql, err)
+	// Only the statement of the query is cancelled on the server, not the statements of the session above.
+	if qm.multiStatement != "" {
+		var tables []*table
+		err := sf.RunWithCancel(ctx, db, func(ctx context.Context) (err error) {
+			tables, err = qm.executeMultiStatement(ctx, conn)
+			return err
+		})
+		return tables, err
 	}
-	defer rows.Close()
 
-	table, err := newTableFromRows(rows)
+	tbl, err := qm.executeStatement(ctx, db, conn)
 	if err != nil {
-		return nil, fmt.Errorf("failed to build a table from rows: %v", err)
+		return nil, err
 	}
 
-	return table, nil
+	return []*table{tbl}, nil
 }
 
-func (qm *queryModel) convertToFrame(table *table) (*data.Frame, error) {
-	frame, err := table.convertToFrame("response")
-	if err != nil {
-		return nil, fmt.Errorf("failed to table to frame: %v", err)
-	}
+// resetStatementTimeout sets the statement timeout of the session back to the one of the datasource.
+// The query may have been cancelled, so it does not run on the context of the query.
+func (qm *queryModel) resetStatementTimeout(conn *sql.Conn) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), resetTimeout)
+	defer cancel()
 
-	if l, _ := frame.RowLen(); l <= 0 {
-		return frame, nil
+	if err := sf.SetStatementTimeout(ctx, conn, timeoutSeconds(qm.sessionTimeout)); err != nil {
+		log.Error("failed to reset the statement timeout:", err)
+		return false
 	}
+	return true
+}
 
-	if qm.isTimeseries {
-		schema := frame.TimeSeriesSchema()
-		if schema.Type == data.TimeSeriesTypeNot {
-			return frame, nil
-		}
+// timeoutSeconds returns the timeout in whole seconds, rounded up so that a timeout is never shortened.
+func timeoutSeconds(timeout time.Duration) int {
+	return int((timeout + time.Second - 1) / time.Second)
+}
 
-		if schema.Type == data.TimeSeriesTypeLong {
-			frame, err = data.LongToWide(frame, qm.fillMissingOption)
-			if err != nil {
-				newerr := fmt.Errorf("failed to convert table to frame, cause by an error from LongToWide: %v", err)
-				if strings.Contains(err.Error(), "sorted ascending by time") {
-					return frame, er.NewError(er.ErrMustBeSortedByTime, newerr)
-				} else {
-					return frame, newerr
-				}
-			}
-		}
+func (qm *queryModel) executeStatement(ctx context.Context, db *sql.DB, conn *sql.Conn) (*table, error) {
+	var table *table
 
-		if qm.shouldFillMissing {
-			frame, err = qm.fillMissingPoints(&schema, frame)
-			if err != nil {
-				return frame, fmt.Errorf("failed to convert table to frame, cause by an error from fillMissingPoints: %v", err)
-			}
+	if qm.arrowFetch && sf.SupportsArrow(qm.sql) {
+		err := sf.RunWithCancel(ctx, db, func(ctx context.Context) (err error) {
+			table, err = qm.executeArrow(ctx, conn)
+			return err
+		})
+
+		var notSupported *sf.ArrowNotSupportedError
+		if !errors.As(err, &notSupported) {
+			return table, err
 		}
+
+		// The query has been executed, so fetch its result again instead of running it.
+		log.Info("fall back to scanning rows:", err)
+		return qm.readRows(gs.WithFetchResultByID(ctx, notSupported.QueryID), conn, "", nil)
 	}
 
-	return frame, err
+	err := sf.RunWithCancel(ctx, db, func(ctx context.Context) (err error) {
+		table, err = qm.readRows(ctx, conn, qm.sql, qm.args)
+		return err
+	})
+	return table, err
 }
 
-func (qm *queryModel) fillMissingPoints(schema *data.TimeSeriesSchema, frame *data.Frame) (*data.Frame, error) {
-	timeIdx := schema.TimeIndex
-	timeField := frame.Fields[timeIdx]
-	switch t := timeField.At(0).(type) {
-	case time.Time:
-		return qm.fillMissingPointsForClass(schema, frame)
-	case *time.Time:
-		return qm.fillMissingPointsForPointer(schema, frame)
-	default:
-		return nil, fmt.Errorf("failed to fill missing points, cause by not supported type[%v]", t)
+// readRows runs the query and reads its rows into a table.
+func (qm *queryModel) readRows(ctx context.Context, conn *sql.Conn, query string, args []any) (*table, error) {
+	rows, err := conn.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, queryError(ctx, err, fmt.Errorf("failed to query [%s]: [%v]", qm.sql, err))
 	}
-}
+	defer rows.Close()
 
-func (qm *queryModel) fillMissingPointsForClass(schema *data.TimeSeriesSchema, frame *data.Frame) (*data.Frame, error) {
-	newFrame := frame.EmptyCopy()
+	table, err := newTableFromRows(rows, qm.maxRows, qm.failOnMaxRows, orUTC(qm.location))
+	if err != nil {
+		if er.GetCode(err) == er.ErrTooManyRows {
+			return nil, err
+		}
+		return nil, queryError(ctx, err, fmt.Errorf("failed to build a table from rows: %v", err))
+	}
 
-	timeIdx := schema.TimeIndex
-	timeField := frame.Fields[timeIdx]
-	rowIdx := 0
-	rowTime := timeField.At(rowIdx).(time.Time)
-	lastRowIdx := timeField.Len() - 1
-	missingVals := buildMissingValues(frame, timeIdx, qm.fillMissingOption)
-	missingMode := qm.fillMissingOption.Mode
-	prevRowIdx := -1
+	return table, nil
+}
 
-	for curr := toTimeGroup(qm.from, qm.interval); !curr.After(qm.to); curr = curr.Add(qm.interval) {
-		comp := curr.Compare(rowTime)
+// executeMultiStatement executes the statements of the query on the connection, and reads a table
+// from each result set. Only the table of the last statement is kept in multiStatementLast.
+func (qm *queryModel) executeMultiStatement(ctx context.Context, conn *sql.Conn) ([]*table, error) {
+	// 0 allows any number of statements.
+	ctx, err := gs.WithMultiStatement(ctx, 0)
+	if err != nil {
+		return nil, fmt.Errorf("failed to set the MULTI_STATEMENT_COUNT: %v", err)
+	}
 
-		if comp == 0 {
-			newFrame.AppendRow(frame.RowCopy(rowIdx)...)
+	rows, err := conn.QueryContext(ctx, qm.sql, qm.args...)
+	if err != nil {
+		return nil, queryError(ctx, err, fmt.Errorf("failed to query [%s]: [%v]", qm.sql, err))
+	}
+	defer rows.Close()
 
-			if rowIdx < lastRowIdx {
-				rowIdx++
-				rowTime = timeField.At(rowIdx).(time.Time)
+	var tables []*table
+	for {
+		tbl, err := newTableFromRows(rows, qm.maxRows, qm.failOnMaxRows, orUTC(qm.location))
+		if err != nil {
+			if er.GetCode(err) == er.ErrTooManyRows {
+				return nil, err
 			}
+			return nil, queryError(ctx, err, fmt.Errorf("failed to build a table from the result of statement %d: %v", len(tables)+1, err))
+		}
+
+		if qm.multiStatement == multiStatementLast {
+			tables = []*table{tbl}
 		} else {
-			rowVals := newRow(missingMode, newFrame, prevRowIdx, missingVals)
-			rowVals[timeIdx] = curr
-			newFrame.AppendRow(rowVals...)
+			tables = append(tables, tbl)
 		}
 
-		prevRowIdx++
+		if !rows.NextResultSet() {
+			break
+		}
 	}
 
-	return newFrame, nil
+	if err := rows.Err(); err != nil {
+		return nil, queryError(ctx, err, fmt.Errorf("failed to read the next result set: %v", err))
+	}
+
+	return tables, nil
 }
 
-func (qm *queryModel) fillMissingPointsForPointer(schema *data.TimeSeriesSchema, frame *data.Frame) (*data.Frame, error) {
-	newFrame := frame.EmptyCopy()
+// discardConn closes the connection instead of returning it to the pool. The statements may have changed
+// the session, such as by SET, USE ROLE or ALTER SESSION, or created temporary tables, which the next
+// queries on the connection must not see.
+func discardConn(conn *sql.Conn) {
+	// database/sql closes a connection which returned driver.ErrBadConn.
+	conn.Raw(func(any) error {
+		return driver.ErrBadConn
+	})
+	conn.Close()
+}
 
-	timeIdx := schema.TimeIndex
-	timeField := frame.Fields[timeIdx]
-	rowIdx := 0
-	rowTime := timeField.At(rowIdx).(*time.Time)
-	lastRowIdx := timeField.Len() - 1
-	missingVals := buildMissingValues(frame, timeIdx, qm.fillMissingOption)
-	missingMode := qm.fillMissingOption.Mode
-	prevRowIdx := -1
+// executeArrow executes the query, reading its result in arrow record batches.
+func (qm *queryModel) executeArrow(ctx context.Context, conn *sql.Conn) (*table, error) {
+	result, err := sf.QueryArrow(ctx, conn, qm.sql, qm.maxRows, qm.args...)
+	if err != nil {
+		var notSupported *sf.ArrowNotSupportedError
+		if errors.As(err, &notSupported) {
+			return nil, err
+		}
+		return nil, queryError(ctx, err, fmt.Errorf("failed to query [%s] in arrow: [%v]", qm.sql, err))
+	}
 
-	for curr := toTimeGroup(qm.from, qm.interval); !curr.After(qm.to); curr = curr.Add(qm.interval) {
-		comp := curr.Compare(*rowTime)
+	if result.Truncated && qm.failOnMaxRows {
+		return nil, er.NewErrorF(er.ErrTooManyRows, "failed to build the dataframe, the result has more than %d rows", qm.maxRows)
+	}
 
-		if comp == 0 {
-			newFrame.AppendRow(frame.RowCopy(rowIdx)...)
+	return newTableFromArrow(result, orUTC(qm.location)), nil
+}
 
-			if rowIdx < lastRowIdx {
-				rowIdx++
-				rowTime = timeField.At(rowIdx).(*time.Time)
-			}
-		} else {
-			rowVals := newRow(missingMode, newFrame, prevRowIdx, missingVals)
-			rowVals[timeIdx] = &curr
-			newFrame.AppendRow(rowVals...)
+// queryError tells a query failed because it timed out or ctx is done from any other failure.
+// cause is the error from the driver, and err is the one to report.
+func queryError(ctx context.Context, cause error, err error) error {
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) || sf.IsStatementTimeout(cause) {
+		return er.NewError(er.ErrQueryTimeout, err)
+	}
+	if ctx.Err() != nil {
+		return er.NewError(er.ErrQueryCancelled, fmt.Errorf("%v: %v", ctx.Err(), err))
+	}
+	return err
+}
+
+func (qm *queryModel) convertToFrame(table *table) (*data.Frame, error) {
+	if qm.flattenJSON {
+		flattened, err := table.flattenJSON()
+		if err != nil {
+			return nil, fmt.Errorf("failed to flatten json: %v", err)
 		}
+		table = flattened
+	}
 
-		prevRowIdx++
+	frame, err := table.convertToFrame("response")
+	if err != nil {
+		return nil, fmt.Errorf("failed to table to frame: %v", err)
 	}
 
-	return newFrame, nil
-}
+	frame, err = qm.trimTimeRange(frame)
+	if err != nil {
+		return nil, fmt.Errorf("failed to trim the rows outside the time range: %v", err)
+	}
 
-func toTimeGroup(t time.Time, it time.Duration) time.Time {
-	sec := int64(it.Seconds())
-	return time.Unix(t.Unix()/sec*sec, 0)
-}
+	qm.notices = append(qm.notices, table.notices()...)
+
+	if table.truncated {
+		qm.notices = append(qm.notices, data.Notice{
+			Severity: data.NoticeSeverityWarning,
+			Text:     fmt.Sprintf("The result exceeded the row limit. Only the first %d rows were read, and the rest were dropped.", table.rowCount),
+		})
+	}
 
-func buildMissingValues(frame *data.Frame, timeIdx int, fillMissing *data.FillMissing) []interface{} {
-	vals := make([]interface{}, len(frame.Fields))
+	if l, _ := frame.RowLen(); l <= 0 {
+		return frame, nil
+	}
 
-	for i, field := range frame.Fields {
-		if i == timeIdx {
-			continue
+	if qm.isTimeseries {
+		schema := frame.TimeSeriesSchema()
+		if schema.Type == data.TimeSeriesTypeNot {
+			return frame, nil
 		}
 
-		if fillMissing.Mode == data.FillModeValue {
-			v, err := data.GetMissing(fillMissing, field, 0)
+		if qm.shouldFillMissing {
+			// A long frame is filled for each label set before it is converted to a wide frame,
+			// so that LongToWide has a row of every series at every time.
+			frame, err = qm.fillMissingPoints(&schema, frame)
 			if err != nil {
-				panic(fmt.Sprintf("an error occured while calling data.GetMissing: [%v]", err))
+				return frame, fmt.Errorf("failed to convert table to frame, cause by an error from fillMissingPoints: %v", err)
 			}
-			vals[i] = v
-		} else {
-			vals[i] = nil
 		}
-	}
-
-	return vals
-}
 
-func newRow(fillMode data.FillMode, frame *data.Frame, prevRowIdx int, missingVals []interface{}) []interface{} {
-	if fillMode == data.FillModePrevious {
-		if prevRowIdx >= 0 {
-			return frame.RowCopy(prevRowIdx)
+		if schema.Type == data.TimeSeriesTypeLong {
+			frame, err = data.LongToWide(frame, qm.fillMissingOption.dataFillMissing())
+			if err != nil {
+				newerr := fmt.Errorf("failed to convert table to frame, cause by an error from LongToWide: %v", err)
+				if strings.Contains(err.Error(), "sorted ascending by time") {
+					return frame, er.NewError(er.ErrMustBeSortedByTime, newerr)
+				} else {
+					return frame, newerr
+				}
+			}
 		}
 	}
 
-	return missingVals
+	return frame, err
 }
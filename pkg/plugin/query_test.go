@@ -0,0 +1,488 @@
+package plugin
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+	"unsafe"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	gs "github.com/snowflakedb/gosnowflake"
+
+	sf "github.com/nexon/sunflake/pkg/snowflake"
+	"github.com/nexon/sunflake/pkg/util/er"
+)
+
+func TestQueryTimeout(t *testing.T) {
+	tests := []struct {
+		datasourceTimeout int
+		timeout           int
+		expected          time.Duration
+	}{
+		{0, 0, 0},
+		{60, 0, 60 * time.Second},
+		{0, 30, 30 * time.Second},
+		{60, 30, 30 * time.Second},
+		{60, 120, 60 * time.Second},
+	}
+
+	for _, tt := range tests {
+		if actual := queryTimeout(tt.datasourceTimeout, tt.timeout); actual != tt.expected {
+			t.Errorf("queryTimeout(%d, %d): expected [%v], but got [%v]", tt.datasourceTimeout, tt.timeout, tt.expected, actual)
+		}
+	}
+}
+
+func TestMaxRows(t *testing.T) {
+	tests := []struct {
+		datasourceMaxRows int
+		maxRows           int
+		expected          int
+	}{
+		{0, 0, 0},
+		{1000, 0, 1000},
+		{0, 10, 10},
+		{1000, 10, 10},
+		{1000, 5000, 1000},
+	}
+
+	for _, tt := range tests {
+		if actual := maxRows(tt.datasourceMaxRows, tt.maxRows); actual != tt.expected {
+			t.Errorf("maxRows(%d, %d): expected [%d], but got [%d]", tt.datasourceMaxRows, tt.maxRows, tt.expected, actual)
+		}
+	}
+}
+
+func TestTimezoneMacros(t *testing.T) {
+	seoul, err := time.LoadLocation("Asia/Seoul")
+	if err != nil {
+		t.Skip("the time zone database is not available:", err)
+	}
+
+	qm := queryModel{
+		raw:      "SELECT $__time(ts), v FROM t WHERE $__timeFilter(ts)",
+		from:     time.Date(2024, 3, 19, 13, 0, 0, 0, time.UTC),
+		to:       time.Date(2024, 3, 19, 14, 0, 0, 0, time.UTC),
+		location: seoul,
+	}
+	if err := qm.evalAllMacros(); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "SELECT CONVERT_TIMEZONE('Asia/Seoul', TO_TIMESTAMP_TZ(ts)) AS time, v FROM t " +
+		"WHERE ts BETWEEN '2024-03-19T22:00:00+09:00' AND '2024-03-19T23:00:00+09:00'"
+	if qm.sql != expected {
+		t.Errorf("expected [%s], but got [%s]", expected, qm.sql)
+	}
+
+	// TIME_SLICE aligns a day to the midnight of the session time zone.
+	group := toTimeGroup(time.Date(2024, 3, 19, 13, 0, 0, 0, time.UTC), 24*time.Hour, seoul)
+	if expected := time.Date(2024, 3, 18, 15, 0, 0, 0, time.UTC); !group.Equal(expected) {
+		t.Errorf("expected the group [%v], but got [%v]", expected, group)
+	}
+}
+
+// Without the Timezone of the datasource, the times are read and written in UTC as they are.
+func TestMacrosWithoutTimezone(t *testing.T) {
+	qm := queryModel{
+		raw:               "SELECT $__time(ts), $__timeGroup(ts, '1h'), v FROM t WHERE $__timeFilter(ts)",
+		from:              time.Date(2024, 3, 19, 13, 0, 0, 0, time.FixedZone("KST", 9*60*60)),
+		to:                time.Date(2024, 3, 19, 14, 0, 0, 0, time.FixedZone("KST", 9*60*60)),
+		fillMissingOption: &fillMissing{mode: fillModeNull},
+	}
+	if err := qm.evalAllMacros(); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "SELECT TO_TIMESTAMP_NTZ(ts) AS time, TIME_SLICE(TO_TIMESTAMP_NTZ(ts), 3600, 'SECOND', 'START'), v FROM t " +
+		"WHERE ts BETWEEN '2024-03-19T04:00:00Z' AND '2024-03-19T05:00:00Z'"
+	if qm.sql != expected {
+		t.Errorf("expected [%s], but got [%s]", expected, qm.sql)
+	}
+
+	start := time.Date(2024, 3, 19, 4, 30, 0, 0, time.UTC)
+	if truncated := qm.step.truncate(start); !truncated.Equal(time.Date(2024, 3, 19, 4, 0, 0, 0, time.UTC)) {
+		t.Errorf("expected the group in UTC, but got [%v]", truncated)
+	}
+}
+
+func TestMultipleTimeGroups(t *testing.T) {
+	qm := queryModel{
+		raw:               "SELECT $__timeGroup(ts, '1h', 0), v FROM t GROUP BY $__timeGroup(ts, '1h')",
+		location:          time.UTC,
+		fillMissingOption: &fillMissing{mode: fillModeNull},
+	}
+	if err := qm.evalAllMacros(); err != nil {
+		t.Fatal(err)
+	}
+	if !qm.shouldFillMissing || qm.fillMissingOption.mode != fillModeValue {
+		t.Error("the fill of the first timeGroup must be kept")
+	}
+
+	for _, raw := range []string{
+		"SELECT $__timeGroup(ts, '1h'), $__timeGroup(ts, '1m')",
+		"SELECT $__timeGroup(ts, '1h', 0), $__timeGroup(ts, '1h', previous)",
+	} {
+		qm := queryModel{raw: raw, location: time.UTC, fillMissingOption: &fillMissing{mode: fillModeNull}}
+		if err := qm.evalAllMacros(); err == nil {
+			t.Errorf("expected an error from [%s]", raw)
+		}
+	}
+}
+
+func TestBuildQueryModelFromBuilder(t *testing.T) {
+	query := &backend.DataQuery{
+		JSON: []byte(`{"queryText": "DROP TABLE ORDERS", "editorMode": "builder", "dataFormat": "timeseries",
+			"snowflakeObject": {"table": "ORDERS"},
+			"timeSeries": {"timeColumn": {"name": "TS"}, "interval": 1, "timeUnit": "h", "fillMissing": "0",
+				"metrics": [{"column": {"name": "PRICE"}, "aggFunc": "SUM"}]}}`),
+		TimeRange: backend.TimeRange{
+			From: time.Date(2024, 3, 19, 13, 0, 0, 0, time.UTC),
+			To:   time.Date(2024, 3, 19, 14, 0, 0, 0, time.UTC),
+		},
+	}
+
+	qm, err := buildQueryModel(query, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `SELECT TIME_SLICE(TO_TIMESTAMP_NTZ("TS"), 3600, 'SECOND', 'START') AS "time", SUM("PRICE") FROM "ORDERS"` +
+		` WHERE "TS" BETWEEN '2024-03-19T13:00:00Z' AND '2024-03-19T14:00:00Z' GROUP BY "time" ORDER BY "time"`
+	if qm.sql != expected {
+		t.Errorf("expected [%s], but got [%s]", expected, qm.sql)
+	}
+
+	if !qm.shouldFillMissing || qm.fillMissingOption.mode != fillModeValue {
+		t.Errorf("expected the fill of the time series, but got %+v", qm.fillMissingOption)
+	}
+}
+
+func TestBuildQueryModelMultiStatement(t *testing.T) {
+	tests := []struct {
+		multiStatement string
+		valid          bool
+	}{
+		{"", true},
+		{"all", true},
+		{"last", true},
+		{"first", false},
+	}
+
+	for _, tt := range tests {
+		query := &backend.DataQuery{
+			JSON: []byte(`{"queryText": "SET a = 1; SELECT $a", "multiStatement": "` + tt.multiStatement + `"}`),
+		}
+
+		qm, err := buildQueryModel(query, nil)
+		if tt.valid && (err != nil || qm.multiStatement != tt.multiStatement) {
+			t.Errorf("expected the multiStatement [%s] to be set, but got [%v]", tt.multiStatement, err)
+		}
+		if !tt.valid && err == nil {
+			t.Errorf("expected an error from the multiStatement [%s]", tt.multiStatement)
+		}
+	}
+}
+
+// fakeResult is a result set of fakeConnector. Its columns are TEXT, and its values are strings.
+type fakeResult struct {
+	columns []string
+	rows    [][]driver.Value
+}
+
+// fakeConnector is a driver.Connector which returns its results for any query, and counts
+// the connections it opened and closed. It records the queries and their arguments.
+//
+// Like gosnowflake, it sends the ID of a query on the query ID channel of its context, and closes the channel.
+// identified records the queries which had the channel. A query with a channel which is already closed fails,
+// where gosnowflake would panic.
+type fakeConnector struct {
+	results []fakeResult
+	opened  atomic.Int32
+	closed  atomic.Int32
+
+	mu         sync.Mutex
+	queries    []string
+	args       [][]any
+	identified []string
+	closedIDs  map[chan<- string]bool
+}
+
+// queryIDChanKey is the key of the query ID channel in the contexts of gosnowflake, which is unexported.
+var queryIDChanKey = func() any {
+	key := reflect.ValueOf(gs.WithQueryIDChan(context.Background(), nil)).Elem().FieldByName("key")
+	return reflect.NewAt(key.Type(), unsafe.Pointer(key.UnsafeAddr())).Elem().Interface()
+}()
+
+func (c *fakeConnector) record(ctx context.Context, query string, args []driver.NamedValue) error {
+	values := make([]any, len(args))
+	for i, arg := range args {
+		values[i] = arg.Value
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.queries = append(c.queries, query)
+	c.args = append(c.args, values)
+
+	queryIDs, _ := ctx.Value(queryIDChanKey).(chan<- string)
+	if queryIDs == nil {
+		return nil
+	}
+	if c.closedIDs[queryIDs] {
+		return fmt.Errorf("send on the closed query ID channel by [%s]", query)
+	}
+
+	c.identified = append(c.identified, query)
+	queryIDs <- strconv.Itoa(len(c.queries))
+	close(queryIDs)
+
+	if c.closedIDs == nil {
+		c.closedIDs = make(map[chan<- string]bool)
+	}
+	c.closedIDs[queryIDs] = true
+	return nil
+}
+
+func (c *fakeConnector) Connect(context.Context) (driver.Conn, error) {
+	c.opened.Add(1)
+	return &fakeConn{c}, nil
+}
+
+func (c *fakeConnector) Driver() driver.Driver { return nil }
+
+type fakeConn struct {
+	c *fakeConnector
+}
+
+func (c *fakeConn) Prepare(string) (driver.Stmt, error) { return nil, errors.New("not supported") }
+func (c *fakeConn) Begin() (driver.Tx, error)           { return nil, errors.New("not supported") }
+
+func (c *fakeConn) Close() error {
+	c.c.closed.Add(1)
+	return nil
+}
+
+func (c *fakeConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	if err := c.c.record(ctx, query, args); err != nil {
+		return nil, err
+	}
+	return &fakeRows{results: c.c.results}, nil
+}
+
+func (c *fakeConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	if err := c.c.record(ctx, query, args); err != nil {
+		return nil, err
+	}
+	return driver.RowsAffected(0), nil
+}
+
+type fakeRows struct {
+	results []fakeResult
+	row     int
+}
+
+func (r *fakeRows) Columns() []string { return r.results[0].columns }
+func (r *fakeRows) Close() error      { return nil }
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if r.row >= len(r.results[0].rows) {
+		return io.EOF
+	}
+	copy(dest, r.results[0].rows[r.row])
+	r.row++
+	return nil
+}
+
+func (r *fakeRows) HasNextResultSet() bool { return len(r.results) > 1 }
+
+func (r *fakeRows) NextResultSet() error {
+	if len(r.results) <= 1 {
+		return io.EOF
+	}
+	r.results, r.row = r.results[1:], 0
+	return nil
+}
+
+func (r *fakeRows) ColumnTypeDatabaseTypeName(int) string { return "TEXT" }
+func (r *fakeRows) ColumnTypeScanType(int) reflect.Type   { return reflect.TypeOf("") }
+
+func TestExecuteMultiStatement(t *testing.T) {
+	results := []fakeResult{
+		{columns: []string{"status"}, rows: [][]driver.Value{{"Statement executed successfully."}}},
+		{columns: []string{"a", "b"}, rows: [][]driver.Value{{"1", "x"}, {"2", nil}}},
+	}
+
+	tests := []struct {
+		multiStatement string
+		expected       [][]string
+	}{
+		{multiStatementAll, [][]string{{"status"}, {"a", "b"}}},
+		{multiStatementLast, [][]string{{"a", "b"}}},
+	}
+
+	for _, tt := range tests {
+		connector := &fakeConnector{results: results}
+		db := sql.OpenDB(connector)
+
+		qm := queryModel{sql: "USE ROLE r; SELECT a, b FROM t", multiStatement: tt.multiStatement, location: time.UTC}
+		tables, err := qm.execute(context.Background(), db, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(tables) != len(tt.expected) {
+			t.Fatalf("expected %d tables of [%s], but got %d", len(tt.expected), tt.multiStatement, len(tables))
+		}
+		for i, tbl := range tables {
+			var names []string
+			for _, c := range tbl.cols {
+				names = append(names, c.name)
+			}
+			if !reflect.DeepEqual(names, tt.expected[i]) {
+				t.Errorf("expected the columns %v of [%s], but got %v", tt.expected[i], tt.multiStatement, names)
+			}
+		}
+
+		last := tables[len(tables)-1]
+		if values := last.cols[1].values.([]*string); last.rowCount != 2 || *values[0] != "x" || values[1] != nil {
+			t.Errorf("unexpected values of the last table of [%s]: %+v", tt.multiStatement, last.cols)
+		}
+
+		// The session of the statements is not returned to the pool.
+		if connector.closed.Load() != 1 || db.Stats().Idle != 0 {
+			t.Errorf("expected the connection to be closed, but got %d closed and %d idle", connector.closed.Load(), db.Stats().Idle)
+		}
+		db.Close()
+	}
+}
+
+func TestExecuteStatementTimeout(t *testing.T) {
+	const query = "SELECT a FROM t"
+
+	tests := []struct {
+		sessionTimeout time.Duration
+		timeout        time.Duration
+		expected       []string
+	}{
+		{0, 0, []string{query}},
+		{60 * time.Second, 60 * time.Second, []string{query}},
+		{60 * time.Second, 30 * time.Second, []string{
+			"ALTER SESSION SET STATEMENT_TIMEOUT_IN_SECONDS = 30",
+			query,
+			"ALTER SESSION SET STATEMENT_TIMEOUT_IN_SECONDS = 60",
+		}},
+		{0, 1500 * time.Millisecond, []string{
+			"ALTER SESSION SET STATEMENT_TIMEOUT_IN_SECONDS = 2",
+			query,
+			"ALTER SESSION UNSET STATEMENT_TIMEOUT_IN_SECONDS",
+		}},
+	}
+
+	for _, tt := range tests {
+		connector := &fakeConnector{results: []fakeResult{{columns: []string{"a"}, rows: [][]driver.Value{{"1"}}}}}
+		db := sql.OpenDB(connector)
+
+		qm := queryModel{sql: query, sessionTimeout: tt.sessionTimeout, location: time.UTC}
+		if _, err := qm.execute(context.Background(), db, tt.timeout); err != nil {
+			t.Fatal(err)
+		}
+
+		if !reflect.DeepEqual(connector.queries, tt.expected) {
+			t.Errorf("session timeout %v, timeout %v: expected the queries %q, but got %q", tt.sessionTimeout, tt.timeout, tt.expected, connector.queries)
+		}
+
+		// Only the query is cancelled when the request is, not the statements setting the timeout.
+		if !reflect.DeepEqual(connector.identified, []string{query}) {
+			t.Errorf("session timeout %v, timeout %v: expected the query ID of [%s], but got the ones of %q", tt.sessionTimeout, tt.timeout, query, connector.identified)
+		}
+
+		// The session has the timeout of the datasource again, so it is returned to the pool.
+		if connector.closed.Load() != 0 || db.Stats().Idle != 1 {
+			t.Errorf("expected the connection to be kept, but got %d closed and %d idle", connector.closed.Load(), db.Stats().Idle)
+		}
+		db.Close()
+	}
+}
+
+func TestQueryErrorMessage(t *testing.T) {
+	timedOut, cancel := context.WithTimeout(context.Background(), 0)
+	defer cancel()
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	tests := []struct {
+		ctx  context.Context
+		code int
+	}{
+		{timedOut, er.ErrQueryTimeout},
+		{cancelled, er.ErrQueryCancelled},
+		{context.Background(), 0},
+	}
+
+	cause := errors.New("000604: SQL execution canceled")
+	for _, tt := range tests {
+		err := queryError(tt.ctx, cause, fmt.Errorf("failed to query [SELECT 1]: [%v]", cause))
+		if er.GetCode(err) != tt.code {
+			t.Errorf("expected the error code [%d], but got [%d]", tt.code, er.GetCode(err))
+		}
+
+		message := er.GetDetailedMessageF(err, "query execution: %v", err.Error())
+		if !strings.Contains(message, cause.Error()) {
+			t.Errorf("expected the message to have the error [%v], but got [%s]", cause, message)
+		}
+	}
+}
+
+func TestRunWithCancel(t *testing.T) {
+	connector := &fakeConnector{results: []fakeResult{{columns: []string{"a"}, rows: [][]driver.Value{{"1"}}}}}
+	db := sql.OpenDB(connector)
+	defer db.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	cancelled := func() []any {
+		connector.mu.Lock()
+		defer connector.mu.Unlock()
+		for i, query := range connector.queries {
+			if query == "SELECT SYSTEM$CANCEL_QUERY(?)" {
+				return connector.args[i]
+			}
+		}
+		return nil
+	}
+
+	err := sf.RunWithCancel(ctx, db, func(ctx context.Context) error {
+		rows, err := db.QueryContext(ctx, "SELECT a FROM t")
+		if err != nil {
+			return err
+		}
+		rows.Close()
+
+		// The request is cancelled while the rows are read.
+		cancel()
+		for deadline := time.Now().Add(5 * time.Second); cancelled() == nil && time.Now().Before(deadline); {
+			time.Sleep(10 * time.Millisecond)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if args := cancelled(); !reflect.DeepEqual(args, []any{"1"}) {
+		t.Errorf("expected the query [1] to be cancelled, but got %v", args)
+	}
+}
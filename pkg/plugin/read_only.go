@@ -0,0 +1,221 @@
+package plugin
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/nexon/sunflake/pkg/util/er"
+)
+
+type readOnlyConfig struct {
+	// Enabled rejects a query which is not a single read-only statement before it is executed.
+	// A query in the multi-statement mode may have several statements, each of which must be allowed.
+	// The guard is opt-in, and it does not replace the privileges of the role.
+	Enabled bool
+	// AllowedStatements are the keywords which a statement may start with, such as "SELECT".
+	AllowedStatements []string
+}
+
+var defaultAllowedStatements = []string{"SELECT", "WITH", "SHOW", "DESCRIBE"}
+
+var matchKeyword = regexp.MustCompile(`^[A-Za-z]+$`)
+
+// readOnlyGuard checks the statements of a query against the allowed keywords.
+// A nil *readOnlyGuard allows every query.
+type readOnlyGuard struct {
+	allowed map[string]bool
+	// keywords are the allowed keywords in the order of the config, for the errors.
+	keywords []string
+}
+
+func newReadOnlyGuard(cfg *readOnlyConfig) (*readOnlyGuard, error) {
+	if cfg == nil || !cfg.Enabled {
+		return nil, nil
+	}
+
+	keywords := cfg.AllowedStatements
+	if len(keywords) == 0 {
+		keywords = defaultAllowedStatements
+	}
+
+	g := &readOnlyGuard{allowed: make(map[string]bool, len(keywords))}
+	for _, keyword := range keywords {
+		if !matchKeyword.MatchString(keyword) {
+			return nil, fmt.Errorf("the allowed statement [%s] must be a keyword, such as SELECT", keyword)
+		}
+		keyword = normalizeKeyword(keyword)
+		if !g.allowed[keyword] {
+			g.allowed[keyword] = true
+			g.keywords = append(g.keywords, keyword)
+		}
+	}
+
+	return g, nil
+}
+
+// check returns an error with er.ErrNotReadOnly if the SQL is not a single statement starting with
+// an allowed keyword. The keyword of a WITH statement is checked for its main statement as well,
+// so that a common table expression cannot hide an INSERT. If multiStatement is true, the SQL may
+// have several statements, and each of them is checked. The error has er.ErrUnlexableQuery if the
+// SQL cannot be split into statements, such as with a literal which is not closed.
+func (g *readOnlyGuard) check(sql string, multiStatement bool) error {
+	if g == nil {
+		return nil
+	}
+
+	stmts, err := lexStatements(sql)
+	if err != nil {
+		return er.NewError(er.ErrUnlexableQuery, fmt.Errorf("failed to lex the query: %v", err))
+	}
+
+	if len(stmts) == 0 || (!multiStatement && len(stmts) > 1) {
+		return er.NewErrorF(er.ErrNotReadOnly, "the query must be a single statement, but it has %d statements", len(stmts))
+	}
+
+	for _, stmt := range stmts {
+		if err := g.checkStatement(stmt); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (g *readOnlyGuard) checkStatement(tokens []sqlToken) error {
+	i := skipParens(tokens, 0)
+	if i >= len(tokens) {
+		return er.NewErrorF(er.ErrNotReadOnly, "the statement has no keyword")
+	}
+
+	keyword := normalizeKeyword(tokens[i].text)
+	if !g.allowed[keyword] {
+		return er.NewErrorF(er.ErrNotReadOnly, "the statement [%s] is not allowed, the allowed statements are %s", keyword, g.allowedList())
+	}
+
+	if keyword != "WITH" {
+		return nil
+	}
+
+	main := mainKeyword(tokens, i)
+	if main == "" {
+		return er.NewErrorF(er.ErrNotReadOnly, "the statement [WITH] has no main statement")
+	}
+	if !g.allowed[main] {
+		return er.NewErrorF(er.ErrNotReadOnly, "the statement [%s] after WITH is not allowed, the allowed statements are %s", main, g.allowedList())
+	}
+
+	return nil
+}
+
+func (g *readOnlyGuard) allowedList() string {
+	return strings.Join(g.keywords, ", ")
+}
+
+// sqlToken is a word, such as a keyword or a name in upper case, or a punctuation character.
+// A string literal is "'", and a quoted identifier is `"`. depth is the number of the parentheses
+// around the token.
+type sqlToken struct {
+	text  string
+	depth int
+}
+
+// lexStatements splits the SQL into the tokens of its statements. Statements are separated by
+// semicolons outside of literals and comments, and a statement without any token is dropped.
+func lexStatements(src string) ([][]sqlToken, error) {
+	var stmts [][]sqlToken
+	var curr []sqlToken
+	depth := 0
+
+	for i := 0; i < len(src); {
+		end, err := skipLiteral(src, i)
+		if err != nil {
+			return nil, err
+		}
+		if end > i {
+			switch src[i] {
+			case '\'', '$':
+				curr = append(curr, sqlToken{"'", depth})
+			case '"':
+				curr = append(curr, sqlToken{`"`, depth})
+			}
+			i = end
+			continue
+		}
+
+		c := src[i]
+		switch {
+		case c == ';':
+			if len(curr) > 0 {
+				stmts = append(stmts, curr)
+			}
+			curr = nil
+			depth = 0
+		case c == '(':
+			curr = append(curr, sqlToken{"(", depth})
+			depth++
+		case c == ')':
+			depth--
+			curr = append(curr, sqlToken{")", depth})
+		case isIdentifierByte(c):
+			start := i
+			for i < len(src) && isIdentifierByte(src[i]) {
+				i++
+			}
+			curr = append(curr, sqlToken{strings.ToUpper(src[start:i]), depth})
+			continue
+		case c == ' ', c == '\t', c == '\n', c == '\r', c == '\f', c == '\v':
+		default:
+			curr = append(curr, sqlToken{string(c), depth})
+		}
+		i++
+	}
+
+	if len(curr) > 0 {
+		stmts = append(stmts, curr)
+	}
+
+	return stmts, nil
+}
+
+// mainKeyword returns the keyword of the main statement of the WITH statement at i, which follows
+// the parentheses of the last common table expression, or "" if there is none.
+func mainKeyword(tokens []sqlToken, i int) string {
+	depth := tokens[i].depth
+
+	for j := i + 1; j < len(tokens)-1; j++ {
+		if tokens[j].depth != depth || tokens[j].text != ")" {
+			continue
+		}
+
+		// The parentheses may be the columns of a common table expression, followed by AS,
+		// or the query of one, followed by a comma and the next one.
+		next := tokens[j+1]
+		if next.depth != depth || next.text == "," || next.text == "AS" {
+			continue
+		}
+
+		k := skipParens(tokens, j+1)
+		if k < len(tokens) {
+			return normalizeKeyword(tokens[k].text)
+		}
+	}
+
+	return ""
+}
+
+func skipParens(tokens []sqlToken, i int) int {
+	for i < len(tokens) && tokens[i].text == "(" {
+		i++
+	}
+	return i
+}
+
+// normalizeKeyword returns the keyword in upper case. DESC is the short form of DESCRIBE.
+func normalizeKeyword(keyword string) string {
+	keyword = strings.ToUpper(keyword)
+	if keyword == "DESC" {
+		return "DESCRIBE"
+	}
+	return keyword
+}
@@ -0,0 +1,116 @@
+package plugin
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/nexon/sunflake/pkg/util/er"
+)
+
+func TestReadOnlyGuard(t *testing.T) {
+	guard, err := newReadOnlyGuard(&readOnlyConfig{Enabled: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		sql     string
+		allowed bool
+	}{
+		{"SELECT * FROM t", true},
+		{"  -- comment\n select 1;", true},
+		{"(SELECT 1) UNION (SELECT 2)", true},
+		{"WITH a AS (SELECT 1), b (x) AS (SELECT 2) SELECT * FROM a, b", true},
+		{"SHOW TABLES", true},
+		{"desc table t", true},
+		{"SELECT 'a; DROP TABLE t', \"b;c\" FROM t /* ; */", true},
+		{"SELECT $$;$$", true},
+		{"DROP TABLE t", false},
+		{"INSERT INTO t SELECT 1", false},
+		{"SELECT 1; DROP TABLE t", false},
+		{"SELECT 1;;SELECT 2", false},
+		{"WITH a AS (SELECT 1) DELETE FROM t", false},
+		{"WITH a AS (SELECT 1)", false},
+		{"WITH p AS PROCEDURE() RETURNS INT LANGUAGE SQL AS $$ BEGIN RETURN 1; END $$ CALL p()", false},
+		{"CALL p()", false},
+		{"/* SELECT */ UPDATE t SET a = 1", false},
+		{"-- only a comment", false},
+	}
+
+	for _, tt := range tests {
+		err := guard.check(tt.sql, false)
+		if tt.allowed && err != nil {
+			t.Errorf("expected [%s] to be allowed, but got [%v]", tt.sql, err)
+		}
+		if !tt.allowed && er.GetCode(err) != er.ErrNotReadOnly {
+			t.Errorf("expected [%s] to be rejected, but got [%v]", tt.sql, err)
+		}
+	}
+}
+
+func TestReadOnlyGuardAllowedStatements(t *testing.T) {
+	guard, err := newReadOnlyGuard(&readOnlyConfig{Enabled: true, AllowedStatements: []string{"select", "explain"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := guard.check("EXPLAIN SELECT 1", false); err != nil {
+		t.Errorf("expected EXPLAIN to be allowed, but got [%v]", err)
+	}
+
+	for _, sql := range []string{"SHOW TABLES", "WITH a AS (SELECT 1) SELECT 1"} {
+		if err := guard.check(sql, false); err == nil {
+			t.Errorf("expected [%s] to be rejected", sql)
+		}
+	}
+
+	// The error tells the statements which are allowed by the config.
+	err = guard.check("SHOW TABLES", false)
+	if expected := "the statement [SHOW] is not allowed, the allowed statements are SELECT, EXPLAIN"; err == nil || err.Error() != expected {
+		t.Errorf("expected [%s], but got [%v]", expected, err)
+	}
+
+	if _, err := newReadOnlyGuard(&readOnlyConfig{Enabled: true, AllowedStatements: []string{"SELECT 1"}}); err == nil {
+		t.Error("expected an error from an allowed statement which is not a keyword")
+	}
+
+	var disabled *readOnlyGuard
+	if err := disabled.check("DROP TABLE t", false); err != nil {
+		t.Errorf("expected a disabled guard to allow every query, but got [%v]", err)
+	}
+}
+
+func TestReadOnlyGuardMultiStatement(t *testing.T) {
+	guard, err := newReadOnlyGuard(&readOnlyConfig{Enabled: true, AllowedStatements: []string{"SET", "SELECT"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := guard.check("SET region = 'kr'; SELECT * FROM t WHERE region = $region;", true); err != nil {
+		t.Errorf("expected the statements to be allowed, but got [%v]", err)
+	}
+
+	if err := guard.check("SET region = 'kr'; DELETE FROM t", true); er.GetCode(err) != er.ErrNotReadOnly {
+		t.Errorf("expected the DELETE to be rejected, but got [%v]", err)
+	}
+}
+
+func TestReadOnlyGuardUnlexable(t *testing.T) {
+	guard, err := newReadOnlyGuard(&readOnlyConfig{Enabled: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, sql := range []string{"SELECT 'not closed", `SELECT "a`, "SELECT 1 /* comment"} {
+		err := guard.check(sql, false)
+		if er.GetCode(err) != er.ErrUnlexableQuery {
+			t.Errorf("expected ErrUnlexableQuery from [%s], but got [%v]", sql, err)
+			continue
+		}
+
+		// The response keeps where the query could not be read.
+		if message := er.GetDetailedMessageF(err, "read-only: %v", err); !strings.Contains(message, "Reason: failed to lex the query: line 1") {
+			t.Errorf("expected the reason in the message, but got [%s]", message)
+		}
+	}
+}
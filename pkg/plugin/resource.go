@@ -0,0 +1,261 @@
+package plugin
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/resource/httpadapter"
+
+	sf "github.com/nexon/sunflake/pkg/snowflake"
+	"github.com/nexon/sunflake/pkg/util/er"
+	"github.com/nexon/sunflake/pkg/util/log"
+)
+
+const defaultResourceLimit = 1000
+
+// resourceResponse is the body of every metadata resource.
+// Total is the number of items matched before the offset and the limit are applied.
+type resourceResponse struct {
+	Items  any `json:"items"`
+	Total  int `json:"total"`
+	Offset int `json:"offset"`
+	Limit  int `json:"limit"`
+}
+
+type resourceError struct {
+	Error string `json:"error"`
+}
+
+func newResourceHandler(d *Datasource) backend.CallResourceHandler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/databases", d.handleDatabases)
+	mux.HandleFunc("/schemas", d.handleSchemas)
+	mux.HandleFunc("/tables", d.handleTables)
+	mux.HandleFunc("/columns", d.handleColumns)
+	mux.HandleFunc("/cache/invalidate", d.handleInvalidateCache)
+
+	return httpadapter.New(mux)
+}
+
+func (d *Datasource) handleDatabases(rw http.ResponseWriter, req *http.Request) {
+	q := req.URL.Query()
+
+	conn, ok := d.resourceConnection(rw, req)
+	if !ok {
+		return
+	}
+	defer conn.release()
+
+	key := d.metadataKey(conn, "databases", q.Get("search"))
+	names, err := cachedMetadata(d.metaCache, key, isRefresh(req), func() ([]string, error) {
+		return sf.ShowDatabases(req.Context(), conn.db, key.search)
+	})
+	if err != nil {
+		writeResourceError(rw, http.StatusInternalServerError, fmt.Errorf("failed to get databases: %v", err))
+		return
+	}
+
+	writePage(rw, req, names)
+}
+
+func (d *Datasource) handleSchemas(rw http.ResponseWriter, req *http.Request) {
+	q := req.URL.Query()
+
+	database, err := requireParams(q.Get, "database")
+	if err != nil {
+		writeResourceError(rw, http.StatusBadRequest, err)
+		return
+	}
+
+	conn, ok := d.resourceConnection(rw, req)
+	if !ok {
+		return
+	}
+	defer conn.release()
+
+	key := d.metadataKey(conn, "schemas", q.Get("search"), database...)
+	names, err := cachedMetadata(d.metaCache, key, isRefresh(req), func() ([]string, error) {
+		return sf.ShowSchemas(req.Context(), conn.db, key.database, key.search)
+	})
+	if err != nil {
+		writeResourceError(rw, http.StatusInternalServerError, fmt.Errorf("failed to get schemas: %v", err))
+		return
+	}
+
+	writePage(rw, req, names)
+}
+
+func (d *Datasource) handleTables(rw http.ResponseWriter, req *http.Request) {
+	q := req.URL.Query()
+
+	params, err := requireParams(q.Get, "database", "schema")
+	if err != nil {
+		writeResourceError(rw, http.StatusBadRequest, err)
+		return
+	}
+
+	conn, ok := d.resourceConnection(rw, req)
+	if !ok {
+		return
+	}
+	defer conn.release()
+
+	key := d.metadataKey(conn, "tables", q.Get("search"), params...)
+	names, err := cachedMetadata(d.metaCache, key, isRefresh(req), func() ([]string, error) {
+		return sf.ListTables(req.Context(), conn.db, key.database, key.schema, key.search)
+	})
+	if err != nil {
+		writeResourceError(rw, http.StatusInternalServerError, fmt.Errorf("failed to get tables: %v", err))
+		return
+	}
+
+	writePage(rw, req, names)
+}
+
+func (d *Datasource) handleColumns(rw http.ResponseWriter, req *http.Request) {
+	q := req.URL.Query()
+
+	params, err := requireParams(q.Get, "database", "schema", "table")
+	if err != nil {
+		writeResourceError(rw, http.StatusBadRequest, err)
+		return
+	}
+
+	conn, ok := d.resourceConnection(rw, req)
+	if !ok {
+		return
+	}
+	defer conn.release()
+
+	key := d.metadataKey(conn, "columns", q.Get("search"), params...)
+	columns, err := cachedMetadata(d.metaCache, key, isRefresh(req), func() ([]sf.Column, error) {
+		return sf.ListColumns(req.Context(), conn.db, key.database, key.schema, key.table, key.search)
+	})
+	if err != nil {
+		writeResourceError(rw, http.StatusInternalServerError, fmt.Errorf("failed to get columns: %v", err))
+		return
+	}
+
+	writePage(rw, req, columns)
+}
+
+// handleInvalidateCache removes the cached listings of the database given by the "database" parameter,
+// or all cached listings if it is omitted.
+func (d *Datasource) handleInvalidateCache(rw http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		writeResourceError(rw, http.StatusMethodNotAllowed, fmt.Errorf("method [%s] is not allowed", req.Method))
+		return
+	}
+
+	d.metaCache.invalidate(req.URL.Query().Get("database"))
+	rw.WriteHeader(http.StatusNoContent)
+}
+
+// resourceConnection returns the connection of the request, or writes the error if there is none.
+// The connection must be released when the request is done with it.
+func (d *Datasource) resourceConnection(rw http.ResponseWriter, req *http.Request) (connection, bool) {
+	conn, err := d.connection(req.Header.Get(backend.OAuthIdentityTokenHeaderName))
+	if err != nil {
+		status := http.StatusBadRequest
+		if er.GetCode(err) == er.ErrNoUserToken {
+			status = http.StatusUnauthorized
+		}
+		writeResourceError(rw, status, err)
+		return connection{}, false
+	}
+	return conn, true
+}
+
+// metadataKey builds the cache key of a listing. path is the database, schema and table the listing is in.
+func (d *Datasource) metadataKey(conn connection, kind string, search string, path ...string) metadataKey {
+	key := metadataKey{user: conn.user, kind: kind, search: search}
+	if d.dm != nil {
+		key.role = d.dm.Role
+	}
+
+	for i, p := range path {
+		switch i {
+		case 0:
+			key.database = p
+		case 1:
+			key.schema = p
+		case 2:
+			key.table = p
+		}
+	}
+
+	return key
+}
+
+func isRefresh(req *http.Request) bool {
+	refresh, _ := strconv.ParseBool(req.URL.Query().Get("refresh"))
+	return refresh
+}
+
+func requireParams(get func(string) string, names ...string) ([]string, error) {
+	values := make([]string, len(names))
+
+	for i, name := range names {
+		values[i] = get(name)
+		if values[i] == "" {
+			return nil, fmt.Errorf("missing the required parameter [%s]", name)
+		}
+	}
+
+	return values, nil
+}
+
+func parsePage(req *http.Request) (offset int, limit int, err error) {
+	q := req.URL.Query()
+	limit = defaultResourceLimit
+
+	if s := q.Get("offset"); s != "" {
+		if offset, err = strconv.Atoi(s); err != nil || offset < 0 {
+			return 0, 0, fmt.Errorf("invalid offset [%s]", s)
+		}
+	}
+
+	if s := q.Get("limit"); s != "" {
+		if limit, err = strconv.Atoi(s); err != nil || limit <= 0 {
+			return 0, 0, fmt.Errorf("invalid limit [%s]", s)
+		}
+	}
+
+	return offset, limit, nil
+}
+
+func writePage[T any](rw http.ResponseWriter, req *http.Request, items []T) {
+	offset, limit, err := parsePage(req)
+	if err != nil {
+		writeResourceError(rw, http.StatusBadRequest, err)
+		return
+	}
+
+	total := len(items)
+	start := min(offset, total)
+	end := min(start+limit, total)
+
+	writeResourceJSON(rw, http.StatusOK, resourceResponse{
+		Items:  items[start:end],
+		Total:  total,
+		Offset: offset,
+		Limit:  limit,
+	})
+}
+
+func writeResourceError(rw http.ResponseWriter, status int, err error) {
+	log.ErrorM("failed to handle the resource:", err)
+	writeResourceJSON(rw, status, resourceError{err.Error()})
+}
+
+func writeResourceJSON(rw http.ResponseWriter, status int, body any) {
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(status)
+
+	if err := json.NewEncoder(rw).Encode(body); err != nil {
+		log.Error("failed to write the resource response:", err)
+	}
+}
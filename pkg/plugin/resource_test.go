@@ -0,0 +1,102 @@
+package plugin
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestResourcePages(t *testing.T) {
+	connector := &fakeConnector{results: []fakeResult{{
+		columns: []string{"table_name"},
+		rows:    [][]driver.Value{{"A"}, {"B"}, {"C"}, {"D"}, {"E"}},
+	}}}
+	d := &Datasource{db: sql.OpenDB(connector)}
+	defer d.db.Close()
+
+	tests := []struct {
+		target   string
+		expected resourceResponse
+	}{
+		{"/tables?database=db&schema=s", resourceResponse{Items: []any{"A", "B", "C", "D", "E"}, Total: 5, Offset: 0, Limit: defaultResourceLimit}},
+		{"/tables?database=db&schema=s&limit=2", resourceResponse{Items: []any{"A", "B"}, Total: 5, Offset: 0, Limit: 2}},
+		{"/tables?database=db&schema=s&offset=2&limit=2", resourceResponse{Items: []any{"C", "D"}, Total: 5, Offset: 2, Limit: 2}},
+		{"/tables?database=db&schema=s&offset=4&limit=2", resourceResponse{Items: []any{"E"}, Total: 5, Offset: 4, Limit: 2}},
+		{"/tables?database=db&schema=s&offset=10", resourceResponse{Items: []any{}, Total: 5, Offset: 10, Limit: defaultResourceLimit}},
+	}
+
+	for _, tt := range tests {
+		rw := httptest.NewRecorder()
+		d.handleTables(rw, httptest.NewRequest(http.MethodGet, tt.target, nil))
+
+		if rw.Code != http.StatusOK {
+			t.Errorf("%s: expected the status [%d], but got [%d]: %s", tt.target, http.StatusOK, rw.Code, rw.Body)
+			continue
+		}
+
+		var actual resourceResponse
+		if err := json.Unmarshal(rw.Body.Bytes(), &actual); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(actual, tt.expected) {
+			t.Errorf("%s: expected [%+v], but got [%+v]", tt.target, tt.expected, actual)
+		}
+	}
+}
+
+func TestResourceBadRequests(t *testing.T) {
+	connector := &fakeConnector{results: []fakeResult{{
+		columns: []string{"name"},
+		rows:    [][]driver.Value{{"DB"}},
+	}}}
+	d := &Datasource{db: sql.OpenDB(connector)}
+	defer d.db.Close()
+
+	tests := []struct {
+		handler func(http.ResponseWriter, *http.Request)
+		target  string
+	}{
+		{d.handleSchemas, "/schemas"},
+		{d.handleTables, "/tables?database=db"},
+		{d.handleColumns, "/columns?database=db&schema=s"},
+		{d.handleDatabases, "/databases?offset=-1"},
+		{d.handleDatabases, "/databases?limit=0"},
+		{d.handleDatabases, "/databases?limit=x"},
+	}
+
+	for _, tt := range tests {
+		rw := httptest.NewRecorder()
+		tt.handler(rw, httptest.NewRequest(http.MethodGet, tt.target, nil))
+
+		if rw.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected the status [%d], but got [%d]", tt.target, http.StatusBadRequest, rw.Code)
+		}
+	}
+}
+
+func TestResourceSearch(t *testing.T) {
+	connector := &fakeConnector{results: []fakeResult{{
+		columns: []string{"column_name", "data_type"},
+		rows:    [][]driver.Value{{"MY_COLUMN", "NUMBER"}},
+	}}}
+	d := &Datasource{db: sql.OpenDB(connector)}
+	defer d.db.Close()
+
+	rw := httptest.NewRecorder()
+	d.handleColumns(rw, httptest.NewRequest(http.MethodGet, "/columns?database=db&schema=s&table=t&search=my_%25", nil))
+	if rw.Code != http.StatusOK {
+		t.Fatalf("expected the status [%d], but got [%d]: %s", http.StatusOK, rw.Code, rw.Body)
+	}
+
+	if len(connector.args) != 1 {
+		t.Fatalf("expected a query, but got [%d]", len(connector.args))
+	}
+	expected := []any{"s", "t", `%my\_\%%`}
+	if !reflect.DeepEqual(connector.args[0], expected) {
+		t.Errorf("expected the arguments [%v], but got [%v]", expected, connector.args[0])
+	}
+}
@@ -0,0 +1,299 @@
+package plugin
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/nexon/sunflake/pkg/util/er"
+)
+
+type resultCacheConfig struct {
+	// Enabled turns on the result cache. The cache is opt-in.
+	Enabled bool
+	// TTL is how long a result is served from the cache, in seconds.
+	TTL int
+	// MaxMemoryMB bounds the estimated size of the cached results.
+	// The least recently used result is evicted first.
+	MaxMemoryMB int
+}
+
+var defaultResultCacheConfig = resultCacheConfig{
+	Enabled:     false,
+	TTL:         60,
+	MaxMemoryMB: 256,
+}
+
+func (c *resultCacheConfig) enabled() bool {
+	return c != nil && c.Enabled && c.TTL > 0
+}
+
+const (
+	cacheHit  = "hit"
+	cacheMiss = "miss"
+	// cacheShared means the result is from an execution started by another identical query.
+	cacheShared = "shared"
+)
+
+type resultEntry struct {
+	key       string
+	tables    []*table
+	size      int64
+	expiresAt time.Time
+}
+
+// resultCall is an execution of a query shared by the identical queries waiting for it.
+// It is cancelled only when every waiter has left.
+type resultCall struct {
+	done    chan struct{}
+	tables  []*table
+	err     error
+	waiters int
+	cancel  context.CancelFunc
+}
+
+// queryExecutor executes a query within the timeout, or without a timeout if it is 0.
+type queryExecutor func(ctx context.Context, timeout time.Duration) ([]*table, error)
+
+// resultCache caches the tables of executed queries, keyed on the SQL after the macros are evaluated.
+// Identical queries running at the same time share one execution.
+// A nil *resultCache is valid and executes every query.
+type resultCache struct {
+	mu        sync.Mutex
+	ttl       time.Duration
+	maxBytes  int64
+	usedBytes int64
+	entries   map[string]*list.Element
+	lru       *list.List
+	calls     map[string]*resultCall
+	now       func() time.Time
+}
+
+func newResultCache(cfg *resultCacheConfig) *resultCache {
+	if !cfg.enabled() {
+		return nil
+	}
+
+	maxMemoryMB := cfg.MaxMemoryMB
+	if maxMemoryMB <= 0 {
+		maxMemoryMB = defaultResultCacheConfig.MaxMemoryMB
+	}
+
+	return &resultCache{
+		ttl:      time.Duration(cfg.TTL) * time.Second,
+		maxBytes: int64(maxMemoryMB) << 20,
+		entries:  make(map[string]*list.Element),
+		lru:      list.New(),
+		calls:    make(map[string]*resultCall),
+		now:      time.Now,
+	}
+}
+
+// execute returns the cached tables of the key, or executes the query and caches its tables.
+// The returned status is one of cacheHit, cacheMiss and cacheShared, or empty if the cache is disabled.
+//
+// The execution is shared, so it runs on a context detached from ctx within timeout, which is a part of
+// the key, so every waiter has the same timeout. A waiter stops waiting when ctx is done or the timeout passes,
+// and the execution is cancelled, on Snowflake as well, only when no waiter is left.
+func (c *resultCache) execute(ctx context.Context, key string, timeout time.Duration, exec queryExecutor) ([]*table, string, error) {
+	if c == nil {
+		tables, err := exec(ctx, timeout)
+		return tables, "", err
+	}
+
+	if tables, found := c.get(key); found {
+		return tables, cacheHit, nil
+	}
+
+	status := cacheShared
+
+	c.mu.Lock()
+	call, found := c.calls[key]
+	if !found {
+		status = cacheMiss
+
+		callCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+		call = &resultCall{done: make(chan struct{}), cancel: cancel}
+		c.calls[key] = call
+
+		go c.run(callCtx, key, timeout, call, exec)
+	}
+	call.waiters++
+	c.mu.Unlock()
+
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	select {
+	case <-call.done:
+		c.leave(key, call)
+		if call.err != nil {
+			return nil, "", call.err
+		}
+		return call.tables, status, nil
+	case <-ctx.Done():
+		c.leave(key, call)
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return nil, "", er.NewErrorF(er.ErrQueryTimeout, "stopped waiting for the query after %v", timeout)
+		}
+		return nil, "", er.NewError(er.ErrQueryCancelled, ctx.Err())
+	}
+}
+
+func (c *resultCache) run(ctx context.Context, key string, timeout time.Duration, call *resultCall, exec queryExecutor) {
+	defer call.cancel()
+
+	call.tables, call.err = exec(ctx, timeout)
+	if call.err == nil {
+		c.set(key, call.tables)
+	}
+
+	c.mu.Lock()
+	if c.calls[key] == call {
+		delete(c.calls, key)
+	}
+	c.mu.Unlock()
+
+	close(call.done)
+}
+
+// leave removes a waiter from the call, and cancels the call if it was the last one.
+func (c *resultCache) leave(key string, call *resultCall) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	call.waiters--
+	if call.waiters > 0 {
+		return
+	}
+
+	// A later identical query starts a new execution instead of joining the cancelled one.
+	if c.calls[key] == call {
+		delete(c.calls, key)
+	}
+	call.cancel()
+}
+
+func (c *resultCache) get(key string) ([]*table, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, found := c.entries[key]
+	if !found {
+		return nil, false
+	}
+
+	entry := elem.Value.(*resultEntry)
+	if c.now().After(entry.expiresAt) {
+		c.remove(elem)
+		return nil, false
+	}
+
+	c.lru.MoveToFront(elem)
+	return entry.tables, true
+}
+
+func (c *resultCache) set(key string, tables []*table) {
+	var size int64
+	for _, t := range tables {
+		size += t.size()
+	}
+	if size > c.maxBytes {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, found := c.entries[key]; found {
+		c.remove(elem)
+	}
+
+	c.entries[key] = c.lru.PushFront(&resultEntry{key, tables, size, c.now().Add(c.ttl)})
+	c.usedBytes += size
+
+	for c.usedBytes > c.maxBytes {
+		c.remove(c.lru.Back())
+	}
+}
+
+func (c *resultCache) clear() {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.entries = make(map[string]*list.Element)
+	c.lru.Init()
+	c.usedBytes = 0
+}
+
+func (c *resultCache) remove(elem *list.Element) {
+	entry := c.lru.Remove(elem).(*resultEntry)
+	delete(c.entries, entry.key)
+	c.usedBytes -= entry.size
+}
+
+// cacheKey identifies the result of the query. The row limit, the multi-statement mode and the bind
+// variables are parts of the key, because the same SQL returns different tables under them.
+// So is the user of a forwarded OAuth identity, whose grants may differ from the others,
+// and the timeout, within which the shared execution runs.
+func (qm *queryModel) cacheKey() string {
+	return fmt.Sprintf("%s/%v/%d/%t/%s/%#v/%s", qm.user, qm.timeout, qm.maxRows, qm.failOnMaxRows, qm.multiStatement, qm.args, qm.sql)
+}
+
+// alignTimeRange widens the time range to the boundaries of the step, so that the macros evaluate
+// to the same SQL for every refresh within a step, and the cached result can be reused.
+// The rows outside the time range of the query are trimmed from the result, see trimTimeRange.
+func alignTimeRange(from time.Time, to time.Time, step time.Duration) (time.Time, time.Time) {
+	if step <= 0 {
+		return from, to
+	}
+
+	return from.Truncate(step), to.Truncate(step).Add(step - time.Nanosecond)
+}
+
+// trimTimeRange drops the rows whose time is outside the time range of the query, which were read
+// because the macros were evaluated in the aligned range. The time is of the first time field,
+// which is the time of a time series, and a frame without a time field is returned as it is.
+// With $__timeGroup, the rows of the time slice that contains from are kept.
+func (qm *queryModel) trimTimeRange(frame *data.Frame) (*data.Frame, error) {
+	if !qm.trimToRange {
+		return frame, nil
+	}
+
+	fieldIdx := -1
+	for i, field := range frame.Fields {
+		if field.Type().Time() {
+			fieldIdx = i
+			break
+		}
+	}
+	if fieldIdx < 0 {
+		return frame, nil
+	}
+
+	from := qm.from
+	if qm.step.unit != "" {
+		from = qm.step.truncate(from)
+	}
+
+	return frame.FilterRowsByField(fieldIdx, func(v interface{}) (bool, error) {
+		switch t := v.(type) {
+		case time.Time:
+			return !t.Before(from) && !t.After(qm.to), nil
+		case *time.Time:
+			return t == nil || (!t.Before(from) && !t.After(qm.to)), nil
+		}
+		return true, nil
+	})
+}
@@ -0,0 +1,380 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/nexon/sunflake/pkg/util/er"
+)
+
+func int64Table(values ...int64) *table {
+	col := make([]*int64, len(values))
+	for i := range values {
+		col[i] = &values[i]
+	}
+	return &table{cols: []column{{name: "n", values: col}}, rowCount: len(values)}
+}
+
+// waitForWaiters waits until the execution of the key has n waiters.
+func waitForWaiters(t *testing.T, c *resultCache, key string, n int) {
+	for i := 0; i < 1000; i++ {
+		c.mu.Lock()
+		call, found := c.calls[key]
+		joined := found && call.waiters == n
+		c.mu.Unlock()
+
+		if joined {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatalf("expected %d waiters of [%s]", n, key)
+}
+
+func TestResultCacheHitMiss(t *testing.T) {
+	c := newResultCache(&resultCacheConfig{Enabled: true, TTL: 60})
+	now := time.Date(2024, 3, 19, 13, 0, 0, 0, time.UTC)
+	c.now = func() time.Time { return now }
+
+	var executions int
+	exec := func(context.Context, time.Duration) ([]*table, error) {
+		executions++
+		return []*table{int64Table(1, 2)}, nil
+	}
+
+	tests := []struct {
+		after    time.Duration
+		key      string
+		expected string
+	}{
+		{0, "a", cacheMiss},
+		{30 * time.Second, "a", cacheHit},
+		{0, "b", cacheMiss},
+		// The result of a expired 60 seconds after it was cached.
+		{31 * time.Second, "a", cacheMiss},
+		{0, "a", cacheHit},
+	}
+
+	for _, tt := range tests {
+		now = now.Add(tt.after)
+
+		tables, status, err := c.execute(context.Background(), tt.key, 0, exec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if status != tt.expected {
+			t.Errorf("expected [%s] of [%s], but got [%s]", tt.expected, tt.key, status)
+		}
+		if len(tables) != 1 || tables[0].rowCount != 2 {
+			t.Errorf("unexpected tables of [%s]: %v", tt.key, tables)
+		}
+	}
+
+	if executions != 3 {
+		t.Errorf("expected 3 executions, but got %d", executions)
+	}
+}
+
+func TestResultCacheError(t *testing.T) {
+	c := newResultCache(&resultCacheConfig{Enabled: true, TTL: 60})
+
+	var executions int
+	exec := func(context.Context, time.Duration) ([]*table, error) {
+		executions++
+		return nil, errors.New("failed")
+	}
+
+	for i := 0; i < 2; i++ {
+		if _, _, err := c.execute(context.Background(), "a", 0, exec); err == nil {
+			t.Error("expected the error of the execution")
+		}
+	}
+
+	if executions != 2 {
+		t.Errorf("expected an error not to be cached, but got %d executions", executions)
+	}
+}
+
+func TestResultCacheShared(t *testing.T) {
+	c := newResultCache(&resultCacheConfig{Enabled: true, TTL: 60})
+
+	var executions atomic.Int32
+	release := make(chan struct{})
+	exec := func(ctx context.Context, timeout time.Duration) ([]*table, error) {
+		executions.Add(1)
+		if timeout != 10*time.Second {
+			t.Errorf("expected the shared execution to run within the timeout of the query, but got %v", timeout)
+		}
+		<-release
+		return []*table{int64Table(1)}, nil
+	}
+
+	statuses := make([]string, 2)
+	var wg sync.WaitGroup
+	for i := range statuses {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, status, err := c.execute(context.Background(), "a", 10*time.Second, exec)
+			if err != nil {
+				t.Error(err)
+			}
+			statuses[i] = status
+		}(i)
+
+		waitForWaiters(t, c, "a", i+1)
+	}
+
+	close(release)
+	wg.Wait()
+
+	if statuses[0] != cacheMiss || statuses[1] != cacheShared {
+		t.Errorf("expected [miss shared], but got %v", statuses)
+	}
+	if executions.Load() != 1 {
+		t.Errorf("expected 1 execution, but got %d", executions.Load())
+	}
+}
+
+func TestResultCacheEviction(t *testing.T) {
+	c := newResultCache(&resultCacheConfig{Enabled: true, TTL: 60, MaxMemoryMB: 1})
+	// Each table is 3 rows of 16 bytes.
+	c.maxBytes = 100
+
+	c.set("a", []*table{int64Table(1, 2, 3)})
+	c.set("b", []*table{int64Table(1, 2, 3)})
+	if c.usedBytes != 96 {
+		t.Errorf("expected 96 bytes, but got %d", c.usedBytes)
+	}
+
+	// a is used more recently than b, so b is evicted for c.
+	c.get("a")
+	c.set("c", []*table{int64Table(1, 2, 3)})
+
+	if _, found := c.get("b"); found {
+		t.Error("expected the least recently used result to be evicted")
+	}
+	if _, found := c.get("a"); !found {
+		t.Error("expected the recently used result to be kept")
+	}
+	if c.usedBytes != 96 || c.lru.Len() != 2 {
+		t.Errorf("expected 2 results of 96 bytes, but got %d of %d bytes", c.lru.Len(), c.usedBytes)
+	}
+
+	// A result larger than the cache is not cached, and evicts nothing.
+	c.set("d", []*table{int64Table(make([]int64, 10)...)})
+	if _, found := c.get("d"); found || c.lru.Len() != 2 {
+		t.Error("expected a result larger than the cache not to be cached")
+	}
+
+	c.set("a", []*table{int64Table(1)})
+	if c.usedBytes != 64 {
+		t.Errorf("expected a replaced result to release its bytes, but got %d", c.usedBytes)
+	}
+
+	c.clear()
+	if c.usedBytes != 0 || c.lru.Len() != 0 {
+		t.Errorf("expected an empty cache, but got %d results of %d bytes", c.lru.Len(), c.usedBytes)
+	}
+}
+
+func TestTableSize(t *testing.T) {
+	s := "abc"
+	raw := json.RawMessage(`{"a":1}`)
+	f := 1.5
+	b := true
+
+	tbl := &table{cols: []column{
+		{values: []*string{&s, nil}},
+		{values: []*int64{nil, nil}},
+		{values: []*float64{&f, nil}},
+		{values: []time.Time{{}, {}}},
+		{values: []*bool{&b, nil}},
+		{values: []*json.RawMessage{&raw, nil}},
+	}}
+
+	// string: 8+16+3 and 8, int64: 2*16, float64: 2*16, time: 2*24, bool: 2*9, json: 8+24+7 and 8
+	expected := int64(35 + 32 + 32 + 48 + 18 + 47)
+	if size := tbl.size(); size != expected {
+		t.Errorf("expected %d bytes, but got %d", expected, size)
+	}
+}
+
+func TestAlignTimeRange(t *testing.T) {
+	from := time.Date(2024, 3, 19, 13, 0, 42, 0, time.UTC)
+	to := time.Date(2024, 3, 19, 14, 3, 10, 0, time.UTC)
+
+	alignedFrom, alignedTo := alignTimeRange(from, to, time.Minute)
+	if expected := time.Date(2024, 3, 19, 13, 0, 0, 0, time.UTC); !alignedFrom.Equal(expected) {
+		t.Errorf("expected [%v], but got [%v]", expected, alignedFrom)
+	}
+	if expected := time.Date(2024, 3, 19, 14, 3, 59, 999999999, time.UTC); !alignedTo.Equal(expected) {
+		t.Errorf("expected [%v], but got [%v]", expected, alignedTo)
+	}
+
+	// A refresh within the same minute gives the same range.
+	againFrom, againTo := alignTimeRange(from.Add(10*time.Second), to.Add(40*time.Second), time.Minute)
+	if !againFrom.Equal(alignedFrom) || !againTo.Equal(alignedTo) {
+		t.Errorf("expected the same range, but got [%v, %v]", againFrom, againTo)
+	}
+
+	if f, e := alignTimeRange(from, to, 0); !f.Equal(from) || !e.Equal(to) {
+		t.Error("expected the range not to be aligned without a step")
+	}
+}
+
+func TestTrimTimeRange(t *testing.T) {
+	dm := &datasourceModel{ResultCache: &resultCacheConfig{Enabled: true, TTL: 60}}
+	from := time.Date(2024, 3, 19, 13, 0, 42, 0, time.UTC)
+	to := time.Date(2024, 3, 19, 14, 3, 10, 0, time.UTC)
+
+	tests := []struct {
+		queryText string
+		sql       string
+		times     []time.Time
+		expected  []time.Time
+	}{
+		{
+			"SELECT ts AS time, v FROM t WHERE $__timeFilter(ts)",
+			"SELECT ts AS time, v FROM t WHERE ts BETWEEN '2024-03-19T13:00:00Z' AND '2024-03-19T14:03:59.999999999Z'",
+			[]time.Time{from.Add(-time.Second), from, to, to.Add(time.Second)},
+			[]time.Time{from, to},
+		},
+		{
+			// The time slice of from starts before from, but is kept.
+			"SELECT $__timeGroup(ts, '1m') AS time, v FROM t WHERE $__timeFilter(ts)",
+			"SELECT TIME_SLICE(TO_TIMESTAMP_NTZ(ts), 60, 'SECOND', 'START') AS time, v FROM t WHERE ts BETWEEN '2024-03-19T13:00:00Z' AND '2024-03-19T14:03:59.999999999Z'",
+			[]time.Time{from.Truncate(time.Minute), to.Truncate(time.Minute)},
+			[]time.Time{from.Truncate(time.Minute), to.Truncate(time.Minute)},
+		},
+	}
+
+	for _, tt := range tests {
+		query := &backend.DataQuery{
+			JSON:      []byte(fmt.Sprintf(`{"queryText": %q}`, tt.queryText)),
+			TimeRange: backend.TimeRange{From: from, To: to},
+			Interval:  time.Minute,
+		}
+		qm, err := buildQueryModel(query, dm)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// The SQL is of the aligned range, so that the refreshes within a minute share the result.
+		if qm.sql != tt.sql {
+			t.Errorf("expected [%s], but got [%s]", tt.sql, qm.sql)
+		}
+
+		values := make([]int64, len(tt.times))
+		tbl := &table{cols: []column{{name: "time", values: tt.times}, int64Table(values...).cols[0]}, rowCount: len(tt.times)}
+		frame, err := qm.convertToFrame(tbl)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var actual []time.Time
+		for i := 0; i < frame.Fields[0].Len(); i++ {
+			actual = append(actual, frame.Fields[0].At(i).(time.Time))
+		}
+		if !reflect.DeepEqual(actual, tt.expected) {
+			t.Errorf("%s: expected the rows at %v, but got %v", tt.queryText, tt.expected, actual)
+		}
+	}
+}
+
+func TestResultCacheCancelWaiter(t *testing.T) {
+	c := newResultCache(&resultCacheConfig{Enabled: true, TTL: 60})
+
+	release := make(chan struct{})
+	cancelled := make(chan struct{})
+	exec := func(ctx context.Context, _ time.Duration) ([]*table, error) {
+		select {
+		case <-release:
+			return []*table{int64Table(1)}, nil
+		case <-ctx.Done():
+			// The query would be cancelled on Snowflake here.
+			close(cancelled)
+			return nil, ctx.Err()
+		}
+	}
+
+	first, cancelFirst := context.WithCancel(context.Background())
+	firstErr := make(chan error, 1)
+	go func() {
+		_, _, err := c.execute(first, "a", 0, exec)
+		firstErr <- err
+	}()
+	waitForWaiters(t, c, "a", 1)
+
+	secondDone := make(chan string, 1)
+	go func() {
+		_, status, err := c.execute(context.Background(), "a", 0, exec)
+		if err != nil {
+			t.Error(err)
+		}
+		secondDone <- status
+	}()
+	waitForWaiters(t, c, "a", 2)
+
+	// The first waiter leaves, but the execution keeps running for the second one.
+	cancelFirst()
+	if err := <-firstErr; err == nil {
+		t.Error("expected the cancelled waiter to get an error")
+	}
+
+	select {
+	case <-cancelled:
+		t.Fatal("expected the execution not to be cancelled while a waiter is left")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	close(release)
+	if status := <-secondDone; status != cacheShared {
+		t.Errorf("expected the shared result, but got [%s]", status)
+	}
+}
+
+func TestResultCacheCancelAllWaiters(t *testing.T) {
+	c := newResultCache(&resultCacheConfig{Enabled: true, TTL: 60})
+
+	cancelled := make(chan struct{})
+	exec := func(ctx context.Context, _ time.Duration) ([]*table, error) {
+		<-ctx.Done()
+		close(cancelled)
+		return nil, ctx.Err()
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		_, _, err := c.execute(ctx, "a", 0, exec)
+		done <- err
+	}()
+	waitForWaiters(t, c, "a", 1)
+
+	cancel()
+	<-done
+
+	select {
+	case <-cancelled:
+	case <-time.After(time.Second):
+		t.Fatal("expected the execution to be cancelled when no waiter is left")
+	}
+
+	// A timeout of a waiter is reported as a timeout.
+	never := func(ctx context.Context, _ time.Duration) ([]*table, error) {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	if _, _, err := c.execute(context.Background(), "b", time.Millisecond, never); er.GetCode(err) != er.ErrQueryTimeout {
+		t.Errorf("expected ErrQueryTimeout, but got [%v]", err)
+	}
+}
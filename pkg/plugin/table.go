@@ -2,26 +2,48 @@ package plugin
 
 import (
 	"database/sql"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"math/big"
 	"strconv"
 	"time"
 
 	"github.com/grafana/grafana-plugin-sdk-go/data"
+
+	sf "github.com/nexon/sunflake/pkg/snowflake"
+	"github.com/nexon/sunflake/pkg/util/er"
 )
 
 type appendFunc func(slice interface{}, v interface{}) (newSlice interface{}, err error)
 
 type table struct {
 	cols []column
+	// truncated is true when the result had more rows than the limit, and the rest were dropped.
+	truncated bool
+	rowCount  int
 }
 
 type column struct {
-	columnType *sql.ColumnType
-	appendf    appendFunc
-	values     interface{}
+	name         string
+	databaseType string
+	precision    int64
+	scale        int64
+	appendf      appendFunc
+	values       interface{}
+	// overflowed is true when an integer exceeded int64, and the column was converted to float64.
+	overflowed bool
+	// precisionLost is true when a decimal could not be kept in float64 as it is.
+	precisionLost bool
 }
 
-func newTableFromRows(rows *sql.Rows) (*table, error) {
+// errInt64Overflow is returned by an appender when an integer does not fit in int64.
+var errInt64Overflow = errors.New("the value is out of the range of int64")
+
+// newTableFromRows reads the rows into a table. If maxRows > 0, it reads at most maxRows rows
+// and marks the table truncated, or returns an error when failOnMaxRows is true.
+// TIMESTAMP_NTZ and DATE values are read as the wall clock in loc.
+func newTableFromRows(rows *sql.Rows, maxRows int, failOnMaxRows bool, loc *time.Location) (*table, error) {
 	types, err := rows.ColumnTypes()
 	if err != nil {
 		return nil, fmt.Errorf("failed to build the dataframe, caused by an error from rows.ColumnTypes(): %v", err)
@@ -38,14 +60,21 @@ func newTableFromRows(rows *sql.Rows) (*table, error) {
 		scanValues[i] = new(any)
 	}
 
-	table, err := newTable(types)
+	table, err := newTable(types, loc)
 	if err != nil {
 		return nil, fmt.Errorf("failed to build the dataframe, cause by an error from newTable(): %v", err)
 	}
 
 	rowCount := 0
 	for rows.Next() {
-		// TODO: check if rowCount exceeds the limit
+		if maxRows > 0 && rowCount >= maxRows {
+			if failOnMaxRows {
+				return nil, er.NewErrorF(er.ErrTooManyRows, "failed to build the dataframe, the result has more than %d rows", maxRows)
+			}
+			table.truncated = true
+			break
+		}
+
 		err := rows.Scan(scanValues...)
 		if err != nil {
 			return nil, fmt.Errorf("failed to build the dataframe, caused by an error from rows.Scan(): %v", err)
@@ -61,7 +90,7 @@ func newTableFromRows(rows *sql.Rows) (*table, error) {
 		rowCount++
 	}
 
-	// log.DefaultLogger.Info(fmt.Sprintf("rowCount: %d", rowCount))
+	table.rowCount = rowCount
 
 	if err := rows.Err(); err != nil {
 		return nil, fmt.Errorf("failed to scan rows: %v", err)
@@ -70,56 +99,229 @@ func newTableFromRows(rows *sql.Rows) (*table, error) {
 	return table, nil
 }
 
-func newTable(types []*sql.ColumnType) (*table, error) {
-	cols, err := initColumns(types)
+// newTableFromArrow builds a table from the column values read in arrow record batches.
+// Values are not appended to the table any more, so the columns have no appender.
+// TIMESTAMP_NTZ and DATE values are read as the wall clock in loc.
+func newTableFromArrow(result *sf.ArrowResult, loc *time.Location) *table {
+	cols := make([]column, len(result.Columns))
+
+	for i, c := range result.Columns {
+		if hasWallClock(c.DatabaseType) {
+			switch times := c.Values.(type) {
+			case []time.Time:
+				for j, v := range times {
+					times[j] = wallClockIn(v, loc)
+				}
+			case []*time.Time:
+				for _, v := range times {
+					if v != nil {
+						*v = wallClockIn(*v, loc)
+					}
+				}
+			}
+		}
+
+		cols[i] = column{
+			name:         c.Name,
+			databaseType: c.DatabaseType,
+			values:       c.Values,
+		}
+	}
+
+	return &table{cols, result.Truncated, result.RowCount}
+}
+
+func newTable(types []*sql.ColumnType, loc *time.Location) (*table, error) {
+	cols, err := initColumns(types, loc)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create a table: %v", err)
 	}
 
-	return &table{cols}, nil
+	return &table{cols: cols}, nil
 }
 
-func initColumns(types []*sql.ColumnType) ([]column, error) {
+func initColumns(types []*sql.ColumnType, loc *time.Location) ([]column, error) {
 	cols := make([]column, len(types))
 
 	for i, t := range types {
-		appendf, err := getAppender(t)
+		c, err := newColumn(t, loc)
 		if err != nil {
 			return nil, fmt.Errorf("failed to init columns: %v", err)
 		}
 
-		columnValues, err := newColumnVaules(t)
-		if err != nil {
-			return nil, fmt.Errorf("failed to init columns: %v", err)
-		}
+		cols[i] = c
+	}
 
-		cols[i] = column{
-			t,
-			appendf,
-			columnValues,
+	return cols, nil
+}
+
+// newColumn maps the Snowflake type of the column to the type of its values.
+// Types not listed here are mapped by the scan type of the driver.
+func newColumn(t *sql.ColumnType, loc *time.Location) (column, error) {
+	c := column{
+		name:         t.Name(),
+		databaseType: t.DatabaseTypeName(),
+	}
+
+	switch c.databaseType {
+	case "FIXED":
+		c.precision, c.scale, _ = t.DecimalSize()
+		if c.scale > 0 {
+			c.appendf = appendValueToFloat64
+			c.values = make([]*float64, 0)
+		} else {
+			c.appendf = appendStringToInt64
+			c.values = make([]*int64, 0)
 		}
+		return c, nil
+	case "REAL":
+		c.appendf = appendValueToFloat64
+		c.values = make([]*float64, 0)
+		return c, nil
+	case "VARIANT", "OBJECT", "ARRAY":
+		c.appendf = appendStringToJSON
+		c.values = make([]*json.RawMessage, 0)
+		return c, nil
+	case "TIMESTAMP_NTZ", "DATE":
+		c.appendf = appendWallClockToTime(loc)
+		c.values = make([]time.Time, 0)
+		return c, nil
 	}
 
-	return cols, nil
+	appendf, err := getAppender(t)
+	if err != nil {
+		return c, err
+	}
+
+	columnValues, err := newColumnVaules(t)
+	if err != nil {
+		return c, err
+	}
+
+	c.appendf = appendf
+	c.values = columnValues
+	return c, nil
 }
 
 func (t *table) append(columnIndex int, columnValue interface{}) error {
-	values := t.cols[columnIndex].values
-	values, err := t.cols[columnIndex].appendf(values, columnValue)
+	c := &t.cols[columnIndex]
+
+	values, err := c.appendf(c.values, columnValue)
+	if errors.Is(err, errInt64Overflow) {
+		c.convertToFloat64()
+		values, err = c.appendf(c.values, columnValue)
+	}
 	if err != nil {
 		return err
 	}
 
-	t.cols[columnIndex].values = values
+	c.values = values
+
+	if c.scale > 0 && !c.precisionLost && !sf.IsExactInFloat64(c.precision, c.scale) {
+		c.precisionLost = isPrecisionLost(columnValue, values.([]*float64), c.scale)
+	}
+
 	return nil
 }
 
+// convertToFloat64 converts the values of an integer column to float64, which holds
+// integers out of the range of int64 at the cost of precision.
+func (c *column) convertToFloat64() {
+	ints := c.values.([]*int64)
+	floats := make([]*float64, len(ints))
+
+	for i, n := range ints {
+		if n != nil {
+			f := float64(*n)
+			floats[i] = &f
+		}
+	}
+
+	c.values = floats
+	c.appendf = appendValueToFloat64
+	c.overflowed = true
+}
+
+// isPrecisionLost reports whether the last value appended to the float64 values differs from
+// the decimal v, when both are rounded to the scale.
+func isPrecisionLost(v interface{}, values []*float64, scale int64) bool {
+	s, ok := v.(string)
+	if !ok || len(values) == 0 || values[len(values)-1] == nil {
+		return false
+	}
+
+	exact, ok := new(big.Rat).SetString(s)
+	if !ok {
+		return false
+	}
+
+	f := *values[len(values)-1]
+	return exact.FloatString(int(scale)) != strconv.FormatFloat(f, 'f', int(scale), 64)
+}
+
+// notices returns the warnings about the values converted with a loss.
+func (t *table) notices() []data.Notice {
+	notices := make([]data.Notice, 0)
+
+	for _, c := range t.cols {
+		if c.overflowed {
+			notices = append(notices, data.Notice{
+				Severity: data.NoticeSeverityWarning,
+				Text:     fmt.Sprintf("The values of the column [%s] exceed the range of int64, so they were converted to float64 and may have lost precision.", c.name),
+			})
+		} else if c.precisionLost {
+			notices = append(notices, data.Notice{
+				Severity: data.NoticeSeverityWarning,
+				Text:     fmt.Sprintf("Some values of the column [%s] NUMBER(%d,%d) lost precision when they were converted to float64.", c.name, c.precision, c.scale),
+			})
+		}
+	}
+
+	return notices
+}
+
+// size estimates the memory used by the values of the table, in bytes.
+func (t *table) size() int64 {
+	var size int64
+
+	for _, c := range t.cols {
+		switch values := c.values.(type) {
+		case []*string:
+			for _, v := range values {
+				size += 8
+				if v != nil {
+					size += 16 + int64(len(*v))
+				}
+			}
+		case []*int64:
+			size += int64(len(values)) * 16
+		case []*float64:
+			size += int64(len(values)) * 16
+		case []time.Time:
+			size += int64(len(values)) * 24
+		case []*time.Time:
+			size += int64(len(values)) * 32
+		case []*bool:
+			size += int64(len(values)) * 9
+		case []*json.RawMessage:
+			for _, v := range values {
+				size += 8
+				if v != nil {
+					size += 24 + int64(len(*v))
+				}
+			}
+		}
+	}
+
+	return size
+}
+
 func (t *table) convertToFrame(name string) (frame *data.Frame, err error) {
 	frame = data.NewFrame(name)
 
 	for _, c := range t.cols {
 		// TODO: add label
-		frame.Fields = append(frame.Fields, data.NewField(c.columnType.Name(), nil, c.values))
+		frame.Fields = append(frame.Fields, data.NewField(c.name, nil, c.values))
 	}
 
 	return frame, nil
@@ -169,18 +371,49 @@ func appendStringToString(slice interface{}, v interface{}) (interface{}, error)
 	return s, nil
 }
 
-func appendStringToInt64(slice interface{}, v interface{}) (interface{}, error) {
+// appendStringToJSON appends the JSON text of a semi-structured value.
+func appendStringToJSON(slice interface{}, v interface{}) (interface{}, error) {
+	s, ok := slice.([]*json.RawMessage)
+	if !ok {
+		return nil, fmt.Errorf("failed to convert slice to []*json.RawMessage")
+	}
+
+	if v == nil {
+		return append(s, nil), nil
+	}
+
 	e, ok := v.(string)
 	if !ok {
-		return nil, fmt.Errorf("failed to convert %T[%v] to the int64 type", v, v)
+		return nil, fmt.Errorf("failed to convert %T[%v] to the json type", v, v)
 	}
 
+	if !json.Valid([]byte(e)) {
+		return nil, fmt.Errorf("failed to parse %T[%v] to json", v, v)
+	}
+
+	m := json.RawMessage(e)
+	return append(s, &m), nil
+}
+
+func appendStringToInt64(slice interface{}, v interface{}) (interface{}, error) {
 	s, ok := slice.([]*int64)
 	if !ok {
 		return nil, fmt.Errorf("failed to convert slice to []int64")
 	}
 
+	if v == nil {
+		return append(s, nil), nil
+	}
+
+	e, ok := v.(string)
+	if !ok {
+		return nil, fmt.Errorf("failed to convert %T[%v] to the int64 type", v, v)
+	}
+
 	n, err := strconv.ParseInt(e, 10, 64)
+	if errors.Is(err, strconv.ErrRange) {
+		return nil, fmt.Errorf("failed to parse %T[%v] to int64: %w", v, v, errInt64Overflow)
+	}
 	if err != nil {
 		return nil, fmt.Errorf("failed to parse %T[%v] to int64", v, v)
 	}
@@ -226,6 +459,12 @@ func appendStringToFloat64(slice interface{}, v interface{}) (interface{}, error
 
 func appendValueToFloat64(slice interface{}, v interface{}) (interface{}, error) {
 	switch t := v.(type) {
+	case nil:
+		s, ok := slice.([]*float64)
+		if !ok {
+			return nil, fmt.Errorf("failed to convert slice to []float64, slice is [%T]type", slice)
+		}
+		return append(s, nil), nil
 	case string:
 		return appendStringToFloat64(slice, v)
 	case float64:
@@ -235,19 +474,63 @@ func appendValueToFloat64(slice interface{}, v interface{}) (interface{}, error)
 	}
 }
 
+// appendTimeToTime appends a time. The values become []*time.Time at the first null.
 func appendTimeToTime(slice interface{}, v interface{}) (interface{}, error) {
+	if times, ok := slice.([]time.Time); ok && v == nil {
+		slice = sf.NullableTimes(times)
+	}
+
+	if v == nil {
+		s, ok := slice.([]*time.Time)
+		if !ok {
+			return nil, fmt.Errorf("failed to convert slice to []*time.Time")
+		}
+		return append(s, nil), nil
+	}
+
 	e, ok := v.(time.Time)
 	if !ok {
 		return nil, fmt.Errorf("failed to convert %T[%v] to the time.Time", v, v)
 	}
 
-	s, ok := slice.([]time.Time)
-	if !ok {
-		return nil, fmt.Errorf("failed to convert slice to []time.Time")
+	switch s := slice.(type) {
+	case []time.Time:
+		return append(s, e), nil
+	case []*time.Time:
+		return append(s, &e), nil
 	}
 
-	s = append(s, e)
-	return s, nil
+	return nil, fmt.Errorf("failed to convert slice to []time.Time")
+}
+
+// appendWallClockToTime returns an appender which reads the wall clock of the value in loc.
+func appendWallClockToTime(loc *time.Location) appendFunc {
+	return func(slice interface{}, v interface{}) (interface{}, error) {
+		if v == nil {
+			return appendTimeToTime(slice, nil)
+		}
+
+		e, ok := v.(time.Time)
+		if !ok {
+			return nil, fmt.Errorf("failed to convert %T[%v] to the time.Time", v, v)
+		}
+
+		return appendTimeToTime(slice, wallClockIn(e, loc))
+	}
+}
+
+// hasWallClock reports whether the values of the type have no time zone.
+// The driver returns them in UTC, but they are the wall clock in the session time zone.
+func hasWallClock(databaseType string) bool {
+	return databaseType == "TIMESTAMP_NTZ" || databaseType == "DATE"
+}
+
+func wallClockIn(t time.Time, loc *time.Location) time.Time {
+	if loc == nil || loc == time.UTC {
+		return t
+	}
+
+	return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), loc)
 }
 
 // func appendStringToBool(slice interface{}, v interface{}) (interface{}, error) {
@@ -0,0 +1,240 @@
+package plugin
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/apache/arrow/go/v14/arrow"
+	"github.com/apache/arrow/go/v14/arrow/array"
+	"github.com/apache/arrow/go/v14/arrow/memory"
+
+	sf "github.com/nexon/sunflake/pkg/snowflake"
+	"github.com/nexon/sunflake/pkg/util/er"
+)
+
+const benchmarkRows = 100000
+
+var benchmarkStart = time.Date(2024, 3, 19, 13, 0, 0, 0, time.UTC)
+
+// BenchmarkScanRows appends the values as the scanner reads them from gosnowflake,
+// which returns numbers as strings.
+func BenchmarkScanRows(b *testing.B) {
+	rows := make([][]any, benchmarkRows)
+	for i := range rows {
+		rows[i] = []any{
+			benchmarkStart.Add(time.Duration(i) * time.Second),
+			"name-" + strconv.Itoa(i%10),
+			strconv.Itoa(i),
+			strconv.FormatFloat(float64(i)/3, 'f', -1, 64),
+		}
+	}
+
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		t := &table{cols: []column{
+			{name: "time", appendf: appendTimeToTime, values: make([]time.Time, 0)},
+			{name: "name", appendf: appendStringToString, values: make([]*string, 0)},
+			{name: "count", appendf: appendStringToInt64, values: make([]*int64, 0)},
+			{name: "size", appendf: appendValueToFloat64, values: make([]*float64, 0)},
+		}}
+
+		for _, row := range rows {
+			for i, v := range row {
+				if err := t.append(i, v); err != nil {
+					b.Fatal(err)
+				}
+			}
+		}
+
+		if _, err := t.convertToFrame("response"); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkArrowRecords converts the same values from an arrow record batch.
+func BenchmarkArrowRecords(b *testing.B) {
+	record := newBenchmarkRecord()
+	defer record.Release()
+
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		result := &sf.ArrowResult{Columns: []sf.ArrowColumn{
+			{Name: "time", Values: make([]time.Time, 0)},
+			{Name: "name", Values: make([]*string, 0)},
+			{Name: "count", Values: make([]*int64, 0)},
+			{Name: "size", Values: make([]*float64, 0)},
+		}}
+
+		if err := result.AppendRecord(record, 0); err != nil {
+			b.Fatal(err)
+		}
+
+		if _, err := newTableFromArrow(result, time.UTC).convertToFrame("response"); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func newBenchmarkRecord() arrow.Record {
+	schema := arrow.NewSchema([]arrow.Field{
+		{Name: "time", Type: &arrow.TimestampType{Unit: arrow.Nanosecond}},
+		{Name: "name", Type: arrow.BinaryTypes.String},
+		{Name: "count", Type: arrow.PrimitiveTypes.Int64},
+		{Name: "size", Type: arrow.PrimitiveTypes.Float64},
+	}, nil)
+
+	builder := array.NewRecordBuilder(memory.NewGoAllocator(), schema)
+	defer builder.Release()
+
+	for i := 0; i < benchmarkRows; i++ {
+		builder.Field(0).(*array.TimestampBuilder).Append(arrow.Timestamp(benchmarkStart.Add(time.Duration(i) * time.Second).UnixNano()))
+		builder.Field(1).(*array.StringBuilder).Append("name-" + strconv.Itoa(i%10))
+		builder.Field(2).(*array.Int64Builder).Append(int64(i))
+		builder.Field(3).(*array.Float64Builder).Append(float64(i) / 3)
+	}
+
+	return builder.NewRecord()
+}
+
+func TestAppendNumber(t *testing.T) {
+	tbl := &table{cols: []column{
+		{name: "total", databaseType: "FIXED", precision: 38, appendf: appendStringToInt64, values: make([]*int64, 0)},
+		{name: "amount", databaseType: "FIXED", precision: 38, scale: 2, appendf: appendValueToFloat64, values: make([]*float64, 0)},
+	}}
+
+	rows := [][]any{
+		{"1", "1.50"},
+		{nil, nil},
+		{"99999999999999999999", "123456789012345678.91"},
+	}
+	for _, row := range rows {
+		for i, v := range row {
+			if err := tbl.append(i, v); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	totals, ok := tbl.cols[0].values.([]*float64)
+	if !ok {
+		t.Fatalf("the overflowed column must be converted to []*float64, but got %T", tbl.cols[0].values)
+	}
+	if *totals[0] != 1 || totals[1] != nil || *totals[2] != 1e20 {
+		t.Errorf("unexpected values of the overflowed column: %v, %v, %v", *totals[0], totals[1], *totals[2])
+	}
+
+	if !tbl.cols[0].overflowed || tbl.cols[0].precisionLost {
+		t.Error("only overflowed must be set on the integer column")
+	}
+	if tbl.cols[1].overflowed || !tbl.cols[1].precisionLost {
+		t.Error("only precisionLost must be set on the decimal column")
+	}
+	if n := len(tbl.notices()); n != 2 {
+		t.Errorf("expected 2 notices, but got %d", n)
+	}
+}
+
+func TestAppendNullTime(t *testing.T) {
+	seoul, err := time.LoadLocation("Asia/Seoul")
+	if err != nil {
+		t.Skip("the time zone database is not available:", err)
+	}
+
+	tbl := &table{cols: []column{
+		{name: "ts", databaseType: "TIMESTAMP_NTZ", appendf: appendWallClockToTime(seoul), values: make([]time.Time, 0)},
+	}}
+
+	for _, v := range []any{benchmarkStart, nil, benchmarkStart} {
+		if err := tbl.append(0, v); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	times, ok := tbl.cols[0].values.([]*time.Time)
+	if !ok {
+		t.Fatalf("the column with a null must be converted to []*time.Time, but got %T", tbl.cols[0].values)
+	}
+	expected := time.Date(2024, 3, 19, 13, 0, 0, 0, seoul)
+	if len(times) != 3 || !times[0].Equal(expected) || times[1] != nil || !times[2].Equal(expected) {
+		t.Errorf("unexpected times: %v", times)
+	}
+
+	// The times read in arrow are the wall clock in the time zone as well.
+	ts := benchmarkStart
+	result := &sf.ArrowResult{Columns: []sf.ArrowColumn{
+		{Name: "ts", DatabaseType: "TIMESTAMP_NTZ", Values: []*time.Time{&ts, nil}},
+	}, RowCount: 2}
+	arrowTimes := newTableFromArrow(result, seoul).cols[0].values.([]*time.Time)
+	if !arrowTimes[0].Equal(expected) || arrowTimes[1] != nil {
+		t.Errorf("unexpected times read in arrow: %v", arrowTimes)
+	}
+}
+
+func TestNewTableFromRowsMaxRows(t *testing.T) {
+	connector := &fakeConnector{results: []fakeResult{{
+		columns: []string{"a"},
+		rows:    [][]driver.Value{{"1"}, {"2"}, {"3"}},
+	}}}
+	db := sql.OpenDB(connector)
+	defer db.Close()
+
+	tests := []struct {
+		maxRows       int
+		failOnMaxRows bool
+		rowCount      int
+		truncated     bool
+		code          int
+	}{
+		{0, false, 3, false, 0},
+		{3, false, 3, false, 0},
+		{3, true, 3, false, 0},
+		{2, false, 2, true, 0},
+		{2, true, 0, false, er.ErrTooManyRows},
+	}
+
+	for _, tt := range tests {
+		rows, err := db.Query("SELECT a FROM t")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		tbl, err := newTableFromRows(rows, tt.maxRows, tt.failOnMaxRows, time.UTC)
+		rows.Close()
+		if tt.code != 0 {
+			if er.GetCode(err) != tt.code {
+				t.Errorf("maxRows %d, failOnMaxRows %t: expected the error code [%v], but got [%v]", tt.maxRows, tt.failOnMaxRows, tt.code, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if tbl.rowCount != tt.rowCount || tbl.truncated != tt.truncated {
+			t.Errorf("maxRows %d, failOnMaxRows %t: expected [%d] rows and truncated [%t], but got [%d] and [%t]",
+				tt.maxRows, tt.failOnMaxRows, tt.rowCount, tt.truncated, tbl.rowCount, tbl.truncated)
+		}
+
+		qm := queryModel{}
+		if _, err := qm.convertToFrame(tbl); err != nil {
+			t.Fatal(err)
+		}
+
+		var notices []string
+		for _, notice := range qm.notices {
+			notices = append(notices, notice.Text)
+		}
+		var expected []string
+		if tt.truncated {
+			expected = []string{"The result exceeded the row limit. Only the first 2 rows were read, and the rest were dropped."}
+		}
+		if !reflect.DeepEqual(notices, expected) {
+			t.Errorf("maxRows %d, failOnMaxRows %t: expected the notices %q, but got %q", tt.maxRows, tt.failOnMaxRows, expected, notices)
+		}
+	}
+}
@@ -0,0 +1,178 @@
+package plugin
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/gtime"
+)
+
+const (
+	stepSecond = "SECOND"
+	stepDay    = "DAY"
+	stepWeek   = "WEEK"
+	stepMonth  = "MONTH"
+	stepYear   = "YEAR"
+)
+
+var matchCalendarInterval = regexp.MustCompile(`^(\d+)([dwMy])$`)
+
+var calendarUnits = map[string]string{
+	"d": stepDay,
+	"w": stepWeek,
+	"M": stepMonth,
+	"y": stepYear,
+}
+
+// weekAnchor is a Monday. Weeks are counted from it, shifted to the start of the week.
+var weekAnchor = time.Date(1900, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// timeStep is the interval of $__timeGroup. Days, weeks, months and years follow the calendar
+// in the time zone, so that they start at midnight even if a day is not 24 hours long.
+// Shorter intervals are a number of seconds, aligned as TIME_SLICE does.
+type timeStep struct {
+	count     int64
+	unit      string
+	location  *time.Location
+	weekStart time.Weekday
+}
+
+func parseTimeStep(interval string, loc *time.Location, weekStart time.Weekday) (timeStep, error) {
+	if m := matchCalendarInterval.FindStringSubmatch(interval); m != nil {
+		count, err := strconv.ParseInt(m[1], 10, 64)
+		if err != nil || count <= 0 {
+			return timeStep{}, fmt.Errorf("interval[%s] must be a positive number of the unit", interval)
+		}
+		return timeStep{count, calendarUnits[m[2]], loc, weekStart}, nil
+	}
+
+	it, err := gtime.ParseInterval(interval)
+	if err != nil {
+		return timeStep{}, fmt.Errorf("an error from parseInterval(%s): %v", interval, err)
+	}
+
+	if it.Seconds() < 1 {
+		return timeStep{}, fmt.Errorf("interval[%s] must be at least in seconds", interval)
+	}
+
+	return timeStep{int64(it.Seconds()), stepSecond, loc, weekStart}, nil
+}
+
+func (s timeStep) equal(o timeStep) bool {
+	sameLocation := (s.location == nil) == (o.location == nil) && s.location.String() == o.location.String()
+	return s.count == o.count && s.unit == o.unit && sameLocation && s.weekStart == o.weekStart
+}
+
+// duration returns the length of the step. It is approximate for months and years.
+func (s timeStep) duration() time.Duration {
+	day := 24 * time.Hour
+
+	switch s.unit {
+	case stepDay:
+		return time.Duration(s.count) * day
+	case stepWeek:
+		return time.Duration(s.count) * 7 * day
+	case stepMonth:
+		return time.Duration(s.count) * 30 * day
+	case stepYear:
+		return time.Duration(s.count) * 365 * day
+	default:
+		return time.Duration(s.count) * time.Second
+	}
+}
+
+// sql returns the expression which groups the column by the step, in the same way as truncate.
+func (s timeStep) sql(col string) string {
+	ts := toTimestampTZ(col, s.location)
+
+	switch s.unit {
+	case stepSecond, stepDay, stepMonth, stepYear:
+		return fmt.Sprintf("TIME_SLICE(%s, %d, '%s', 'START')", ts, s.count, s.unit)
+	default:
+		// TIME_SLICE does not follow WEEK_START, so the days since the start of an anchor week are counted instead.
+		return fmt.Sprintf("DATEADD('DAY', -MOD(DATEDIFF('DAY', '%s'::DATE, %s::DATE), %d), DATE_TRUNC('DAY', %s))",
+			s.anchor().Format(time.DateOnly), ts, 7*s.count, ts)
+	}
+}
+
+// truncate returns the start of the step which the time is in.
+func (s timeStep) truncate(t time.Time) time.Time {
+	if s.unit == stepSecond {
+		return toTimeGroup(t, s.duration(), orUTC(s.location))
+	}
+
+	t = t.In(orUTC(s.location))
+	date := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+
+	switch s.unit {
+	case stepDay:
+		days := int64(date.Sub(time.Unix(0, 0).UTC()).Hours() / 24)
+		date = time.Unix(0, 0).UTC().AddDate(0, 0, int(floorDiv(days, s.count)*s.count))
+	case stepWeek:
+		anchor := s.anchor()
+		days := int64(date.Sub(anchor).Hours() / 24)
+		date = anchor.AddDate(0, 0, int(floorDiv(days, 7*s.count)*7*s.count))
+	case stepMonth:
+		months := int64(t.Year()-1970)*12 + int64(t.Month()-1)
+		date = time.Date(1970, time.Month(floorDiv(months, s.count)*s.count+1), 1, 0, 0, 0, 0, time.UTC)
+	case stepYear:
+		years := int64(t.Year() - 1970)
+		date = time.Date(1970+int(floorDiv(years, s.count)*s.count), 1, 1, 0, 0, 0, 0, time.UTC)
+	}
+
+	return time.Date(date.Year(), date.Month(), date.Day(), 0, 0, 0, 0, orUTC(s.location))
+}
+
+// next returns the start of the step after the one starting at t.
+func (s timeStep) next(t time.Time) time.Time {
+	n := int(s.count)
+	t = t.In(orUTC(s.location))
+
+	switch s.unit {
+	case stepDay:
+		return t.AddDate(0, 0, n)
+	case stepWeek:
+		return t.AddDate(0, 0, 7*n)
+	case stepMonth:
+		return t.AddDate(0, n, 0)
+	case stepYear:
+		return t.AddDate(n, 0, 0)
+	default:
+		return t.Add(s.duration())
+	}
+}
+
+// toTimeGroup returns the start of the group of the time, aligned to the wall clock in the time zone
+// as TIME_SLICE does.
+func toTimeGroup(t time.Time, it time.Duration, loc *time.Location) time.Time {
+	sec := int64(it.Seconds())
+	_, offset := t.In(loc).Zone()
+	local := t.Unix() + int64(offset)
+	return time.Unix(local/sec*sec-int64(offset), 0)
+}
+
+func (s timeStep) anchor() time.Time {
+	return weekAnchor.AddDate(0, 0, (int(s.weekStart)-int(time.Monday)+7)%7)
+}
+
+func floorDiv(a int64, b int64) int64 {
+	q := a / b
+	if a%b != 0 && (a < 0) != (b < 0) {
+		q--
+	}
+	return q
+}
+
+// parseWeekday parses the name of a day of the week, such as "monday".
+func parseWeekday(name string) (time.Weekday, error) {
+	for d := time.Sunday; d <= time.Saturday; d++ {
+		if strings.EqualFold(name, d.String()) {
+			return d, nil
+		}
+	}
+
+	return 0, fmt.Errorf("unknown day of the week [%s]", name)
+}
@@ -0,0 +1,101 @@
+package plugin
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTimeStep(t *testing.T) {
+	seoul, err := time.LoadLocation("Asia/Seoul")
+	if err != nil {
+		t.Skip("the time zone database is not available:", err)
+	}
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skip("the time zone database is not available:", err)
+	}
+
+	tests := []struct {
+		interval  string
+		location  *time.Location
+		weekStart time.Weekday
+		time      time.Time
+		start     time.Time
+		next      time.Time
+	}{
+		{"5m", time.UTC, time.Monday,
+			time.Date(2024, 3, 19, 13, 7, 0, 0, time.UTC),
+			time.Date(2024, 3, 19, 13, 5, 0, 0, time.UTC),
+			time.Date(2024, 3, 19, 13, 10, 0, 0, time.UTC)},
+		{"1d", seoul, time.Monday,
+			time.Date(2024, 3, 19, 16, 0, 0, 0, time.UTC),
+			time.Date(2024, 3, 20, 0, 0, 0, 0, seoul),
+			time.Date(2024, 3, 21, 0, 0, 0, 0, seoul)},
+		// The day of the change to the daylight saving time is 23 hours long.
+		{"1d", newYork, time.Monday,
+			time.Date(2024, 3, 10, 12, 0, 0, 0, newYork),
+			time.Date(2024, 3, 10, 0, 0, 0, 0, newYork),
+			time.Date(2024, 3, 11, 0, 0, 0, 0, newYork)},
+		{"1w", time.UTC, time.Monday,
+			time.Date(2024, 3, 21, 13, 0, 0, 0, time.UTC),
+			time.Date(2024, 3, 18, 0, 0, 0, 0, time.UTC),
+			time.Date(2024, 3, 25, 0, 0, 0, 0, time.UTC)},
+		{"1w", time.UTC, time.Sunday,
+			time.Date(2024, 3, 21, 13, 0, 0, 0, time.UTC),
+			time.Date(2024, 3, 17, 0, 0, 0, 0, time.UTC),
+			time.Date(2024, 3, 24, 0, 0, 0, 0, time.UTC)},
+		{"1M", seoul, time.Monday,
+			time.Date(2024, 2, 29, 20, 0, 0, 0, time.UTC),
+			time.Date(2024, 3, 1, 0, 0, 0, 0, seoul),
+			time.Date(2024, 4, 1, 0, 0, 0, 0, seoul)},
+		{"3M", time.UTC, time.Monday,
+			time.Date(2024, 5, 19, 0, 0, 0, 0, time.UTC),
+			time.Date(2024, 4, 1, 0, 0, 0, 0, time.UTC),
+			time.Date(2024, 7, 1, 0, 0, 0, 0, time.UTC)},
+		{"1y", time.UTC, time.Monday,
+			time.Date(2024, 5, 19, 0, 0, 0, 0, time.UTC),
+			time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+			time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)},
+	}
+
+	for _, tt := range tests {
+		step, err := parseTimeStep(tt.interval, tt.location, tt.weekStart)
+		if err != nil {
+			t.Fatalf("parseTimeStep(%s): %v", tt.interval, err)
+		}
+
+		start := step.truncate(tt.time)
+		if !start.Equal(tt.start) {
+			t.Errorf("%s in %s: expected the start [%v] of [%v], but got [%v]", tt.interval, tt.location, tt.start, tt.time, start)
+		}
+
+		if next := step.next(start); !next.Equal(tt.next) {
+			t.Errorf("%s in %s: expected the next [%v] of [%v], but got [%v]", tt.interval, tt.location, tt.next, start, next)
+		}
+	}
+}
+
+func TestTimeStepSQL(t *testing.T) {
+	tests := []struct {
+		interval  string
+		weekStart time.Weekday
+		expected  string
+	}{
+		{"1m", time.Monday, "TIME_SLICE(CONVERT_TIMEZONE('UTC', TO_TIMESTAMP_TZ(ts)), 60, 'SECOND', 'START')"},
+		{"2d", time.Monday, "TIME_SLICE(CONVERT_TIMEZONE('UTC', TO_TIMESTAMP_TZ(ts)), 2, 'DAY', 'START')"},
+		{"1M", time.Monday, "TIME_SLICE(CONVERT_TIMEZONE('UTC', TO_TIMESTAMP_TZ(ts)), 1, 'MONTH', 'START')"},
+		{"1w", time.Sunday, "DATEADD('DAY', -MOD(DATEDIFF('DAY', '1900-01-07'::DATE, CONVERT_TIMEZONE('UTC', TO_TIMESTAMP_TZ(ts))::DATE), 7), " +
+			"DATE_TRUNC('DAY', CONVERT_TIMEZONE('UTC', TO_TIMESTAMP_TZ(ts))))"},
+	}
+
+	for _, tt := range tests {
+		step, err := parseTimeStep(tt.interval, time.UTC, tt.weekStart)
+		if err != nil {
+			t.Fatalf("parseTimeStep(%s): %v", tt.interval, err)
+		}
+
+		if actual := step.sql("ts"); actual != tt.expected {
+			t.Errorf("%s: expected [%s], but got [%s]", tt.interval, tt.expected, actual)
+		}
+	}
+}
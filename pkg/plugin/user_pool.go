@@ -0,0 +1,198 @@
+package plugin
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"strings"
+	"sync"
+	"time"
+
+	sf "github.com/nexon/sunflake/pkg/snowflake"
+	"github.com/nexon/sunflake/pkg/util/er"
+	gs "github.com/snowflakedb/gosnowflake"
+)
+
+// maxUserPools bounds the number of the pools of userPools. The least recently used pool is closed first.
+const maxUserPools = 100
+
+// connection is the DB which a request runs on. user identifies the OAuth identity of the Grafana user
+// in the caches, so that a user is never served what another user queried. It is empty for the shared
+// DB of the datasource.
+type connection struct {
+	db   *sql.DB
+	user string
+	// done is called by release, and is nil for the shared DB of the datasource.
+	done func()
+}
+
+// release ends the use of the connection by the request. The pool of a user is not closed while a request
+// is using it, so every connection from userPools must be released once the request is done with it.
+func (c connection) release() {
+	if c.done != nil {
+		c.done()
+	}
+}
+
+// userPools has a connection pool for each OAuth access token forwarded from Grafana, which logs in to
+// Snowflake external OAuth as the signed-in user. Grafana sends a new token when it refreshes the old one,
+// so a pool which has not been used for the idle timeout of the connections is closed.
+// A pool removed while requests are using it is closed when the last of them releases it.
+// A nil *userPools is valid and means that the datasource uses its shared DB.
+type userPools struct {
+	mu         sync.Mutex
+	config     gs.Config
+	poolConfig *sf.ConnectionPoolConfig
+	idle       time.Duration
+	pools      map[string]*userPool
+	now        func() time.Time
+}
+
+type userPool struct {
+	db       *sql.DB
+	lastUsed time.Time
+	// inUse is the number of the requests using the pool.
+	inUse   int
+	removed bool
+}
+
+func newUserPools(config *gs.Config, poolConfig *sf.ConnectionPoolConfig) *userPools {
+	idle := time.Duration(poolConfig.IdleTimeout) * time.Second
+	if idle <= 0 {
+		idle = time.Duration(sf.DefaultConnPoolConfig.IdleTimeout) * time.Second
+	}
+
+	return &userPools{
+		config:     *config,
+		poolConfig: poolConfig,
+		idle:       idle,
+		pools:      make(map[string]*userPool),
+		now:        time.Now,
+	}
+}
+
+// get returns the connection of the access token in the Authorization header, such as "Bearer eyJ...".
+// It returns an error with er.ErrNoUserToken if the header has no access token.
+func (p *userPools) get(authorization string) (connection, error) {
+	scheme, token, _ := strings.Cut(strings.TrimSpace(authorization), " ")
+	token = strings.TrimSpace(token)
+	if !strings.EqualFold(scheme, "Bearer") || token == "" {
+		return connection{}, er.NewErrorF(er.ErrNoUserToken, "the request has no OAuth access token of the signed-in user")
+	}
+
+	// The caches are keyed by the hash, so that the token itself is kept only by the pool.
+	sum := sha256.Sum256([]byte(token))
+	user := hex.EncodeToString(sum[:])
+
+	p.mu.Lock()
+
+	// Closing a DB waits for its connections, so the removed pools are closed after unlocking.
+	now := p.now()
+	removed := p.removeIdle(now)
+
+	pool, found := p.pools[user]
+	if !found {
+		if len(p.pools) >= maxUserPools {
+			removed = append(removed, p.removeLeastRecentlyUsed()...)
+		}
+
+		pool = &userPool{
+			db: sf.OpenWithToken(&p.config, p.poolConfig, func(context.Context) (string, time.Time, error) {
+				return token, time.Time{}, nil
+			}),
+		}
+		p.pools[user] = pool
+	}
+	pool.lastUsed = now
+	pool.inUse++
+
+	p.mu.Unlock()
+	closePools(removed)
+
+	var once sync.Once
+	done := func() {
+		once.Do(func() { p.release(pool) })
+	}
+	return connection{db: pool.db, user: user, done: done}, nil
+}
+
+// release ends a use of the pool, and closes the pool if it was removed and no request is using it.
+func (p *userPools) release(pool *userPool) {
+	p.mu.Lock()
+	pool.inUse--
+	pool.lastUsed = p.now()
+	closing := pool.removed && pool.inUse == 0
+	p.mu.Unlock()
+
+	if closing {
+		pool.db.Close()
+	}
+}
+
+// remove removes the pool of the user. It returns the pool if it can be closed now, or nil if a request
+// is using it, and the last request closes it in release.
+func (p *userPools) remove(user string) *userPool {
+	pool := p.pools[user]
+	delete(p.pools, user)
+
+	pool.removed = true
+	if pool.inUse > 0 {
+		return nil
+	}
+	return pool
+}
+
+// removeIdle removes the pools which have not been used for the idle timeout, and returns the ones to close.
+// A pool in use is not idle.
+func (p *userPools) removeIdle(now time.Time) []*userPool {
+	var removed []*userPool
+	for user, pool := range p.pools {
+		if pool.inUse == 0 && now.Sub(pool.lastUsed) > p.idle {
+			removed = append(removed, p.remove(user))
+		}
+	}
+	return removed
+}
+
+// removeLeastRecentlyUsed removes the pool which was used the least recently, and returns it if it can be closed.
+func (p *userPools) removeLeastRecentlyUsed() []*userPool {
+	var oldest string
+	for user, pool := range p.pools {
+		if oldest == "" || pool.lastUsed.Before(p.pools[oldest].lastUsed) {
+			oldest = user
+		}
+	}
+
+	if oldest == "" {
+		return nil
+	}
+
+	if pool := p.remove(oldest); pool != nil {
+		return []*userPool{pool}
+	}
+	return nil
+}
+
+func (p *userPools) close() {
+	if p == nil {
+		return
+	}
+
+	p.mu.Lock()
+	removed := make([]*userPool, 0, len(p.pools))
+	for user := range p.pools {
+		if pool := p.remove(user); pool != nil {
+			removed = append(removed, pool)
+		}
+	}
+	p.mu.Unlock()
+
+	closePools(removed)
+}
+
+func closePools(pools []*userPool) {
+	for _, pool := range pools {
+		pool.db.Close()
+	}
+}
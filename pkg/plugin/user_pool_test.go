@@ -0,0 +1,169 @@
+package plugin
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"testing"
+	"time"
+
+	sf "github.com/nexon/sunflake/pkg/snowflake"
+	"github.com/nexon/sunflake/pkg/util/er"
+	gs "github.com/snowflakedb/gosnowflake"
+)
+
+func TestUserPools(t *testing.T) {
+	pools := newUserPools(&gs.Config{Account: "sunflake"}, &sf.ConnectionPoolConfig{IdleTimeout: 180})
+	defer pools.close()
+
+	now := time.Date(2024, 3, 19, 13, 0, 0, 0, time.UTC)
+	pools.now = func() time.Time { return now }
+
+	alice, err := pools.get("Bearer alice-token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	bob, err := pools.get("Bearer bob-token")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if alice.db == bob.db || alice.user == bob.user {
+		t.Error("expected each token to have its own pool and cache key")
+	}
+	alice.release()
+	bob.release()
+
+	now = now.Add(time.Minute)
+	again, err := pools.get("bearer  alice-token ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again.db != alice.db || again.user != alice.user {
+		t.Error("expected the pool of the same token to be reused")
+	}
+	again.release()
+
+	// The pool of bob has not been used for longer than the idle timeout.
+	now = now.Add(150 * time.Second)
+	if _, err := pools.get("Bearer alice-token"); err != nil {
+		t.Fatal(err)
+	}
+	if _, found := pools.pools[bob.user]; found || len(pools.pools) != 1 {
+		t.Errorf("expected the idle pool to be closed, but got %d pools", len(pools.pools))
+	}
+	if !isClosed(bob.db) {
+		t.Error("expected the idle pool to be closed")
+	}
+
+	for _, authorization := range []string{"", "Bearer ", "Basic YWxpY2U6c2VjcmV0"} {
+		if _, err := pools.get(authorization); er.GetCode(err) != er.ErrNoUserToken {
+			t.Errorf("expected ErrNoUserToken from [%s], but got [%v]", authorization, err)
+		}
+	}
+}
+
+func TestUserPoolsMax(t *testing.T) {
+	pools := newUserPools(&gs.Config{Account: "sunflake"}, &sf.DefaultConnPoolConfig)
+	defer pools.close()
+
+	now := time.Date(2024, 3, 19, 13, 0, 0, 0, time.UTC)
+	pools.now = func() time.Time { return now }
+
+	first, err := pools.get("Bearer token-0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	first.release()
+
+	for i := 1; i <= maxUserPools; i++ {
+		now = now.Add(time.Second)
+		conn, err := pools.get(fmt.Sprintf("Bearer token-%d", i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn.release()
+	}
+
+	if len(pools.pools) != maxUserPools {
+		t.Errorf("expected %d pools, but got %d", maxUserPools, len(pools.pools))
+	}
+	if _, found := pools.pools[first.user]; found {
+		t.Error("expected the least recently used pool to be closed")
+	}
+}
+
+func TestUserPoolsInUse(t *testing.T) {
+	pools := newUserPools(&gs.Config{Account: "sunflake"}, &sf.ConnectionPoolConfig{IdleTimeout: 180})
+
+	now := time.Date(2024, 3, 19, 13, 0, 0, 0, time.UTC)
+	pools.now = func() time.Time { return now }
+
+	running, err := pools.get("Bearer alice-token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	released, err := pools.get("Bearer bob-token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	released.release()
+
+	// A pool in use is not idle, however long its request runs.
+	now = now.Add(time.Hour)
+	if _, err := pools.get("Bearer carol-token"); err != nil {
+		t.Fatal(err)
+	}
+	if _, found := pools.pools[running.user]; !found || isClosed(running.db) {
+		t.Error("expected the pool in use to be kept")
+	}
+	if !isClosed(released.db) {
+		t.Error("expected the idle pool to be closed")
+	}
+
+	// A pool removed while it is in use is closed by the last release.
+	again, err := pools.get("Bearer alice-token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pools.close()
+	if isClosed(running.db) {
+		t.Fatal("expected the pool not to be closed while it is in use")
+	}
+
+	running.release()
+	running.release()
+	if isClosed(running.db) {
+		t.Fatal("expected the pool not to be closed while it is in use")
+	}
+	again.release()
+	if !isClosed(running.db) {
+		t.Error("expected the pool to be closed by the last release")
+	}
+}
+
+// isClosed returns whether the DB was closed, without connecting to Snowflake.
+func isClosed(db *sql.DB) bool {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := db.Conn(ctx)
+	return err != nil && err.Error() == "sql: database is closed"
+}
+
+func TestDatasourceConnection(t *testing.T) {
+	shared := Datasource{}
+	if conn, err := shared.connection(""); err != nil || conn.user != "" {
+		t.Errorf("expected the shared DB without a user, but got [%s], [%v]", conn.user, err)
+	}
+
+	forwarding := Datasource{userPools: newUserPools(&gs.Config{Account: "sunflake"}, &sf.DefaultConnPoolConfig)}
+	defer forwarding.userPools.close()
+
+	if _, err := forwarding.connection(""); er.GetCode(err) != er.ErrNoUserToken {
+		t.Errorf("expected ErrNoUserToken, but got [%v]", err)
+	}
+	if conn, err := forwarding.connection("Bearer alice-token"); err != nil || conn.db == nil || conn.user == "" {
+		t.Errorf("expected the pool of the user, but got [%v]", err)
+	}
+}
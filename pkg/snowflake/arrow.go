@@ -0,0 +1,376 @@
+package snowflake
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/apache/arrow/go/v14/arrow"
+	"github.com/apache/arrow/go/v14/arrow/array"
+	gs "github.com/snowflakedb/gosnowflake"
+)
+
+// ArrowColumn is a column of a result fetched in Arrow record batches.
+// Values is one of []*string, []*int64, []*float64, []time.Time, []*bool and []*json.RawMessage,
+// and can be passed to data.NewField as it is. A time column becomes []*time.Time at its first null.
+type ArrowColumn struct {
+	Name         string
+	DatabaseType string
+	Values       interface{}
+	// Precision and Scale are those of a NUMBER column.
+	Precision int64
+	Scale     int64
+}
+
+type ArrowResult struct {
+	Columns []ArrowColumn
+	// Truncated is true when the result had more rows than the limit, and the rest were dropped.
+	Truncated bool
+	RowCount  int
+}
+
+// ArrowNotSupportedError is returned when the result of a query cannot be read from Arrow record batches.
+// The query has been executed, so its result can still be fetched by QueryID instead of running it again.
+type ArrowNotSupportedError struct {
+	QueryID string
+	Reason  string
+}
+
+func (e *ArrowNotSupportedError) Error() string {
+	return fmt.Sprintf("failed to read the result of the query [%s] in arrow: %s", e.QueryID, e.Reason)
+}
+
+// SupportsArrow reports whether the result of the query is returned in Arrow format.
+// Snowflake returns the results of SHOW, DESCRIBE and other commands in JSON format,
+// which cannot be read in Arrow record batches.
+func SupportsArrow(query string) bool {
+	keyword := strings.ToUpper(strings.TrimLeft(query, " \t\r\n("))
+
+	return strings.HasPrefix(keyword, "SELECT") || strings.HasPrefix(keyword, "WITH")
+}
+
+// QueryArrow executes the query and reads its result in Arrow record batches,
+// converting them into column values without scanning every row.
+// If maxRows > 0, it reads at most maxRows rows and marks the result truncated.
+// The args are bound to the bind variables of the query.
+func QueryArrow(ctx context.Context, conn *sql.Conn, query string, maxRows int, args ...any) (*ArrowResult, error) {
+	var rows driver.Rows
+	err := conn.Raw(func(driverConn any) (err error) {
+		queryer, ok := driverConn.(driver.QueryerContext)
+		if !ok {
+			return fmt.Errorf("failed to convert %T to driver.QueryerContext", driverConn)
+		}
+
+		rows, err = queryer.QueryContext(gs.WithArrowBatches(ctx), query, namedValues(args))
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to query [%s]: [%v]", query, err)
+	}
+	defer rows.Close()
+
+	sfRows, ok := rows.(gs.SnowflakeRows)
+	if !ok {
+		return nil, fmt.Errorf("failed to convert %T to gosnowflake.SnowflakeRows", rows)
+	}
+
+	result, err := newArrowResult(rows)
+	if err != nil {
+		return nil, &ArrowNotSupportedError{sfRows.GetQueryID(), err.Error()}
+	}
+
+	batches, err := sfRows.GetArrowBatches()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get arrow batches: [%v]", err)
+	}
+
+	for _, batch := range batches {
+		if result.Truncated {
+			break
+		}
+
+		records, err := batch.WithContext(ctx).Fetch()
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch an arrow batch: [%v]", err)
+		}
+
+		for _, record := range *records {
+			if err == nil && !result.Truncated {
+				err = result.AppendRecord(record, maxRows)
+			}
+			record.Release()
+		}
+		if err != nil {
+			return nil, &ArrowNotSupportedError{sfRows.GetQueryID(), err.Error()}
+		}
+	}
+
+	return result, nil
+}
+
+func newArrowResult(rows driver.Rows) (*ArrowResult, error) {
+	typeNames, ok := rows.(driver.RowsColumnTypeDatabaseTypeName)
+	if !ok {
+		return nil, fmt.Errorf("failed to get the database types of the columns")
+	}
+
+	names := rows.Columns()
+	columns := make([]ArrowColumn, len(names))
+
+	for i, name := range names {
+		dbType := typeNames.ColumnTypeDatabaseTypeName(i)
+
+		var values interface{}
+		var precision, scale int64
+		switch dbType {
+		case "TEXT":
+			values = make([]*string, 0)
+		case "VARIANT", "OBJECT", "ARRAY":
+			values = make([]*json.RawMessage, 0)
+		case "FIXED":
+			// Whether the values are exact in int64 or float64 is checked for each record, see checkFixedArray.
+			precision, scale, _ = columnDecimalSize(rows, i)
+			if scale > 0 {
+				values = make([]*float64, 0)
+			} else {
+				values = make([]*int64, 0)
+			}
+		case "REAL":
+			values = make([]*float64, 0)
+		case "BOOLEAN":
+			values = make([]*bool, 0)
+		case "DATE", "TIME", "TIMESTAMP_NTZ", "TIMESTAMP_LTZ", "TIMESTAMP_TZ":
+			values = make([]time.Time, 0)
+		default:
+			return nil, fmt.Errorf("not supported type [%s] of the column [%s]", dbType, name)
+		}
+
+		columns[i] = ArrowColumn{name, dbType, values, precision, scale}
+	}
+
+	return &ArrowResult{Columns: columns}, nil
+}
+
+func columnDecimalSize(rows driver.Rows, i int) (int64, int64, bool) {
+	precisionScale, ok := rows.(driver.RowsColumnTypePrecisionScale)
+	if !ok {
+		return 0, 0, false
+	}
+
+	return precisionScale.ColumnTypePrecisionScale(i)
+}
+
+// AppendRecord appends the rows of the record to the columns, up to maxRows rows in total if maxRows > 0.
+func (r *ArrowResult) AppendRecord(record arrow.Record, maxRows int) error {
+	n := int(record.NumRows())
+	if maxRows > 0 && r.RowCount+n > maxRows {
+		n = maxRows - r.RowCount
+		r.Truncated = true
+	}
+
+	for i := range r.Columns {
+		values, err := appendArrowValues(r.Columns[i], record.Column(i), n)
+		if err != nil {
+			return fmt.Errorf("failed to read the column [%s]: %v", r.Columns[i].Name, err)
+		}
+		r.Columns[i].Values = values
+	}
+
+	r.RowCount += n
+	return nil
+}
+
+// appendArrowValues appends the first n values of the array to the values of the column.
+func appendArrowValues(c ArrowColumn, arr arrow.Array, n int) (interface{}, error) {
+	if err := checkFixedArray(c, arr); err != nil {
+		return nil, err
+	}
+
+	switch s := c.Values.(type) {
+	case []*string:
+		a, ok := arr.(*array.String)
+		if !ok {
+			return nil, fmt.Errorf("not supported arrow type [%s] for string", arr.DataType())
+		}
+		for i := 0; i < n; i++ {
+			if a.IsNull(i) {
+				s = append(s, nil)
+			} else {
+				v := a.Value(i)
+				s = append(s, &v)
+			}
+		}
+		return s, nil
+	case []*json.RawMessage:
+		a, ok := arr.(*array.String)
+		if !ok {
+			return nil, fmt.Errorf("not supported arrow type [%s] for json", arr.DataType())
+		}
+		for i := 0; i < n; i++ {
+			if a.IsNull(i) {
+				s = append(s, nil)
+			} else {
+				v := json.RawMessage(a.Value(i))
+				s = append(s, &v)
+			}
+		}
+		return s, nil
+	case []*int64:
+		for i := 0; i < n; i++ {
+			if arr.IsNull(i) {
+				s = append(s, nil)
+				continue
+			}
+
+			var v int64
+			switch a := arr.(type) {
+			case *array.Int64:
+				v = a.Value(i)
+			case *array.Int32:
+				v = int64(a.Value(i))
+			case *array.Int16:
+				v = int64(a.Value(i))
+			case *array.Int8:
+				v = int64(a.Value(i))
+			default:
+				return nil, fmt.Errorf("not supported arrow type [%s] for int64", arr.DataType())
+			}
+			s = append(s, &v)
+		}
+		return s, nil
+	case []*float64:
+		for i := 0; i < n; i++ {
+			if arr.IsNull(i) {
+				s = append(s, nil)
+				continue
+			}
+
+			a, ok := arr.(*array.Float64)
+			if !ok {
+				return nil, fmt.Errorf("not supported arrow type [%s] for float64", arr.DataType())
+			}
+			v := a.Value(i)
+			s = append(s, &v)
+		}
+		return s, nil
+	case []*bool:
+		a, ok := arr.(*array.Boolean)
+		if !ok {
+			return nil, fmt.Errorf("not supported arrow type [%s] for bool", arr.DataType())
+		}
+		for i := 0; i < n; i++ {
+			if a.IsNull(i) {
+				s = append(s, nil)
+			} else {
+				v := a.Value(i)
+				s = append(s, &v)
+			}
+		}
+		return s, nil
+	case []time.Time:
+		if arr.NullN() > 0 {
+			c.Values = NullableTimes(s)
+			return appendArrowValues(c, arr, n)
+		}
+		for i := 0; i < n; i++ {
+			v, err := arrowTime(arr, i)
+			if err != nil {
+				return nil, err
+			}
+			s = append(s, v)
+		}
+		return s, nil
+	case []*time.Time:
+		for i := 0; i < n; i++ {
+			if arr.IsNull(i) {
+				s = append(s, nil)
+				continue
+			}
+
+			v, err := arrowTime(arr, i)
+			if err != nil {
+				return nil, err
+			}
+			s = append(s, &v)
+		}
+		return s, nil
+	}
+
+	return nil, fmt.Errorf("not supported values type [%T]", c.Values)
+}
+
+func arrowTime(arr arrow.Array, i int) (time.Time, error) {
+	switch a := arr.(type) {
+	case *array.Timestamp:
+		unit := a.DataType().(*arrow.TimestampType).Unit
+		return a.Value(i).ToTime(unit), nil
+	case *array.Date32:
+		return a.Value(i).ToTime(), nil
+	case *array.Time64:
+		unit := a.DataType().(*arrow.Time64Type).Unit
+		return a.Value(i).ToTime(unit), nil
+	}
+
+	return time.Time{}, fmt.Errorf("not supported arrow type [%s] for time", arr.DataType())
+}
+
+// NullableTimes converts the values of a time column to pointers, so that a null can be appended.
+func NullableTimes(times []time.Time) []*time.Time {
+	values := make([]*time.Time, len(times))
+	for i := range times {
+		values[i] = &times[i]
+	}
+	return values
+}
+
+// checkFixedArray returns an error if the array of a NUMBER column may not have the exact values.
+//
+// Snowflake sends the values of a record batch in the narrowest integer type which holds them, or Decimal128
+// if they do not fit in int64, and gosnowflake casts Decimal128 to int64 without checking overflow. So an
+// int64 array of a column wider than int64 may have wrapped around, which its values cannot tell, while
+// narrower integers are always exact.
+//
+// gosnowflake divides the integers of a decimal by the power of ten of its scale into float64. While an
+// integer has at most MaxFloat64Precision digits, the quotient is the float64 nearest the decimal, the same
+// as a row read row by row, so the values of a wider column are checked one by one.
+func checkFixedArray(c ArrowColumn, arr arrow.Array) error {
+	if c.DatabaseType != "FIXED" || IsExactInFloat64(c.Precision, c.Scale) {
+		return nil
+	}
+
+	switch a := arr.(type) {
+	case *array.Int64:
+		return fmt.Errorf("the int64 values of NUMBER(%d,%d) may have overflowed", c.Precision, c.Scale)
+	case *array.Float64:
+		// A power of ten above 10^22 is not exact in float64, so neither is the quotient.
+		if c.Scale > maxExactPow10 {
+			return fmt.Errorf("the float64 values of NUMBER(%d,%d) may have lost precision", c.Precision, c.Scale)
+		}
+
+		limit := math.Pow10(MaxFloat64Precision - int(c.Scale))
+		for i := 0; i < a.Len(); i++ {
+			if !a.IsNull(i) && math.Abs(a.Value(i)) >= limit {
+				return fmt.Errorf("the value %v of NUMBER(%d,%d) may have lost precision in float64", a.Value(i), c.Precision, c.Scale)
+			}
+		}
+	}
+
+	return nil
+}
+
+// maxExactPow10 is the largest exponent of ten whose power is exact in float64.
+const maxExactPow10 = 22
+
+// namedValues converts the arguments of a query to the bind variables of the driver.
+func namedValues(args []any) []driver.NamedValue {
+	values := make([]driver.NamedValue, len(args))
+	for i, arg := range args {
+		values[i] = driver.NamedValue{Ordinal: i + 1, Value: arg}
+	}
+	return values
+}
@@ -0,0 +1,433 @@
+package snowflake
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/apache/arrow/go/v14/arrow"
+	"github.com/apache/arrow/go/v14/arrow/array"
+	"github.com/apache/arrow/go/v14/arrow/decimal128"
+	"github.com/apache/arrow/go/v14/arrow/ipc"
+	"github.com/apache/arrow/go/v14/arrow/memory"
+	gs "github.com/snowflakedb/gosnowflake"
+)
+
+func TestAppendFixedValues(t *testing.T) {
+	alloc := memory.NewGoAllocator()
+
+	int8s := array.NewInt8Builder(alloc)
+	int8s.AppendValues([]int8{1, -2}, nil)
+	int64s := array.NewInt64Builder(alloc)
+	int64s.AppendValues([]int64{1, 1 << 40}, nil)
+	float64s := array.NewFloat64Builder(alloc)
+	float64s.AppendValues([]float64{1.5, 2.25}, nil)
+	exactFloat64s := array.NewFloat64Builder(alloc)
+	exactFloat64s.AppendValues([]float64{-9999999999999.99, 0, 12345.67}, []bool{true, false, true})
+	inexactFloat64s := array.NewFloat64Builder(alloc)
+	inexactFloat64s.AppendValues([]float64{1, 10000000000000}, nil)
+
+	tests := []struct {
+		precision int64
+		scale     int64
+		arr       arrow.Array
+		expected  string
+		err       string
+	}{
+		{38, 0, int8s.NewArray(), "1 -2", ""},
+		{18, 0, int64s.NewArray(), "1 1099511627776", ""},
+		// gosnowflake may have cast a Decimal128 to these int64 values.
+		{38, 0, int64s.NewArray(), "", "may have overflowed"},
+		{10, 2, float64s.NewArray(), "1.5 2.25", ""},
+		// The decimals have at most 15 digits, so they are exact.
+		{38, 2, exactFloat64s.NewArray(), "-9.99999999999999e+12 <nil> 12345.67", ""},
+		{38, 2, inexactFloat64s.NewArray(), "", "may have lost precision"},
+		{38, 23, float64s.NewArray(), "", "may have lost precision"},
+	}
+
+	for _, tt := range tests {
+		c := ArrowColumn{Name: "n", DatabaseType: "FIXED", Precision: tt.precision, Scale: tt.scale}
+		if tt.scale > 0 {
+			c.Values = make([]*float64, 0)
+		} else {
+			c.Values = make([]*int64, 0)
+		}
+
+		values, err := appendArrowValues(c, tt.arr, tt.arr.Len())
+		tt.arr.Release()
+
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("expected an error with [%s] from NUMBER(%d,%d), but got [%v]", tt.err, tt.precision, tt.scale, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("failed to append the values of NUMBER(%d,%d): %v", tt.precision, tt.scale, err)
+			continue
+		}
+		if actual := formatValues(values); actual != tt.expected {
+			t.Errorf("expected [%s] from NUMBER(%d,%d), but got [%s]", tt.expected, tt.precision, tt.scale, actual)
+		}
+	}
+}
+
+// formatValues formats the values of a column separated by spaces, with <nil> for null.
+func formatValues(values interface{}) string {
+	var s []string
+	switch vs := values.(type) {
+	case []*int64:
+		for _, v := range vs {
+			s = append(s, formatPointer(v))
+		}
+	case []*float64:
+		for _, v := range vs {
+			s = append(s, formatPointer(v))
+		}
+	case []*string:
+		for _, v := range vs {
+			s = append(s, formatPointer(v))
+		}
+	case []*bool:
+		for _, v := range vs {
+			s = append(s, formatPointer(v))
+		}
+	}
+	return strings.Join(s, " ")
+}
+
+func formatPointer[T any](v *T) string {
+	if v == nil {
+		return "<nil>"
+	}
+	return fmt.Sprint(*v)
+}
+
+func newTestRecord(offset int, nulls bool) arrow.Record {
+	schema := arrow.NewSchema([]arrow.Field{
+		{Name: "ts", Type: &arrow.TimestampType{Unit: arrow.Millisecond}, Nullable: true},
+		{Name: "d", Type: arrow.FixedWidthTypes.Date32, Nullable: true},
+		{Name: "name", Type: arrow.BinaryTypes.String, Nullable: true},
+		{Name: "count", Type: arrow.PrimitiveTypes.Int16, Nullable: true},
+		{Name: "size", Type: arrow.PrimitiveTypes.Float64, Nullable: true},
+		{Name: "ok", Type: arrow.FixedWidthTypes.Boolean, Nullable: true},
+		{Name: "v", Type: arrow.BinaryTypes.String, Nullable: true},
+	}, nil)
+
+	b := array.NewRecordBuilder(memory.NewGoAllocator(), schema)
+	defer b.Release()
+
+	for i := offset; i < offset+2; i++ {
+		if nulls && i%2 == 1 {
+			for _, f := range b.Fields() {
+				f.AppendNull()
+			}
+			continue
+		}
+
+		start := time.Date(2024, 3, 19, 13, 0, i, 0, time.UTC)
+		b.Field(0).(*array.TimestampBuilder).Append(arrow.Timestamp(start.UnixMilli()))
+		b.Field(1).(*array.Date32Builder).Append(arrow.Date32FromTime(start))
+		b.Field(2).(*array.StringBuilder).Append(fmt.Sprintf("name-%d", i))
+		b.Field(3).(*array.Int16Builder).Append(int16(i))
+		b.Field(4).(*array.Float64Builder).Append(float64(i) / 2)
+		b.Field(5).(*array.BooleanBuilder).Append(i%2 == 0)
+		b.Field(6).(*array.StringBuilder).Append(fmt.Sprintf(`{"i":%d}`, i))
+	}
+
+	return b.NewRecord()
+}
+
+func newTestResult() *ArrowResult {
+	return &ArrowResult{Columns: []ArrowColumn{
+		{Name: "ts", DatabaseType: "TIMESTAMP_NTZ", Values: make([]time.Time, 0)},
+		{Name: "d", DatabaseType: "DATE", Values: make([]time.Time, 0)},
+		{Name: "name", DatabaseType: "TEXT", Values: make([]*string, 0)},
+		{Name: "count", DatabaseType: "FIXED", Values: make([]*int64, 0), Precision: 38},
+		{Name: "size", DatabaseType: "REAL", Values: make([]*float64, 0)},
+		{Name: "ok", DatabaseType: "BOOLEAN", Values: make([]*bool, 0)},
+		{Name: "v", DatabaseType: "VARIANT", Values: make([]*json.RawMessage, 0)},
+	}}
+}
+
+func TestAppendRecord(t *testing.T) {
+	tests := []struct {
+		nulls     bool
+		maxRows   int
+		rowCount  int
+		truncated bool
+	}{
+		{false, 0, 4, false},
+		{false, 3, 3, true},
+		{false, 4, 4, false},
+		{true, 0, 4, false},
+	}
+
+	for _, tt := range tests {
+		result := newTestResult()
+		for offset := 0; offset < 4; offset += 2 {
+			if result.Truncated {
+				break
+			}
+			record := newTestRecord(offset, tt.nulls)
+			err := result.AppendRecord(record, tt.maxRows)
+			record.Release()
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		if result.RowCount != tt.rowCount || result.Truncated != tt.truncated {
+			t.Errorf("expected %d rows and truncated %t with maxRows %d, but got %d and %t", tt.rowCount, tt.truncated, tt.maxRows, result.RowCount, result.Truncated)
+		}
+
+		for _, c := range result.Columns {
+			if n := reflect.ValueOf(c.Values).Len(); n != tt.rowCount {
+				t.Errorf("expected %d values of [%s], but got %d", tt.rowCount, c.Name, n)
+			}
+		}
+
+		if !tt.nulls {
+			times := result.Columns[0].Values.([]time.Time)
+			if expected := time.Date(2024, 3, 19, 13, 0, 2, 0, time.UTC); !times[2].Equal(expected) {
+				t.Errorf("expected [%v], but got [%v]", expected, times[2])
+			}
+			if dates := result.Columns[1].Values.([]time.Time); !dates[0].Equal(time.Date(2024, 3, 19, 0, 0, 0, 0, time.UTC)) {
+				t.Errorf("expected the date of [%v], but got [%v]", times[0], dates[0])
+			}
+			if actual := formatValues(result.Columns[2].Values); !strings.HasPrefix(actual, "name-0 name-1 name-2") {
+				t.Errorf("unexpected strings [%s]", actual)
+			}
+			if actual := formatValues(result.Columns[3].Values); !strings.HasPrefix(actual, "0 1 2") {
+				t.Errorf("unexpected integers [%s]", actual)
+			}
+			if actual := formatValues(result.Columns[4].Values); !strings.HasPrefix(actual, "0 0.5 1") {
+				t.Errorf("unexpected floats [%s]", actual)
+			}
+			if actual := formatValues(result.Columns[5].Values); !strings.HasPrefix(actual, "true false true") {
+				t.Errorf("unexpected booleans [%s]", actual)
+			}
+			if v := result.Columns[6].Values.([]*json.RawMessage); string(*v[1]) != `{"i":1}` {
+				t.Errorf("unexpected JSON [%s]", *v[1])
+			}
+			continue
+		}
+
+		// A time column with a null becomes nullable, keeping the times before the null.
+		times, ok := result.Columns[0].Values.([]*time.Time)
+		if !ok {
+			t.Fatalf("expected nullable times, but got %T", result.Columns[0].Values)
+		}
+		if times[0] == nil || times[1] != nil || times[2] == nil || times[3] != nil {
+			t.Errorf("expected a null in every other row, but got %v", times)
+		}
+		if actual := formatValues(result.Columns[3].Values); actual != "0 <nil> 2 <nil>" {
+			t.Errorf("unexpected integers with nulls [%s]", actual)
+		}
+		if actual := formatValues(result.Columns[2].Values); actual != "name-0 <nil> name-2 <nil>" {
+			t.Errorf("unexpected strings with nulls [%s]", actual)
+		}
+	}
+}
+
+func TestAppendRecordNotSupported(t *testing.T) {
+	record := newTestRecord(0, false)
+	defer record.Release()
+
+	// The name column is read as a number, which the arrow array does not hold.
+	result := newTestResult()
+	result.Columns[2].Values = make([]*int64, 0)
+
+	err := result.AppendRecord(record, 0)
+	if err == nil || !strings.Contains(err.Error(), "failed to read the column [name]") {
+		t.Errorf("expected an error of the column, but got [%v]", err)
+	}
+}
+
+// fakeRows is driver.Rows with the database types of its columns.
+type fakeRows struct {
+	names []string
+	types []string
+}
+
+func (r *fakeRows) Columns() []string                       { return r.names }
+func (r *fakeRows) Close() error                            { return nil }
+func (r *fakeRows) Next([]driver.Value) error               { return io.EOF }
+func (r *fakeRows) ColumnTypeDatabaseTypeName(i int) string { return r.types[i] }
+
+func (r *fakeRows) ColumnTypePrecisionScale(i int) (int64, int64, bool) {
+	if r.types[i] == "FIXED" {
+		return 38, 2, true
+	}
+	return 0, 0, false
+}
+
+func TestNewArrowResult(t *testing.T) {
+	result, err := newArrowResult(&fakeRows{
+		names: []string{"n", "t", "ts"},
+		types: []string{"FIXED", "TEXT", "TIMESTAMP_LTZ"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A NUMBER column is read in arrow whatever its precision, and its values are checked for each record.
+	n := result.Columns[0]
+	if _, ok := n.Values.([]*float64); !ok || n.Precision != 38 || n.Scale != 2 {
+		t.Errorf("expected float64 values of NUMBER(38,2), but got %T of NUMBER(%d,%d)", n.Values, n.Precision, n.Scale)
+	}
+
+	if _, err := newArrowResult(&fakeRows{names: []string{"g"}, types: []string{"GEOGRAPHY"}}); err == nil {
+		t.Error("expected an error from a type which is not read in arrow")
+	}
+
+	var notSupported *ArrowNotSupportedError
+	err = fmt.Errorf("failed to query: %w", &ArrowNotSupportedError{"01b2", "not supported type"})
+	if !errors.As(err, &notSupported) || notSupported.QueryID != "01b2" {
+		t.Errorf("expected the query ID to fetch the result again, but got [%v]", err)
+	}
+}
+
+// fakeSnowflake serves the login and the queries of gosnowflake. A query returns the record as the
+// first chunk of its result, in the Arrow types Snowflake sends, with the row type of a NUMBER(38,scale) column.
+func fakeSnowflake(t *testing.T, record arrow.Record, scale int64) *sql.DB {
+	var rowset bytes.Buffer
+	w := ipc.NewWriter(&rowset, ipc.WithSchema(record.Schema()))
+	if err := w.Write(record); err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		var data any = map[string]any{}
+		switch req.URL.Path {
+		case "/session/v1/login-request":
+			data = map[string]any{"token": "token", "masterToken": "master", "sessionId": 1}
+		case "/queries/v1/query-request":
+			data = map[string]any{
+				"queryId":           "01b2c3d4-0000-0000-0000-000000000001",
+				"queryResultFormat": "arrow",
+				"statementTypeId":   4096,
+				"total":             record.NumRows(),
+				"returned":          record.NumRows(),
+				"rowtype":           []map[string]any{{"name": "N", "type": "fixed", "precision": 38, "scale": scale, "nullable": true}},
+				"rowsetBase64":      base64.StdEncoding.EncodeToString(rowset.Bytes()),
+			}
+		}
+		json.NewEncoder(rw).Encode(map[string]any{"success": true, "data": data})
+	}))
+	t.Cleanup(server.Close)
+
+	u, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	port, err := strconv.Atoi(u.Port())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	connector := gs.NewConnector(gs.SnowflakeDriver{}, gs.Config{
+		Account:          "test",
+		User:             "user",
+		Password:         "password",
+		Protocol:         u.Scheme,
+		Host:             u.Hostname(),
+		Port:             port,
+		DisableTelemetry: true,
+	})
+	db := sql.OpenDB(connector)
+	t.Cleanup(func() { db.Close() })
+
+	return db
+}
+
+// TestQueryArrowFixed reads NUMBER columns as gosnowflake converts the Arrow types which Snowflake sends.
+func TestQueryArrowFixed(t *testing.T) {
+	alloc := memory.NewGoAllocator()
+
+	newRecord := func(dataType arrow.DataType, appendValues func(array.Builder)) arrow.Record {
+		b := array.NewRecordBuilder(alloc, arrow.NewSchema([]arrow.Field{{Name: "N", Type: dataType, Nullable: true}}, nil))
+		defer b.Release()
+		appendValues(b.Field(0))
+		return b.NewRecord()
+	}
+	decimals := func(scale int32, values ...string) arrow.Record {
+		return newRecord(&arrow.Decimal128Type{Precision: 38, Scale: scale}, func(b array.Builder) {
+			for _, v := range values {
+				n, err := decimal128.FromString(v, 38, scale)
+				if err != nil {
+					t.Fatal(err)
+				}
+				b.(*array.Decimal128Builder).Append(n)
+			}
+		})
+	}
+	int32s := newRecord(arrow.PrimitiveTypes.Int32, func(b array.Builder) {
+		b.(*array.Int32Builder).AppendValues([]int32{1, -2}, []bool{true, true})
+		b.AppendNull()
+	})
+	int64s := func(values ...int64) arrow.Record {
+		return newRecord(arrow.PrimitiveTypes.Int64, func(b array.Builder) {
+			b.(*array.Int64Builder).AppendValues(values, nil)
+		})
+	}
+
+	tests := []struct {
+		name     string
+		record   arrow.Record
+		scale    int64
+		expected string
+		err      string
+	}{
+		{"int32", int32s, 0, "1 -2 <nil>", ""},
+		// The int64 values may have been cast from Decimal128, which overflows.
+		{"int64", int64s(1, 1<<40), 0, "", "may have overflowed"},
+		{"decimal", decimals(0, "1", "92233720368547758070"), 0, "", "may have overflowed"},
+		// The integers of the decimals are divided into float64.
+		{"int64 of a decimal", int64s(12345, -1, 999999999999999), 2, "123.45 -0.01 9.99999999999999e+12", ""},
+		{"large int64 of a decimal", int64s(1, 1000000000000000), 2, "", "may have lost precision"},
+		{"decimal of a decimal", decimals(2, "1.23", "922337203685477580.70"), 2, "", "may have lost precision"},
+	}
+
+	for _, tt := range tests {
+		db := fakeSnowflake(t, tt.record, tt.scale)
+		tt.record.Release()
+
+		conn, err := db.Conn(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		result, err := QueryArrow(context.Background(), conn, "SELECT n FROM t", 0)
+		conn.Close()
+
+		if tt.err != "" {
+			var notSupported *ArrowNotSupportedError
+			if !errors.As(err, &notSupported) || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("%s: expected the result to be read row by row for [%s], but got [%v]", tt.name, tt.err, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if actual := formatValues(result.Columns[0].Values); actual != tt.expected {
+			t.Errorf("%s: expected [%s], but got [%s]", tt.name, tt.expected, actual)
+		}
+	}
+}
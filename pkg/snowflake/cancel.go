@@ -0,0 +1,70 @@
+package snowflake
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	gs "github.com/snowflakedb/gosnowflake"
+
+	"github.com/nexon/sunflake/pkg/util/log"
+)
+
+const cancelTimeout = 10 * time.Second
+
+// CancelQuery aborts the running query with the query ID on the server.
+func CancelQuery(db *sql.DB, queryID string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), cancelTimeout)
+	defer cancel()
+
+	_, err := db.ExecContext(ctx, "SELECT SYSTEM$CANCEL_QUERY(?)", queryID)
+	return err
+}
+
+// RunWithCancel runs one statement, and aborts it on the server if ctx is done before run returns,
+// so that it does not keep running in the warehouse while its rows are no longer read.
+//
+// run must post exactly one statement on the context it is given, and may read its result there.
+// gosnowflake sends the query ID of the first statement posted on the context and closes the channel,
+// so the statements before or after it, such as of the session, must run on ctx instead.
+func RunWithCancel(ctx context.Context, db *sql.DB, run func(ctx context.Context) error) error {
+	// gosnowflake sends the query ID as soon as the query is submitted, and does not wait for a receiver.
+	queryIDs := make(chan string, 1)
+	finished := make(chan struct{})
+	defer close(finished)
+
+	go func() {
+		var queryID string
+		select {
+		case id, ok := <-queryIDs:
+			if !ok {
+				return
+			}
+			queryID = id
+		case <-finished:
+			return
+		}
+
+		select {
+		case <-ctx.Done():
+			log.Info("cancel the query:", queryID)
+			if err := CancelQuery(db, queryID); err != nil {
+				log.Error("failed to cancel the query:", queryID, err)
+			}
+		case <-finished:
+		}
+	}()
+
+	return run(gs.WithQueryIDChan(ctx, queryIDs))
+}
+
+// ErrCodeStatementTimeout is the error code of Snowflake when a statement reached
+// STATEMENT_TIMEOUT_IN_SECONDS and was cancelled.
+const ErrCodeStatementTimeout = 630
+
+// IsStatementTimeout reports whether err is from a statement cancelled by STATEMENT_TIMEOUT_IN_SECONDS.
+func IsStatementTimeout(err error) bool {
+	var se *gs.SnowflakeError
+	return errors.As(err, &se) && se.Number == ErrCodeStatementTimeout
+}
@@ -1,7 +1,9 @@
 package snowflake
 
 import (
+	"context"
 	"database/sql"
+	"database/sql/driver"
 	"fmt"
 	"time"
 
@@ -39,6 +41,98 @@ func Open(config *gs.Config, poolConfig *ConnectionPoolConfig) (*sql.DB, error)
 		return nil, fmt.Errorf("failed to connect to Snowflake: [%v]", err)
 	}
 
+	setConnectionPool(db, poolConfig)
+
+	return db, nil
+}
+
+// TokenFunc returns an OAuth access token, and the time after which a connection logged in with it
+// must not be reused. The time is zero if the token does not expire.
+type TokenFunc func(ctx context.Context) (token string, refreshAt time.Time, err error)
+
+// OpenWithToken opens a DB which logs in with the OAuth access token returned by token.
+// The token is got for every new connection, so a refreshed token is used without opening
+// the DB again. A connection is not reused after the refreshAt of its token, and the next
+// query opens a new connection, which gets the refreshed token.
+func OpenWithToken(config *gs.Config, poolConfig *ConnectionPoolConfig, token TokenFunc) *sql.DB {
+	db := sql.OpenDB(&tokenConnector{config: *config, token: token, now: time.Now})
+	setConnectionPool(db, poolConfig)
+
+	return db
+}
+
+type tokenConnector struct {
+	config gs.Config
+	token  TokenFunc
+	now    func() time.Time
+}
+
+func (c *tokenConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	token, refreshAt, err := c.token(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get an OAuth access token: [%v]", err)
+	}
+
+	cfg := c.config
+	cfg.Authenticator = gs.AuthTypeOAuth
+	cfg.Token = token
+
+	// The driver writes the session parameters to Params, so each connection has its own.
+	cfg.Params = make(map[string]*string, len(c.config.Params))
+	for k, v := range c.config.Params {
+		value := *v
+		cfg.Params[k] = &value
+	}
+
+	conn, err := gs.NewConnector(gs.SnowflakeDriver{}, cfg).Connect(ctx)
+	if err != nil || refreshAt.IsZero() {
+		return conn, err
+	}
+
+	sc, ok := conn.(snowflakeConn)
+	if !ok {
+		return conn, nil
+	}
+	return &tokenConn{snowflakeConn: sc, refreshAt: refreshAt, now: c.now}, nil
+}
+
+// snowflakeConn is the connection of gosnowflake, with the interfaces which database/sql uses.
+type snowflakeConn interface {
+	driver.Conn
+	driver.ConnBeginTx
+	driver.ConnPrepareContext
+	driver.ExecerContext
+	driver.QueryerContext
+	driver.Pinger
+	driver.NamedValueChecker
+}
+
+// tokenConn is a connection logged in with an OAuth access token, which database/sql discards instead of
+// reusing it after refreshAt of the token.
+type tokenConn struct {
+	snowflakeConn
+	refreshAt time.Time
+	now       func() time.Time
+}
+
+// ResetSession is called before the connection is reused from the pool.
+func (c *tokenConn) ResetSession(context.Context) error {
+	if !c.IsValid() {
+		return driver.ErrBadConn
+	}
+	return nil
+}
+
+// IsValid is called before the connection is returned to the pool.
+func (c *tokenConn) IsValid() bool {
+	return c.now().Before(c.refreshAt)
+}
+
+func (c *tokenConnector) Driver() driver.Driver {
+	return gs.SnowflakeDriver{}
+}
+
+func setConnectionPool(db *sql.DB, poolConfig *ConnectionPoolConfig) {
 	// SetConnMaxIdleTime sets the maximum amount of time a connection may be idle.
 	// Expired connections may be closed lazily before reuse.
 	// If d <= 0, connections are not closed due to a connection's idle time.
@@ -61,6 +155,4 @@ func Open(config *gs.Config, poolConfig *ConnectionPoolConfig) (*sql.DB, error)
 	// If n <= 0, then there is no limit on the number of open connections.
 	// The default is 0 (unlimited).
 	db.SetMaxOpenConns(poolConfig.MaxOpen)
-
-	return db, nil
 }
@@ -0,0 +1,105 @@
+package snowflake
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	gs "github.com/snowflakedb/gosnowflake"
+)
+
+// TestOpenWithTokenRefresh opens a new connection with a new token once the token of a connection
+// is to be refreshed, whether the connection was open or idle in the pool at the time.
+func TestOpenWithTokenRefresh(t *testing.T) {
+	var mu sync.Mutex
+	var logins []string
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		var data any = map[string]any{}
+		if req.URL.Path == "/session/v1/login-request" {
+			var body struct {
+				Data struct {
+					Token string `json:"TOKEN"`
+				} `json:"data"`
+			}
+			json.NewDecoder(req.Body).Decode(&body)
+
+			mu.Lock()
+			logins = append(logins, body.Data.Token)
+			mu.Unlock()
+			data = map[string]any{"token": "token", "masterToken": "master", "sessionId": 1}
+		}
+		json.NewEncoder(rw).Encode(map[string]any{"success": true, "data": data})
+	}))
+	defer server.Close()
+
+	u, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	port, err := strconv.Atoi(u.Port())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Date(2024, 3, 19, 13, 0, 0, 0, time.UTC)
+	var tokens int
+	token := func(context.Context) (string, time.Time, error) {
+		tokens++
+		return fmt.Sprintf("token-%d", tokens), now.Add(time.Hour), nil
+	}
+
+	config := gs.Config{Account: "test", Protocol: u.Scheme, Host: u.Hostname(), Port: port, DisableTelemetry: true}
+	db := sql.OpenDB(&tokenConnector{config: config, token: token, now: func() time.Time { return now }})
+	defer db.Close()
+
+	ctx := context.Background()
+	expectLogins := func(expected ...string) {
+		t.Helper()
+		mu.Lock()
+		defer mu.Unlock()
+		if fmt.Sprint(logins) != fmt.Sprint(expected) {
+			t.Errorf("expected the logins with %v, but got %v", expected, logins)
+		}
+	}
+
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+
+	// The connection is reused while its token is valid.
+	conn, err = db.Conn(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectLogins("token-1")
+
+	// The token expires while the connection is open, so the connection is not returned to the pool.
+	now = now.Add(time.Hour)
+	conn.Close()
+
+	conn, err = db.Conn(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+	expectLogins("token-1", "token-2")
+
+	// The token expires while the connection is idle in the pool, so the connection is not reused.
+	now = now.Add(time.Hour)
+	conn, err = db.Conn(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+	expectLogins("token-1", "token-2", "token-3")
+}
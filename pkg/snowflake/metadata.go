@@ -0,0 +1,140 @@
+package snowflake
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+type Column struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+}
+
+// QuoteIdentifier quotes an object name so that it is used as-is,
+// preserving its case and any special characters.
+func QuoteIdentifier(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
+
+// QuoteLiteral quotes a string so that it can be embedded in a SQL statement as a string constant.
+func QuoteLiteral(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	s = strings.ReplaceAll(s, `'`, `''`)
+	return "'" + s + "'"
+}
+
+// likeEscaper escapes the wildcards of LIKE with a backslash, which is the escape character
+// of SHOW ... LIKE, and of the queries with ESCAPE '\\'.
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// containsPattern is the LIKE pattern of the names containing search, so that % and _ in search
+// match themselves.
+func containsPattern(search string) string {
+	return "%" + likeEscaper.Replace(strings.TrimSpace(search)) + "%"
+}
+
+func ShowDatabases(ctx context.Context, db *sql.DB, search string) ([]string, error) {
+	query := "SHOW TERSE DATABASES"
+	if strings.TrimSpace(search) != "" {
+		query += " LIKE " + QuoteLiteral(containsPattern(search))
+	}
+
+	return queryNames(ctx, db, query, "name")
+}
+
+func ShowSchemas(ctx context.Context, db *sql.DB, database string, search string) ([]string, error) {
+	query := "SHOW TERSE SCHEMAS IN DATABASE " + QuoteIdentifier(database)
+	if strings.TrimSpace(search) != "" {
+		query += " LIKE " + QuoteLiteral(containsPattern(search))
+	}
+
+	return queryNames(ctx, db, query, "name")
+}
+
+func ListTables(ctx context.Context, db *sql.DB, database string, schema string, search string) ([]string, error) {
+	query := fmt.Sprintf(
+		"SELECT table_name FROM %s.information_schema.tables WHERE table_schema = ? AND table_name ILIKE ? ESCAPE '\\\\' ORDER BY table_name",
+		QuoteIdentifier(database),
+	)
+
+	return queryNames(ctx, db, query, "table_name", schema, containsPattern(search))
+}
+
+func ListColumns(ctx context.Context, db *sql.DB, database string, schema string, table string, search string) ([]Column, error) {
+	query := fmt.Sprintf(
+		"SELECT column_name, data_type FROM %s.information_schema.columns WHERE table_schema = ? AND table_name = ? AND column_name ILIKE ? ESCAPE '\\\\' ORDER BY column_name",
+		QuoteIdentifier(database),
+	)
+
+	rows, err := db.QueryContext(ctx, query, schema, table, containsPattern(search))
+	if err != nil {
+		return nil, fmt.Errorf("failed to query [%s]: [%v]", query, err)
+	}
+	defer rows.Close()
+
+	columns := make([]Column, 0)
+	for rows.Next() {
+		var c Column
+		if err := rows.Scan(&c.Name, &c.Type); err != nil {
+			return nil, fmt.Errorf("failed to get result: [%v]", err)
+		}
+		columns = append(columns, c)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to scan rows: [%v]", err)
+	}
+
+	return columns, nil
+}
+
+// queryNames runs the query and collects the values of the named column.
+// SHOW commands return a fixed set of columns, so the column is looked up by name
+// instead of selecting it.
+func queryNames(ctx context.Context, db *sql.DB, query string, column string, args ...any) ([]string, error) {
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query [%s]: [%v]", query, err)
+	}
+	defer rows.Close()
+
+	cols, err := rows.Columns()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get columns: [%v]", err)
+	}
+
+	idx := -1
+	for i, c := range cols {
+		if strings.EqualFold(c, column) {
+			idx = i
+			break
+		}
+	}
+	if idx < 0 {
+		return nil, fmt.Errorf("failed to find the column [%s] in %v", column, cols)
+	}
+
+	scanValues := make([]any, len(cols))
+	for i := range scanValues {
+		scanValues[i] = new(sql.NullString)
+	}
+
+	names := make([]string, 0)
+	for rows.Next() {
+		if err := rows.Scan(scanValues...); err != nil {
+			return nil, fmt.Errorf("failed to get result: [%v]", err)
+		}
+
+		if v := scanValues[idx].(*sql.NullString); v.Valid {
+			names = append(names, v.String)
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to scan rows: [%v]", err)
+	}
+
+	return names, nil
+}
@@ -0,0 +1,48 @@
+package snowflake
+
+import "testing"
+
+func TestQuote(t *testing.T) {
+	tests := []struct {
+		quote    func(string) string
+		s        string
+		expected string
+	}{
+		{QuoteIdentifier, "ORDERS", `"ORDERS"`},
+		{QuoteIdentifier, `my"table`, `"my""table"`},
+		{QuoteIdentifier, "a.b c", `"a.b c"`},
+		{QuoteLiteral, "abc", `'abc'`},
+		{QuoteLiteral, "it's", `'it''s'`},
+		{QuoteLiteral, `a\'b`, `'a\\''b'`},
+	}
+
+	for _, tt := range tests {
+		if actual := tt.quote(tt.s); actual != tt.expected {
+			t.Errorf("expected [%s] from [%s], but got [%s]", tt.expected, tt.s, actual)
+		}
+	}
+}
+
+func TestContainsPattern(t *testing.T) {
+	tests := []struct {
+		search   string
+		expected string
+	}{
+		{"", "%%"},
+		{" orders ", "%orders%"},
+		{"my_table", `%my\_table%`},
+		{"50%", `%50\%%`},
+		{`a\b`, `%a\\b%`},
+	}
+
+	for _, tt := range tests {
+		if actual := containsPattern(tt.search); actual != tt.expected {
+			t.Errorf("expected [%s] from [%s], but got [%s]", tt.expected, tt.search, actual)
+		}
+	}
+
+	// The backslashes of the pattern are doubled in the literal of SHOW ... LIKE.
+	if actual := QuoteLiteral(containsPattern("my_db")); actual != `'%my\\_db%'` {
+		t.Errorf("unexpected literal [%s]", actual)
+	}
+}
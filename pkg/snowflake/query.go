@@ -1,6 +1,7 @@
 package snowflake
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 )
@@ -34,3 +35,18 @@ func Select1(db *sql.DB) error {
 
 	return nil
 }
+
+// SetStatementTimeout sets the STATEMENT_TIMEOUT_IN_SECONDS parameter of the session of conn.
+// If seconds is 0, the parameter is unset, so that the session has the timeout of the user or the account.
+func SetStatementTimeout(ctx context.Context, conn *sql.Conn, seconds int) error {
+	query := "ALTER SESSION UNSET STATEMENT_TIMEOUT_IN_SECONDS"
+	if seconds > 0 {
+		query = fmt.Sprintf("ALTER SESSION SET STATEMENT_TIMEOUT_IN_SECONDS = %d", seconds)
+	}
+
+	if _, err := conn.ExecContext(ctx, query); err != nil {
+		return fmt.Errorf("failed to query [%s]: [%v]", query, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,19 @@
+package snowflake
+
+const (
+	// MaxInt64Precision is the number of decimal digits that int64 always holds.
+	// A NUMBER with more digits and scale 0 may overflow int64.
+	MaxInt64Precision = 18
+	// MaxFloat64Precision is the number of significant decimal digits that float64 always keeps.
+	// A NUMBER with more digits and scale > 0 may lose precision in float64.
+	MaxFloat64Precision = 15
+)
+
+// IsExactInFloat64 reports whether every value of NUMBER(precision, scale) is kept in float64 or int64
+// without overflow or loss of precision.
+func IsExactInFloat64(precision int64, scale int64) bool {
+	if scale == 0 {
+		return precision <= MaxInt64Precision
+	}
+	return precision <= MaxFloat64Precision
+}
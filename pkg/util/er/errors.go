@@ -19,6 +19,18 @@ func (e *ErrorMessage) Error() string {
 	return e.err.Error()
 }
 
+func (e *ErrorMessage) Unwrap() error {
+	return e.err
+}
+
+// GetCode returns the error code of err, or 0 if err is not an *ErrorMessage.
+func GetCode(err error) int {
+	if em, ok := err.(*ErrorMessage); ok {
+		return em.code
+	}
+	return 0
+}
+
 func GetMessage(err error) string {
 	if em, ok := err.(*ErrorMessage); !ok {
 		return err.Error()
@@ -35,10 +47,32 @@ func GetMessageF(err error, format string, a ...any) string {
 	}
 }
 
+// GetDetailedMessageF is GetMessageF followed by the error which err wraps, so that the message
+// of the code keeps the specific reason.
+func GetDetailedMessageF(err error, format string, a ...any) string {
+	if em, ok := err.(*ErrorMessage); !ok {
+		return fmt.Sprintf(format, a...)
+	} else {
+		return fmt.Sprintf("%s Reason: %v", errorMessages[em.code], em.err)
+	}
+}
+
 const (
 	ErrMustBeSortedByTime = iota + 1
+	ErrQueryCancelled
+	ErrQueryTimeout
+	ErrTooManyRows
+	ErrNotReadOnly
+	ErrNoUserToken
+	ErrUnlexableQuery
 )
 
 var errorMessages = map[int]string{
 	ErrMustBeSortedByTime: "If \"Data Format\" is timeseries, please set the order by a time-type column. Otherwise, change \"Data Format\" to table.",
+	ErrQueryCancelled:     "The query was cancelled, because the request was cancelled or Grafana stopped waiting for it.",
+	ErrQueryTimeout:       "The query was cancelled, because it ran longer than the query timeout. Narrow the time range or the condition, or raise \"Query Timeout\".",
+	ErrTooManyRows:        "The query returned more rows than the row limit. Narrow the time range or the condition, or aggregate the rows.",
+	ErrNotReadOnly:        "The query was rejected, because the datasource is read-only. Every statement must start with one of \"readOnly.allowedStatements\", and only a multi-statement query may have more than one statement.",
+	ErrUnlexableQuery:     "The query was rejected, because the datasource is read-only and the statements of the query could not be read. Check that every literal, quoted identifier and comment is closed.",
+	ErrNoUserToken:        "The datasource forwards the OAuth identity of the signed-in user, but the request has no OAuth access token. Sign in to Grafana with the OAuth provider of Snowflake. A request without a user, such as of an alert rule, cannot be forwarded.",
 }
@@ -1,7 +1,7 @@
 import React, { ChangeEvent } from 'react'
 import { InlineField, Input, SecretInput, SecretTextArea, Select } from '@grafana/ui'
 import { DataSourcePluginOptionsEditorProps, SelectableValue } from '@grafana/data'
-import { DEFAULT_CONNECTION_POOL, SunflakeDataSourceOptions, SunflakeSecureJsonData } from '../types'
+import { DEFAULT_CONNECTION_POOL, OAuthOptions, SunflakeDataSourceOptions, SunflakeSecureJsonData } from '../types'
 import { NumberInput } from './input/NumberInput'
 
 interface Props extends DataSourcePluginOptionsEditorProps<SunflakeDataSourceOptions> { }
@@ -12,11 +12,19 @@ const INPUT_WIDTH = 34
 export function ConfigEditor(props: Props) {
   const { onOptionsChange, options } = props
   const { jsonData, secureJsonFields } = options
-  const { connPoolOptions = DEFAULT_CONNECTION_POOL } = jsonData
+  const { connPoolOptions = DEFAULT_CONNECTION_POOL, oauth = {} } = jsonData
   const secureJsonData = (options.secureJsonData || {}) as SunflakeSecureJsonData
   const authtypeOptions = [
     { label: "Basic", value: "basic" },
     { label: "Keypair", value: "keypair" },
+    { label: "OAuth", value: "oauth" },
+    { label: "OAuth Pass-Through", value: "oauthPassThru" },
+    { label: "Programmatic Access Token", value: "pat" },
+    { label: "Okta", value: "okta" },
+  ]
+  const grantTypeOptions = [
+    { label: "Client Credentials", value: "client_credentials" },
+    { label: "Refresh Token", value: "refresh_token" },
   ]
 
   const onAccountChange = (event: ChangeEvent<HTMLInputElement>) => {
@@ -34,11 +42,51 @@ export function ConfigEditor(props: Props) {
       ...options,
       jsonData: {
         ...jsonData,
-        authtype: value.value
+        authtype: value.value,
+        // Grafana forwards the OAuth identity of the signed-in user only with oauthPassThru.
+        oauthPassThru: value.value === 'oauthPassThru',
+      }
+    })
+  }
+
+  const onOktaURLChange = (event: ChangeEvent<HTMLInputElement>) => {
+    onOptionsChange({
+      ...options,
+      jsonData: {
+        ...jsonData,
+        oktaUrl: event.target.value,
       }
     })
   }
 
+  const onProgrammaticAccessTokenChange = (event: ChangeEvent<HTMLInputElement>) => {
+    onOptionsChange({
+      ...options,
+      secureJsonFields: {
+        ...options.secureJsonFields,
+        programmaticAccessToken: false,
+      },
+      secureJsonData: {
+        ...options.secureJsonData,
+        programmaticAccessToken: event.target.value,
+      },
+    })
+  }
+
+  const onResetProgrammaticAccessToken = () => {
+    onOptionsChange({
+      ...options,
+      secureJsonFields: {
+        ...options.secureJsonFields,
+        programmaticAccessToken: false,
+      },
+      secureJsonData: {
+        ...options.secureJsonData,
+        programmaticAccessToken: '',
+      },
+    })
+  }
+
   const onUserChange = (event: ChangeEvent<HTMLInputElement>) => {
     onOptionsChange({
       ...options,
@@ -126,6 +174,7 @@ export function ConfigEditor(props: Props) {
         password: false,
       },
       secureJsonData: {
+        ...options.secureJsonData,
         password: '',
         privatekey: event.target.value,
       },
@@ -146,6 +195,61 @@ export function ConfigEditor(props: Props) {
     })
   }
 
+  const onPrivateKeyPassphraseChange = (event: ChangeEvent<HTMLInputElement>) => {
+    onOptionsChange({
+      ...options,
+      secureJsonFields: {
+        ...options.secureJsonFields,
+        privatekeyPassphrase: false,
+      },
+      secureJsonData: {
+        ...options.secureJsonData,
+        privatekeyPassphrase: event.target.value,
+      },
+    })
+  }
+
+  const onResetPrivateKeyPassphrase = () => {
+    onOptionsChange({
+      ...options,
+      secureJsonFields: {
+        ...options.secureJsonFields,
+        privatekeyPassphrase: false,
+      },
+      secureJsonData: {
+        ...options.secureJsonData,
+        privatekeyPassphrase: '',
+      },
+    })
+  }
+
+  const onOAuthChange = (changed: Partial<OAuthOptions>) => {
+    onOptionsChange({
+      ...options,
+      jsonData: {
+        ...jsonData,
+        oauth: {
+          ...oauth,
+          ...changed,
+        }
+      }
+    })
+  }
+
+  const onOAuthSecretChange = (key: 'oauthClientSecret' | 'oauthRefreshToken', value: string) => {
+    onOptionsChange({
+      ...options,
+      secureJsonFields: {
+        ...options.secureJsonFields,
+        [key]: false,
+      },
+      secureJsonData: {
+        ...options.secureJsonData,
+        [key]: value,
+      },
+    })
+  }
+
   const onMaxOpenChange = (n: number) => {
     onOptionsChange({
       ...options,
@@ -226,7 +330,7 @@ export function ConfigEditor(props: Props) {
           width={INPUT_WIDTH}
         />
       </InlineField>
-      {(jsonData.authtype || 'basic') === 'basic' ? (
+      {['basic', 'okta'].includes(jsonData.authtype || 'basic') ? (
         <InlineField label="Password" labelWidth={LABEL_WIDTH}>
           <SecretInput
             isConfigured={(secureJsonFields && secureJsonFields.password) as boolean}
@@ -238,6 +342,28 @@ export function ConfigEditor(props: Props) {
           />
         </InlineField>
       ) : null}
+      {jsonData.authtype === 'okta' ? (
+        <InlineField label="Okta URL" labelWidth={LABEL_WIDTH} tooltip="The URL of the Okta account, which is the SAML identity provider of Snowflake">
+          <Input
+            onChange={onOktaURLChange}
+            value={jsonData.oktaUrl || ''}
+            placeholder="https://example.okta.com"
+            width={INPUT_WIDTH}
+          />
+        </InlineField>
+      ) : null}
+      {jsonData.authtype === 'pat' ? (
+        <InlineField label="Access Token" labelWidth={LABEL_WIDTH} tooltip="The programmatic access token of the user">
+          <SecretInput
+            isConfigured={(secureJsonFields && secureJsonFields.programmaticAccessToken) as boolean}
+            value={secureJsonData.programmaticAccessToken || ''}
+            placeholder="programmatic access token"
+            width={INPUT_WIDTH}
+            onReset={onResetProgrammaticAccessToken}
+            onChange={onProgrammaticAccessTokenChange}
+          />
+        </InlineField>
+      ) : null}
       {jsonData.authtype === 'keypair' ? (
         <InlineField label="Private Key" labelWidth={LABEL_WIDTH}>
           <SecretTextArea
@@ -251,6 +377,84 @@ export function ConfigEditor(props: Props) {
           />
         </InlineField>
       ) : null}
+      {jsonData.authtype === 'keypair' ? (
+        <InlineField label="Private Key Passphrase" labelWidth={LABEL_WIDTH} tooltip="Only for an encrypted private key">
+          <SecretInput
+            isConfigured={(secureJsonFields && secureJsonFields.privatekeyPassphrase) as boolean}
+            value={secureJsonData.privatekeyPassphrase || ''}
+            placeholder="private key passphrase"
+            width={INPUT_WIDTH}
+            onReset={onResetPrivateKeyPassphrase}
+            onChange={onPrivateKeyPassphraseChange}
+          />
+        </InlineField>
+      ) : null}
+      {jsonData.authtype === 'oauth' ? (
+        <>
+          <InlineField label="Token URL" labelWidth={LABEL_WIDTH} tooltip="The token endpoint of the OAuth authorization server">
+            <Input
+              onChange={(e: ChangeEvent<HTMLInputElement>) => onOAuthChange({ tokenUrl: e.target.value })}
+              value={oauth.tokenUrl || ''}
+              placeholder="https://idp.example.com/oauth2/token"
+              width={INPUT_WIDTH}
+            />
+          </InlineField>
+          <InlineField label="Grant Type" labelWidth={LABEL_WIDTH}>
+            <Select
+              options={grantTypeOptions}
+              value={oauth.grantType || 'client_credentials'}
+              onChange={(v: SelectableValue) => onOAuthChange({ grantType: v.value })}
+              width={INPUT_WIDTH}
+            />
+          </InlineField>
+          <InlineField label="Client ID" labelWidth={LABEL_WIDTH}>
+            <Input
+              onChange={(e: ChangeEvent<HTMLInputElement>) => onOAuthChange({ clientId: e.target.value })}
+              value={oauth.clientId || ''}
+              placeholder=""
+              width={INPUT_WIDTH}
+            />
+          </InlineField>
+          <InlineField label="Client Secret" labelWidth={LABEL_WIDTH}>
+            <SecretInput
+              isConfigured={(secureJsonFields && secureJsonFields.oauthClientSecret) as boolean}
+              value={secureJsonData.oauthClientSecret || ''}
+              placeholder="client secret"
+              width={INPUT_WIDTH}
+              onReset={() => onOAuthSecretChange('oauthClientSecret', '')}
+              onChange={(e: ChangeEvent<HTMLInputElement>) => onOAuthSecretChange('oauthClientSecret', e.target.value)}
+            />
+          </InlineField>
+          {oauth.grantType === 'refresh_token' ? (
+            <InlineField label="Refresh Token" labelWidth={LABEL_WIDTH}>
+              <SecretInput
+                isConfigured={(secureJsonFields && secureJsonFields.oauthRefreshToken) as boolean}
+                value={secureJsonData.oauthRefreshToken || ''}
+                placeholder="refresh token"
+                width={INPUT_WIDTH}
+                onReset={() => onOAuthSecretChange('oauthRefreshToken', '')}
+                onChange={(e: ChangeEvent<HTMLInputElement>) => onOAuthSecretChange('oauthRefreshToken', e.target.value)}
+              />
+            </InlineField>
+          ) : null}
+          <InlineField label="Scope" labelWidth={LABEL_WIDTH} tooltip="Space separated scopes, such as session:role:ANALYST">
+            <Input
+              onChange={(e: ChangeEvent<HTMLInputElement>) => onOAuthChange({ scope: e.target.value })}
+              value={oauth.scope || ''}
+              placeholder=""
+              width={INPUT_WIDTH}
+            />
+          </InlineField>
+          <InlineField label="Refresh Before" labelWidth={LABEL_WIDTH} tooltip="How long before its expiry an access token is refreshed, in seconds">
+            <NumberInput
+              value={oauth.refreshBefore ?? 60}
+              defaultValue={60}
+              onChange={(n: number) => onOAuthChange({ refreshBefore: n })}
+              width={INPUT_WIDTH}
+            />
+          </InlineField>
+        </>
+      ) : null}
       <br />
       <h4>Environment</h4>
       <InlineField label="Role" labelWidth={LABEL_WIDTH}>
@@ -12,7 +12,7 @@ import {
   Widgets
 } from '@react-awesome-query-builder/ui';
 import { List } from 'immutable';
-import { isString } from 'lodash';
+import { isString, mapValues, pick } from 'lodash';
 import React from 'react';
 
 import { dateTime, toOption } from '@grafana/data';
@@ -191,6 +191,32 @@ const enum Op {
   NOT_IN = 'select_not_any_in',
   MACROS = 'macros',
 }
+
+// The operators offered by the builder. The backend generates the SQL of the filters, and supports exactly these,
+// see filterOperators in pkg/plugin/builder.go. The other operators of BasicConfig, such as proximity, are not offered.
+export const FILTER_OPERATORS: string[] = [
+  'equal',
+  'not_equal',
+  'less',
+  'less_or_equal',
+  'greater',
+  'greater_or_equal',
+  'like',
+  'not_like',
+  'starts_with',
+  'ends_with',
+  'between',
+  'not_between',
+  'is_empty',
+  'is_not_empty',
+  'is_null',
+  'is_not_null',
+  'select_equals',
+  'select_not_equals',
+  'select_any_in',
+  'select_not_any_in',
+  'macros',
+];
 const customOperators = getCustomOperators(BasicConfig);
 const textWidget = BasicConfig.types.text.widgets.text;
 const opers = [...(textWidget.operators || []), Op.IN, Op.NOT_IN];
@@ -227,8 +253,8 @@ export const raqbConfig: Config = {
   ...BasicConfig,
   widgets,
   settings,
-  operators: customOperators,
-  types: customTypes,
+  operators: pick(customOperators, FILTER_OPERATORS),
+  types: withFilterOperators(customTypes),
 };
 
 export type { Config };
@@ -327,6 +353,19 @@ function getCustomOperators(config: BasicConfig) {
   return customOperators;
 }
 
+// Removes the operators which are not in FILTER_OPERATORS from the widgets of the types.
+function withFilterOperators(types: typeof customTypes): typeof customTypes {
+  return mapValues(types, (type: any) => ({
+    ...type,
+    widgets:
+      type.widgets &&
+      mapValues(type.widgets, (widget: any) => ({
+        ...widget,
+        operators: widget.operators?.filter((op: string) => FILTER_OPERATORS.includes(op)),
+      })),
+  })) as typeof customTypes;
+}
+
 // value: string | List<string> but AQB uses a different version of Immutable
 function splitIfString(value: any) {
   if (isString(value)) {
@@ -25,7 +25,15 @@ import { DataQuery } from '@grafana/schema';
 import { uniqBy } from 'lodash';
 import { lastValueFrom } from 'rxjs';
 import { map } from 'rxjs/operators';
-import { DEFAULT_STATE, DataFormat, SunflakeDataSourceOptions, SunflakeState, TableColumn } from './types';
+import {
+  DEFAULT_STATE,
+  DataFormat,
+  QueryParameter,
+  ResourceResponse,
+  SunflakeDataSourceOptions,
+  SunflakeState,
+  TableColumn,
+} from './types';
 
 export class DataSource extends DataSourceWithBackend<SunflakeState, SunflakeDataSourceOptions> {
   constructor(
@@ -150,9 +158,17 @@ export class DataSource extends DataSourceWithBackend<SunflakeState, SunflakeDat
     const sql = query.queryText.replace(/IN \('\$(\w+)'\)/g, 'IN ($$$1)');
     const queryText = getTemplateSrv().replace(sql, scopedVars, this.interpolateVariable);
 
+    // The backend generates the SQL of the builder mode and quotes the values of the filters by itself,
+    // so the variables in the filters are replaced without quotes. Multiple values are separated by commas.
+    const replace = (value: string) => getTemplateSrv().replace(value, scopedVars, 'csv');
+    const { queryBuilder, timeSeries, parameters } = query;
+
     return {
       ...query,
       queryText,
+      parameters: parameters?.map((parameter) => interpolateParameter(parameter, scopedVars)),
+      queryBuilder: queryBuilder && { ...queryBuilder, whereJsonTree: interpolateTree(queryBuilder.whereJsonTree, replace) },
+      timeSeries: timeSeries && { ...timeSeries, filterJsonTree: interpolateTree(timeSeries.filterJsonTree, replace) },
     };
   }
 
@@ -170,8 +186,7 @@ export class DataSource extends DataSourceWithBackend<SunflakeState, SunflakeDat
   }
 
   async getSnowflakeDatabases(searchWord?: string): Promise<string[]> {
-    const sql = searchWord && searchWord.trim() ? `SHOW DATABASES LIKE '%${searchWord}%'` : `SHOW DATABASES`;
-    return await this.getStringsFromQueryResult(sql, 'databases', 'name');
+    return await this.getResourceItems<string>('databases', { search: searchWord });
   }
 
   async getSnowflakeSchemas(database?: string, searchWord?: string): Promise<string[]> {
@@ -179,12 +194,7 @@ export class DataSource extends DataSourceWithBackend<SunflakeState, SunflakeDat
       return [];
     }
 
-    let sql = `SHOW SCHEMAS IN DATABASE ${database}`;
-    if (searchWord && searchWord.trim()) {
-      sql = sql + ` LIKE '%${searchWord}%'`;
-    }
-
-    return await this.getStringsFromQueryResult(sql, 'schemas', 'name');
+    return await this.getResourceItems<string>('schemas', { database, search: searchWord });
   }
 
   async getSnowflakeTables(database?: string, schema?: string, searchWord?: string): Promise<string[]> {
@@ -192,13 +202,7 @@ export class DataSource extends DataSourceWithBackend<SunflakeState, SunflakeDat
       return [];
     }
 
-    let sql = `SELECT table_name FROM ${database.toLowerCase()}.information_schema.tables WHERE table_schema = '${schema}'`;
-    if (searchWord && searchWord.trim()) {
-      sql = sql + ` AND table_name LIKE '%${searchWord}%'`;
-    }
-    sql = sql + ' ORDER BY table_name';
-
-    return await this.getStringsFromQueryResult(sql, 'tables', 'TABLE_NAME');
+    return await this.getResourceItems<string>('tables', { database, schema, search: searchWord });
   }
 
   async getSnowflakeColumns(
@@ -211,41 +215,83 @@ export class DataSource extends DataSourceWithBackend<SunflakeState, SunflakeDat
       return [];
     }
 
-    let sql = `SELECT column_name AS "name", data_type AS "type" FROM ${database.toLowerCase()}.information_schema.columns WHERE table_schema = '${schema}' AND table_name = '${table}'`;
-    if (searchWord && searchWord.trim()) {
-      sql = sql + ` AND column_name LIKE '%${searchWord}%'`;
-    }
-    sql = sql + ' ORDER BY column_name';
+    return await this.getResourceItems<TableColumn>('columns', { database, schema, table, search: searchWord });
+  }
+
+  // Requests the pages of a resource until all of its items are read.
+  async getResourceItems<T>(path: string, params: Record<string, string | undefined>): Promise<T[]> {
+    const query = Object.fromEntries(Object.entries(params).filter(([_, v]) => v && v.trim()));
+    const items: T[] = [];
+    let total = 0;
+
+    do {
+      const response = await this.getResource<ResourceResponse<T>>(path, { ...query, offset: items.length });
+      const page = response.items ?? [];
+      if (page.length === 0) {
+        break;
+      }
+
+      items.push(...page);
+      total = response.total;
+    } while (items.length < total);
 
-    return await this.getRowsFromQueryResult<TableColumn>(sql, 'columns');
+    return items;
   }
+}
 
-  async getStringsFromQueryResult(sql: string, refId: string, col: string): Promise<string[]> {
-    const view = await this.runSql(sql, refId);
-    const nameIndex = view.dataFrame.fields.findIndex((field) => field.name === col);
-    const names = view.map((row) => {
-      return row[nameIndex];
-    });
+// A parameter whose value is a multi-value variable becomes the list of its values, which are bound one by one.
+function interpolateParameter(parameter: QueryParameter, scopedVars: ScopedVars): QueryParameter {
+  const { value } = parameter;
+  if (typeof value !== 'string') {
+    return parameter;
+  }
+
+  let values: string[] | undefined;
+  const replaced = getTemplateSrv().replace(value, scopedVars, (v: string | string[]) => {
+    if (Array.isArray(v)) {
+      values = v;
+      return v.join(',');
+    }
+    return v;
+  });
 
-    return names;
+  return { ...parameter, value: values && values.join(',') === replaced ? values : replaced };
+}
+
+function interpolateTree(tree: any, replace: (value: string) => string): any {
+  if (!tree) {
+    return tree;
   }
 
-  async getRowsFromQueryResult<T>(sql: string, refId: string): Promise<T[]> {
-    const view = await this.runSql(sql, refId);
-    const fieldNames = view.dataFrame.fields.reduce((names, field) => {
-      return [...names, field.name];
-    }, [] as string[]);
-    const rows = view.map((vs) => {
-      const row = fieldNames.reduce((row, fieldName, i) => {
-        return {
-          ...row,
-          [fieldName]: vs[i],
-        };
-      }, {} as T);
+  const { properties = {}, children1 } = tree;
+  const value = Array.isArray(properties.value)
+    ? properties.value.map((v: any) => interpolateValue(v, replace))
+    : properties.value;
+  const children = Array.isArray(children1)
+    ? children1.map((child) => interpolateTree(child, replace))
+    : children1 &&
+      Object.fromEntries(Object.entries(children1).map(([id, child]) => [id, interpolateTree(child, replace)]));
 
-      return row;
-    });
+  return { ...tree, properties: { ...properties, value }, children1: children };
+}
 
-    return rows;
+// The values of "any in" are a list, in which a variable with multiple values becomes each of its values.
+function interpolateValue(value: any, replace: (value: string) => string): any {
+  if (typeof value === 'string') {
+    return replace(value);
   }
+  if (!Array.isArray(value)) {
+    return value;
+  }
+
+  const values: any[] = [];
+  value.forEach((v: any) => {
+    const replaced = typeof v === 'string' ? replace(v) : interpolateValue(v, replace);
+    if (typeof v === 'string' && replaced !== v) {
+      values.push(...replaced.split(','));
+    } else {
+      values.push(replaced);
+    }
+  });
+  return values;
 }
@@ -19,6 +19,19 @@ export interface SunflakeState extends DataQuery {
   queryBuilder?: QueryBuilder
   timeSeries?: TimeSeries
   snowflakeObject?: SnowflakeObject
+  queryTimeout?: number
+  maxRows?: number
+  failOnMaxRows?: boolean
+  flattenJSON?: boolean
+  fill?: Record<string, string>
+  multiStatement?: 'all' | 'last'
+  parameters?: QueryParameter[]
+}
+
+export interface QueryParameter {
+  name: string
+  type?: 'text' | 'number' | 'boolean' | 'date' | 'timestamp'
+  value: string | number | boolean | Array<string | number | boolean>
 }
 
 export interface QueryBuilder {
@@ -47,6 +60,13 @@ export interface TableColumn {
   type: string
 }
 
+export interface ResourceResponse<T> {
+  items: T[]
+  total: number
+  offset: number
+  limit: number
+}
+
 export interface SelectColumn {
   column?: TableColumn
   alias?: string
@@ -97,6 +117,8 @@ export const FillMissing = {
   Zero: '0',
   None: 'null',
   Previous: 'previous',
+  Next: 'next',
+  Linear: 'linear',
 }
 
 export interface MetricColumn {
@@ -130,12 +152,30 @@ export const DEFAULT_COLUMN_LIST: TableColumn[] = [] as TableColumn[]
 export interface SunflakeDataSourceOptions extends DataSourceJsonData {
   account?: string
   authtype?: string
+  oktaUrl?: string
   user?: string
   role?: string
   database?: string
   schema?: string
   warehouse?: string
+  queryTimeout?: number
+  maxRows?: number
+  failOnMaxRows?: boolean
+  arrowFetch?: boolean
+  timezone?: string
+  weekStart?: string
+  macros?: CustomMacro[]
   connPoolOptions?: ConnectionPoolOptions
+  metadataCache?: MetadataCacheOptions
+  resultCache?: ResultCacheOptions
+  readOnly?: ReadOnlyOptions
+  oauth?: OAuthOptions
+}
+
+export interface CustomMacro {
+  name: string
+  args?: string[]
+  sql: string
 }
 
 export interface ConnectionPoolOptions {
@@ -145,6 +185,30 @@ export interface ConnectionPoolOptions {
   maxLifetime: number
 }
 
+export interface MetadataCacheOptions {
+  ttl: number
+  maxEntries: number
+}
+
+export interface ResultCacheOptions {
+  enabled: boolean
+  ttl: number
+  maxMemoryMB: number
+}
+
+export interface ReadOnlyOptions {
+  enabled: boolean
+  allowedStatements?: string[]
+}
+
+export interface OAuthOptions {
+  tokenUrl?: string
+  grantType?: 'client_credentials' | 'refresh_token'
+  clientId?: string
+  scope?: string
+  refreshBefore?: number
+}
+
 export const DEFAULT_CONNECTION_POOL: ConnectionPoolOptions = {
   maxOpen: 100,
   maxIdle: 2,
@@ -163,4 +227,8 @@ export const DEFAULT_METRIC_COLUMN: MetricColumn = {
 export interface SunflakeSecureJsonData {
   password: string
   privatekey: string
+  privatekeyPassphrase?: string
+  oauthClientSecret?: string
+  oauthRefreshToken?: string
+  programmaticAccessToken?: string
 }